@@ -2,7 +2,9 @@ package api
 
 import (
 	"errors"
+	"fmt"
 	"math/big"
+	"strings"
 
 	rhpv3 "go.sia.tech/core/rhp/v3"
 	"go.sia.tech/core/types"
@@ -41,8 +43,113 @@ type (
 		// host before it can be used again.
 		RequiresSync bool `json:"requiresSync"`
 	}
+
+	// AccountKey uniquely identifies an account by its ID and the host it was
+	// created with. It implements encoding.TextMarshaler so it can be used as
+	// a map key in JSON and as a log field.
+	AccountKey struct {
+		ID      rhpv3.Account
+		HostKey types.PublicKey
+	}
 )
 
+// Clone returns a deep copy of the account. Balance and Drift are pointers,
+// callers that mutate them should clone the account first since the original
+// might be shared between goroutines.
+func (a Account) Clone() Account {
+	if a.Balance != nil {
+		a.Balance = new(big.Int).Set(a.Balance)
+	}
+	if a.Drift != nil {
+		a.Drift = new(big.Int).Set(a.Drift)
+	}
+	return a
+}
+
+// DriftSign returns -1 if the account's drift is negative, 0 if it's zero and
+// +1 if it's positive. A negative drift means the host reported a lower
+// balance than the one tracked by the bus.
+func (a Account) DriftSign() int {
+	if a.Drift == nil {
+		return 0
+	}
+	return a.Drift.Sign()
+}
+
+// FundingNeeded returns the amount that has to be deposited into the account
+// for its balance to reach the given target, or zero if the balance is already
+// at or above it. A nil balance is treated as zero, a negative balance
+// requires more than the target to be deposited.
+func (a Account) FundingNeeded(target types.Currency) types.Currency {
+	balance := a.Balance
+	if balance == nil {
+		balance = new(big.Int)
+	}
+	needed := new(big.Int).Sub(target.Big(), balance)
+	if needed.Sign() <= 0 {
+		return types.ZeroCurrency
+	}
+	return types.NewCurrency(needed.Uint64(), new(big.Int).Rsh(needed, 64).Uint64())
+}
+
+// IsOverdrawn returns true if the account's balance is negative, which
+// indicates the renter and the host disagree on the balance.
+func (a Account) IsOverdrawn() bool {
+	return a.Balance != nil && a.Balance.Sign() < 0
+}
+
+// Key returns the key that identifies the account.
+func (a Account) Key() AccountKey {
+	return AccountKey{ID: a.ID, HostKey: a.HostKey}
+}
+
+// String implements the fmt.Stringer interface.
+func (a Account) String() string { return a.Key().String() }
+
+// SumBalances returns the total balance of the given accounts and their total
+// balance per host. A nil balance is treated as zero and negative balances are
+// included as is. The returned values are newly allocated, so they can be
+// modified without affecting the accounts.
+func SumBalances(accounts []Account) (total *big.Int, perHost map[types.PublicKey]*big.Int) {
+	total = new(big.Int)
+	perHost = make(map[types.PublicKey]*big.Int)
+	for _, acc := range accounts {
+		sum, ok := perHost[acc.HostKey]
+		if !ok {
+			sum = new(big.Int)
+			perHost[acc.HostKey] = sum
+		}
+		if acc.Balance != nil {
+			sum.Add(sum, acc.Balance)
+			total.Add(total, acc.Balance)
+		}
+	}
+	return
+}
+
+// String implements the fmt.Stringer interface.
+func (k AccountKey) String() string {
+	return fmt.Sprintf("%v@%v", k.ID, k.HostKey)
+}
+
+// MarshalText implements the encoding.TextMarshaler interface.
+func (k AccountKey) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (k *AccountKey) UnmarshalText(b []byte) error {
+	id, hk, ok := strings.Cut(string(b), "@")
+	if !ok {
+		return fmt.Errorf("invalid account key %q: missing host key", string(b))
+	} else if err := k.ID.UnmarshalText([]byte(id)); err != nil {
+		return fmt.Errorf("invalid account key %q: %w", string(b), err)
+	} else if err := k.HostKey.UnmarshalText([]byte(hk)); err != nil {
+		return fmt.Errorf("invalid account key %q: %w", string(b), err)
+	}
+	return nil
+}
+
 type (
 	// AccountsAddBalanceRequest is the request type for /account/:id/add
 	// endpoint.
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"encoding/json"
+	"math/big"
+	"sync"
+	"testing"
+
+	rhpv3 "go.sia.tech/core/rhp/v3"
+	"go.sia.tech/core/types"
+)
+
+func TestAccountKey(t *testing.T) {
+	acc := Account{
+		ID:      rhpv3.Account(types.GeneratePrivateKey().PublicKey()),
+		HostKey: types.GeneratePrivateKey().PublicKey(),
+	}
+
+	// assert the key can be used as a map key in JSON
+	m := map[AccountKey]int{acc.Key(): 1}
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[AccountKey]int
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	} else if decoded[acc.Key()] != 1 {
+		t.Fatal("unexpected map", decoded)
+	}
+
+	// assert the account is printed using its key
+	if acc.String() != acc.ID.String()+"@"+acc.HostKey.String() {
+		t.Fatal("unexpected string", acc.String())
+	}
+
+	// assert invalid keys are rejected
+	var k AccountKey
+	if err := k.UnmarshalText([]byte(acc.ID.String())); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestAccountOverdrawn(t *testing.T) {
+	var acc Account
+	if acc.IsOverdrawn() || acc.DriftSign() != 0 {
+		t.Fatal("unexpected zero account", acc.IsOverdrawn(), acc.DriftSign())
+	}
+
+	acc.Balance = big.NewInt(-1)
+	acc.Drift = big.NewInt(-1)
+	if !acc.IsOverdrawn() {
+		t.Fatal("expected account to be overdrawn")
+	} else if acc.DriftSign() != -1 {
+		t.Fatal("unexpected drift sign", acc.DriftSign())
+	}
+
+	acc.Balance = big.NewInt(0)
+	acc.Drift = big.NewInt(1)
+	if acc.IsOverdrawn() {
+		t.Fatal("expected account to not be overdrawn")
+	} else if acc.DriftSign() != 1 {
+		t.Fatal("unexpected drift sign", acc.DriftSign())
+	}
+}
+
+func TestAccountClone(t *testing.T) {
+	acc := Account{
+		ID:      rhpv3.Account(types.GeneratePrivateKey().PublicKey()),
+		HostKey: types.GeneratePrivateKey().PublicKey(),
+		Balance: big.NewInt(10),
+		Drift:   big.NewInt(-1),
+	}
+
+	// mutate clones concurrently while reading the original, the race
+	// detector catches clones that share memory with the original
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			clone := acc.Clone()
+			clone.Balance.Add(clone.Balance, big.NewInt(1))
+			clone.Drift.Sub(clone.Drift, big.NewInt(1))
+		}()
+		_ = acc.Balance.String() + acc.Drift.String()
+	}
+	wg.Wait()
+
+	// assert the original is unchanged
+	if acc.Balance.Cmp(big.NewInt(10)) != 0 || acc.Drift.Cmp(big.NewInt(-1)) != 0 {
+		t.Fatal("original was modified", acc.Balance, acc.Drift)
+	}
+
+	// assert accounts without a balance or drift can be cloned
+	if clone := (Account{}).Clone(); clone.Balance != nil || clone.Drift != nil {
+		t.Fatal("unexpected clone", clone)
+	}
+}
+
+func TestAccountFundingNeeded(t *testing.T) {
+	target := types.Siacoins(1)
+	tests := []struct {
+		balance *big.Int
+		want    types.Currency
+	}{
+		{nil, target},
+		{big.NewInt(0), target},
+		{types.Siacoins(1).Div64(4).Big(), types.Siacoins(3).Div64(4)},
+		{target.Big(), types.ZeroCurrency},
+		{types.Siacoins(2).Big(), types.ZeroCurrency},
+		{big.NewInt(-1), target.Add(types.NewCurrency64(1))},
+	}
+	for _, test := range tests {
+		acc := Account{Balance: test.balance}
+		if got := acc.FundingNeeded(target); !got.Equals(test.want) {
+			t.Fatalf("balance %v: expected %v, got %v", test.balance, test.want, got)
+		}
+	}
+}
+
+func TestSumBalances(t *testing.T) {
+	hk1, hk2, hk3 := types.PublicKey{1}, types.PublicKey{2}, types.PublicKey{3}
+	accounts := []Account{
+		{HostKey: hk1, Balance: big.NewInt(10)},
+		{HostKey: hk1, Balance: big.NewInt(5)},
+		{HostKey: hk2, Balance: big.NewInt(-3)},
+		{HostKey: hk2, Balance: nil},
+		{HostKey: hk3, Balance: nil},
+	}
+
+	total, perHost := SumBalances(accounts)
+	if total.Cmp(big.NewInt(12)) != 0 {
+		t.Fatal("unexpected total", total)
+	} else if len(perHost) != 3 {
+		t.Fatal("unexpected number of hosts", len(perHost))
+	} else if perHost[hk1].Cmp(big.NewInt(15)) != 0 {
+		t.Fatal("unexpected balance", perHost[hk1])
+	} else if perHost[hk2].Cmp(big.NewInt(-3)) != 0 {
+		t.Fatal("unexpected balance", perHost[hk2])
+	} else if perHost[hk3].Sign() != 0 {
+		t.Fatal("unexpected balance", perHost[hk3])
+	}
+
+	// modifying the sums doesn't affect the accounts
+	perHost[hk1].SetInt64(0)
+	total.SetInt64(0)
+	if accounts[0].Balance.Cmp(big.NewInt(10)) != 0 {
+		t.Fatal("account balance was modified", accounts[0].Balance)
+	}
+
+	// no accounts sum to zero
+	if total, perHost := SumBalances(nil); total.Sign() != 0 || len(perHost) != 0 {
+		t.Fatal("unexpected sums", total, perHost)
+	}
+}
@@ -64,6 +64,20 @@ type (
 	}
 )
 
+// Clone returns a deep copy of the config. ScoreOverrides is a map, callers
+// that mutate it should clone the config first since the original might be
+// shared between goroutines.
+func (cfg AutopilotConfig) Clone() AutopilotConfig {
+	if cfg.Hosts.ScoreOverrides != nil {
+		overrides := make(map[types.PublicKey]float64, len(cfg.Hosts.ScoreOverrides))
+		for hk, score := range cfg.Hosts.ScoreOverrides {
+			overrides[hk] = score
+		}
+		cfg.Hosts.ScoreOverrides = overrides
+	}
+	return cfg
+}
+
 // EndHeight of a contract formed using the AutopilotConfig given the current
 // period.
 func (ap *Autopilot) EndHeight() uint64 {
@@ -2,6 +2,7 @@ package api
 
 import (
 	"reflect"
+	"sync"
 	"testing"
 
 	"go.sia.tech/core/types"
@@ -59,3 +60,37 @@ func TestSortContractsForMaintenance(t *testing.T) {
 		t.Fatal("unexpected sort order")
 	}
 }
+
+func TestAutopilotConfigClone(t *testing.T) {
+	hk := types.GeneratePrivateKey().PublicKey()
+	cfg := AutopilotConfig{
+		Hosts: HostsConfig{
+			ScoreOverrides: map[types.PublicKey]float64{hk: 1},
+		},
+	}
+
+	// mutate clones concurrently while reading the original, the race
+	// detector catches clones that share memory with the original
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			clone := cfg.Clone()
+			clone.Hosts.ScoreOverrides[hk] = 0
+			clone.Hosts.ScoreOverrides[types.PublicKey{1}] = 0.5
+		}()
+		_ = cfg.Hosts.ScoreOverrides[hk]
+	}
+	wg.Wait()
+
+	// assert the original is unchanged
+	if len(cfg.Hosts.ScoreOverrides) != 1 || cfg.Hosts.ScoreOverrides[hk] != 1 {
+		t.Fatal("original was modified", cfg.Hosts.ScoreOverrides)
+	}
+
+	// assert configs without overrides can be cloned
+	if clone := (AutopilotConfig{}).Clone(); clone.Hosts.ScoreOverrides != nil {
+		t.Fatal("unexpected clone", clone)
+	}
+}
@@ -211,6 +211,9 @@ type (
 	}
 )
 
+// String implements the fmt.Stringer interface.
+func (s ContractState) String() string { return string(s) }
+
 // Add returns the sum of the current and given contract spending.
 func (x ContractSpending) Add(y ContractSpending) (z ContractSpending) {
 	z.Uploads = x.Uploads.Add(y.Uploads)
@@ -22,6 +22,13 @@ const (
 	EventDelete  = "delete"
 	EventArchive = "archive"
 	EventRenew   = "renew"
+
+	EventActive   = "active"
+	EventComplete = "complete"
+	EventFailed   = "failed"
+
+	EventSynced  = "synced"
+	EventSyncing = "syncing"
 )
 
 var (
@@ -35,6 +42,15 @@ type (
 		Timestamp      time.Time      `json:"timestamp"`
 	}
 
+	// EventConsensusSyncState is the payload of the 'synced' and 'syncing'
+	// consensus events, it contains the height the node is synced to and the
+	// height of the chain's tip.
+	EventConsensusSyncState struct {
+		BlockHeight uint64    `json:"blockHeight"`
+		TipHeight   uint64    `json:"tipHeight"`
+		Timestamp   time.Time `json:"timestamp"`
+	}
+
 	EventContractAdd struct {
 		Added     ContractMetadata `json:"added"`
 		Timestamp time.Time        `json:"timestamp"`
@@ -51,10 +67,19 @@ type (
 		Timestamp time.Time        `json:"timestamp"`
 	}
 
+	EventContractStateUpdate struct {
+		ContractID     types.FileContractID `json:"contractID"`
+		State          ContractState        `json:"state"`
+		RevisionNumber uint64               `json:"revisionNumber"`
+		FileSize       uint64               `json:"fileSize"`
+		Timestamp      time.Time            `json:"timestamp"`
+	}
+
 	EventHostUpdate struct {
-		HostKey   types.PublicKey `json:"hostKey"`
-		NetAddr   string          `json:"netAddr"`
-		Timestamp time.Time       `json:"timestamp"`
+		HostKey     types.PublicKey `json:"hostKey"`
+		NetAddr     string          `json:"netAddr"`
+		BlockHeight uint64          `json:"blockHeight"`
+		Timestamp   time.Time       `json:"timestamp"`
 	}
 
 	EventContractSetUpdate struct {
@@ -85,6 +110,24 @@ var (
 		}
 	}
 
+	WebhookConsensusSynced = func(url string, headers map[string]string) webhooks.Webhook {
+		return webhooks.Webhook{
+			Event:   EventSynced,
+			Headers: headers,
+			Module:  ModuleConsensus,
+			URL:     url,
+		}
+	}
+
+	WebhookConsensusSyncing = func(url string, headers map[string]string) webhooks.Webhook {
+		return webhooks.Webhook{
+			Event:   EventSyncing,
+			Headers: headers,
+			Module:  ModuleConsensus,
+			URL:     url,
+		}
+	}
+
 	WebhookContractAdd = func(url string, headers map[string]string) webhooks.Webhook {
 		return webhooks.Webhook{
 			Event:   EventAdd,
@@ -149,6 +192,174 @@ var (
 	}
 )
 
+// NewConsensusUpdateEvent returns the event that is broadcasted when a new
+// block is applied while the node is synced.
+func NewConsensusUpdateEvent(cs ConsensusState, fee types.Currency, timestamp time.Time) webhooks.Event {
+	return webhooks.Event{
+		Module: ModuleConsensus,
+		Event:  EventUpdate,
+		Payload: EventConsensusUpdate{
+			ConsensusState: cs,
+			TransactionFee: fee,
+			Timestamp:      timestamp.UTC(),
+		},
+	}
+}
+
+// NewConsensusSyncedEvent returns the event that is broadcasted when the node
+// caught up with the tip of the chain.
+func NewConsensusSyncedEvent(height uint64, timestamp time.Time) webhooks.Event {
+	return webhooks.Event{
+		Module: ModuleConsensus,
+		Event:  EventSynced,
+		Payload: EventConsensusSyncState{
+			BlockHeight: height,
+			TipHeight:   height,
+			Timestamp:   timestamp.UTC(),
+		},
+	}
+}
+
+// NewConsensusSyncingEvent returns the event that is broadcasted when the node
+// fell behind the tip of the chain after it was synced.
+func NewConsensusSyncingEvent(height, tipHeight uint64, timestamp time.Time) webhooks.Event {
+	return webhooks.Event{
+		Module: ModuleConsensus,
+		Event:  EventSyncing,
+		Payload: EventConsensusSyncState{
+			BlockHeight: height,
+			TipHeight:   tipHeight,
+			Timestamp:   timestamp.UTC(),
+		},
+	}
+}
+
+// NewContractAddEvent returns the event that is broadcasted when a contract is
+// added.
+func NewContractAddEvent(added ContractMetadata, timestamp time.Time) webhooks.Event {
+	return webhooks.Event{
+		Module: ModuleContract,
+		Event:  EventAdd,
+		Payload: EventContractAdd{
+			Added:     added,
+			Timestamp: timestamp.UTC(),
+		},
+	}
+}
+
+// NewContractArchiveEvent returns the event that is broadcasted when a
+// contract is archived.
+func NewContractArchiveEvent(fcid types.FileContractID, reason string, timestamp time.Time) webhooks.Event {
+	return webhooks.Event{
+		Module: ModuleContract,
+		Event:  EventArchive,
+		Payload: EventContractArchive{
+			ContractID: fcid,
+			Reason:     reason,
+			Timestamp:  timestamp.UTC(),
+		},
+	}
+}
+
+// NewContractRenewEvent returns the event that is broadcasted when a contract
+// is renewed.
+func NewContractRenewEvent(renewal ContractMetadata, timestamp time.Time) webhooks.Event {
+	return webhooks.Event{
+		Module: ModuleContract,
+		Event:  EventRenew,
+		Payload: EventContractRenew{
+			Renewal:   renewal,
+			Timestamp: timestamp.UTC(),
+		},
+	}
+}
+
+// NewContractStateEvent returns the event that is broadcasted when a contract
+// transitions to the given state, the revision number and file size are the
+// ones of the contract in that state. Only the 'active', 'complete' and 'failed'
+// states have a corresponding event, ErrUnknownEvent is returned for all other
+// states.
+func NewContractStateEvent(fcid types.FileContractID, state ContractState, revisionNumber, fileSize uint64, timestamp time.Time) (webhooks.Event, error) {
+	var event string
+	switch state {
+	case ContractStateActive:
+		event = EventActive
+	case ContractStateComplete:
+		event = EventComplete
+	case ContractStateFailed:
+		event = EventFailed
+	default:
+		return webhooks.Event{}, fmt.Errorf("%w: no event for contract state %v", ErrUnknownEvent, state)
+	}
+	return webhooks.Event{
+		Module: ModuleContract,
+		Event:  event,
+		Payload: EventContractStateUpdate{
+			ContractID:     fcid,
+			State:          state,
+			RevisionNumber: revisionNumber,
+			FileSize:       fileSize,
+			Timestamp:      timestamp.UTC(),
+		},
+	}, nil
+}
+
+// NewContractSetUpdateEvent returns the event that is broadcasted when a
+// contract set is updated.
+func NewContractSetUpdateEvent(name string, fcids []types.FileContractID, timestamp time.Time) webhooks.Event {
+	return webhooks.Event{
+		Module: ModuleContractSet,
+		Event:  EventUpdate,
+		Payload: EventContractSetUpdate{
+			Name:        name,
+			ContractIDs: fcids,
+			Timestamp:   timestamp.UTC(),
+		},
+	}
+}
+
+// NewHostUpdateEvent returns the event that is broadcasted when a host
+// announcement is found in the block at the given height.
+func NewHostUpdateEvent(hk types.PublicKey, netAddr string, blockHeight uint64, timestamp time.Time) webhooks.Event {
+	return webhooks.Event{
+		Module: ModuleHost,
+		Event:  EventUpdate,
+		Payload: EventHostUpdate{
+			HostKey:     hk,
+			NetAddr:     netAddr,
+			BlockHeight: blockHeight,
+			Timestamp:   timestamp.UTC(),
+		},
+	}
+}
+
+// NewSettingUpdateEvent returns the event that is broadcasted when a setting
+// is updated.
+func NewSettingUpdateEvent(key string, update interface{}, timestamp time.Time) webhooks.Event {
+	return webhooks.Event{
+		Module: ModuleSetting,
+		Event:  EventUpdate,
+		Payload: EventSettingUpdate{
+			Key:       key,
+			Update:    update,
+			Timestamp: timestamp.UTC(),
+		},
+	}
+}
+
+// NewSettingDeleteEvent returns the event that is broadcasted when a setting
+// is deleted.
+func NewSettingDeleteEvent(key string, timestamp time.Time) webhooks.Event {
+	return webhooks.Event{
+		Module: ModuleSetting,
+		Event:  EventDelete,
+		Payload: EventSettingDelete{
+			Key:       key,
+			Timestamp: timestamp.UTC(),
+		},
+	}
+}
+
 func ParseEventWebhook(event webhooks.Event) (interface{}, error) {
 	bytes, err := json.Marshal(event.Payload)
 	if err != nil {
@@ -175,6 +386,12 @@ func ParseEventWebhook(event webhooks.Event) (interface{}, error) {
 				return nil, err
 			}
 			return e, nil
+		case EventActive, EventComplete, EventFailed:
+			var e EventContractStateUpdate
+			if err := json.Unmarshal(bytes, &e); err != nil {
+				return nil, err
+			}
+			return e, nil
 		}
 	case ModuleContractSet:
 		if event.Event == EventUpdate {
@@ -185,12 +402,19 @@ func ParseEventWebhook(event webhooks.Event) (interface{}, error) {
 			return e, nil
 		}
 	case ModuleConsensus:
-		if event.Event == EventUpdate {
+		switch event.Event {
+		case EventUpdate:
 			var e EventConsensusUpdate
 			if err := json.Unmarshal(bytes, &e); err != nil {
 				return nil, err
 			}
 			return e, nil
+		case EventSynced, EventSyncing:
+			var e EventConsensusSyncState
+			if err := json.Unmarshal(bytes, &e); err != nil {
+				return nil, err
+			}
+			return e, nil
 		}
 	case ModuleHost:
 		if event.Event == EventUpdate {
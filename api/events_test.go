@@ -0,0 +1,64 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/types"
+	"go.sia.tech/renterd/webhooks"
+)
+
+func TestEventConstructors(t *testing.T) {
+	now := time.Now().Round(0)
+
+	contractStateEvent := func(state ContractState) webhooks.Event {
+		t.Helper()
+		event, err := NewContractStateEvent(types.FileContractID{1}, state, 1, 2, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return event
+	}
+
+	events := []webhooks.Event{
+		NewConsensusUpdateEvent(ConsensusState{BlockHeight: 1, Synced: true}, types.Siacoins(1), now),
+		NewConsensusSyncedEvent(1, now),
+		NewConsensusSyncingEvent(1, 2, now),
+		NewContractAddEvent(ContractMetadata{ID: types.FileContractID{1}}, now),
+		NewContractArchiveEvent(types.FileContractID{1}, ContractArchivalReasonRemoved, now),
+		NewContractRenewEvent(ContractMetadata{ID: types.FileContractID{1}}, now),
+		contractStateEvent(ContractStateActive),
+		contractStateEvent(ContractStateComplete),
+		contractStateEvent(ContractStateFailed),
+		NewContractSetUpdateEvent("set", []types.FileContractID{{1}}, now),
+		NewHostUpdateEvent(types.PublicKey{1}, "foo.bar:1234", 1, now),
+		NewSettingUpdateEvent(SettingGouging, "update", now),
+		NewSettingDeleteEvent(SettingGouging, now),
+	}
+
+	// assert every event survives a round trip and parses into its payload
+	for _, event := range events {
+		b, err := json.Marshal(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded webhooks.Event
+		if err := json.Unmarshal(b, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := ParseEventWebhook(decoded)
+		if err != nil {
+			t.Fatal(event, err)
+		} else if reflect.TypeOf(parsed) != reflect.TypeOf(event.Payload) {
+			t.Fatalf("%v: unexpected payload type %T", event, parsed)
+		}
+	}
+
+	// assert states without an event are rejected
+	if _, err := NewContractStateEvent(types.FileContractID{1}, ContractStatePending, 1, 2, now); !errors.Is(err, ErrUnknownEvent) {
+		t.Fatal("unexpected error", err)
+	}
+}
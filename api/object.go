@@ -16,6 +16,15 @@ import (
 const (
 	ObjectMetadataPrefix = "X-Sia-Meta-"
 
+	// ObjectHealthHeader is the header that contains the health of an object
+	// in the responses of the GET and HEAD /worker/object endpoints.
+	ObjectHealthHeader = "X-Renterd-Health"
+
+	// ObjectPartsCountHeader is the header that contains the number of parts
+	// of an object that was created by a multipart upload in the responses of
+	// the GET and HEAD /worker/object endpoints.
+	ObjectPartsCountHeader = "X-Renterd-Parts-Count"
+
 	ObjectsRenameModeSingle = "single"
 	ObjectsRenameModeMulti  = "multi"
 
@@ -65,6 +74,10 @@ type (
 		Name     string      `json:"name"`
 		Size     int64       `json:"size"`
 		MimeType string      `json:"mimeType,omitempty"`
+
+		// PartsCount is the number of parts the object was uploaded in, it
+		// is zero unless the object was created by a multipart upload.
+		PartsCount int `json:"partsCount,omitempty"`
 	}
 
 	// ObjectUserMetadata contains user-defined metadata about an object and can
@@ -93,7 +106,9 @@ type (
 	HeadObjectResponse struct {
 		ContentType  string
 		Etag         string
+		Health       float64
 		LastModified TimeRFC3339
+		PartsCount   int
 		Range        *ContentRange
 		Size         int64
 		Metadata     ObjectUserMetadata
@@ -2,7 +2,36 @@ package api
 
 import "go.sia.tech/renterd/webhooks"
 
-type WebhookResponse struct {
-	Webhooks []webhooks.Webhook          `json:"webhooks"`
-	Queues   []webhooks.WebhookQueueInfo `json:"queues"`
+type (
+	WebhookResponse struct {
+		Webhooks []webhooks.Webhook `json:"webhooks"`
+		Queues   []WebhookQueueInfo `json:"queues"`
+	}
+
+	// WebhookQueueInfo is the API representation of a
+	// webhooks.WebhookQueueInfo, the age of the queue's oldest event is
+	// encoded in milliseconds.
+	WebhookQueueInfo struct {
+		URL             string     `json:"url"`
+		Module          string     `json:"module,omitempty"`
+		Size            int        `json:"size"`
+		DroppedOversize uint64     `json:"droppedOversize"`
+		OldestEventAge  DurationMS `json:"oldestEventAge"`
+	}
+)
+
+// NewWebhookResponse creates the response to a webhooks request from the
+// webhook manager's info.
+func NewWebhookResponse(hooks []webhooks.Webhook, queues []webhooks.WebhookQueueInfo) WebhookResponse {
+	resp := WebhookResponse{Webhooks: hooks}
+	for _, q := range queues {
+		resp.Queues = append(resp.Queues, WebhookQueueInfo{
+			URL:             q.URL,
+			Module:          q.Module,
+			Size:            q.Size,
+			DroppedOversize: q.DroppedOversize,
+			OldestEventAge:  DurationMS(q.OldestEventAge),
+		})
+	}
+	return resp
 }
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"go.sia.tech/renterd/webhooks"
+)
+
+func TestWebhookResponseOldestEventAge(t *testing.T) {
+	resp := NewWebhookResponse(nil, []webhooks.WebhookQueueInfo{{URL: "http://localhost", Size: 1, OldestEventAge: 1500 * time.Millisecond}})
+	if len(resp.Queues) != 1 || resp.Queues[0].OldestEventAge != DurationMS(1500*time.Millisecond) {
+		t.Fatalf("unexpected queues %+v", resp.Queues)
+	}
+
+	// the age is encoded in milliseconds
+	b, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(string(b), `"oldestEventAge":1500`) {
+		t.Fatal("unexpected encoding", string(b))
+	}
+
+	// and decoded again
+	var decoded WebhookResponse
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	} else if decoded.Queues[0].OldestEventAge != resp.Queues[0].OldestEventAge {
+		t.Fatal("unexpected age", decoded.Queues[0].OldestEventAge)
+	}
+}
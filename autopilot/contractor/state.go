@@ -46,7 +46,7 @@ func (ctx *mCtx) Allowance() types.Currency {
 }
 
 func (ctx *mCtx) AutopilotConfig() api.AutopilotConfig {
-	return ctx.state.AP.Config
+	return ctx.state.AP.Config.Clone()
 }
 
 func (ctx *mCtx) ContractsConfig() api.ContractsConfig {
@@ -152,6 +152,7 @@ type (
 		Info() ([]webhooks.Webhook, []webhooks.WebhookQueueInfo)
 		Register(context.Context, webhooks.Webhook) error
 		Shutdown(context.Context) error
+		Test(context.Context, webhooks.Webhook) error
 	}
 
 	// Store is a collection of stores used by the bus.
@@ -363,12 +364,15 @@ func New(ctx context.Context, masterKey [32]byte, am AlertManager, wm WebhooksMa
 	// create sectors cache
 	b.sectors = ibus.NewSectorsCache()
 
+	// create chain subscriber
+	b.cs, err = ibus.NewChainSubscriber(wm, cm, store, w, announcementMaxAge, l)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create chain subscriber: %w", err)
+	}
+
 	// create pin manager
 	b.pinMgr = ibus.NewPinManager(b.alerts, wm, store, defaultPinUpdateInterval, defaultPinRateWindow, l)
 
-	// create chain subscriber
-	b.cs = ibus.NewChainSubscriber(wm, cm, store, w, announcementMaxAge, l)
-
 	// create wallet metrics recorder
 	b.walletMetricsRecorder = ibus.NewWalletMetricRecorder(store, w, defaultWalletRecordMetricInterval, l)
 
@@ -508,6 +512,7 @@ func (b *Bus) Handler() http.Handler {
 		"GET    /webhooks":        b.webhookHandlerGet,
 		"POST   /webhooks":        b.webhookHandlerPost,
 		"POST   /webhooks/action": b.webhookActionHandlerPost,
+		"POST   /webhooks/test":   b.webhookTestHandlerPost,
 		"POST   /webhook/delete":  b.webhookHandlerDelete,
 	})
 }
@@ -528,14 +533,7 @@ func (b *Bus) addContract(ctx context.Context, rev rhpv2.ContractRevision, contr
 		return api.ContractMetadata{}, err
 	}
 
-	b.broadcastAction(webhooks.Event{
-		Module: api.ModuleContract,
-		Event:  api.EventAdd,
-		Payload: api.EventContractAdd{
-			Added:     c,
-			Timestamp: time.Now().UTC(),
-		},
-	})
+	b.broadcastAction(api.NewContractAddEvent(c, time.Now()))
 	return c, nil
 }
 
@@ -546,14 +544,7 @@ func (b *Bus) addRenewedContract(ctx context.Context, renewedFrom types.FileCont
 	}
 
 	b.sectors.HandleRenewal(r.ID, r.RenewedFrom)
-	b.broadcastAction(webhooks.Event{
-		Module: api.ModuleContract,
-		Event:  api.EventRenew,
-		Payload: api.EventContractRenew{
-			Renewal:   r,
-			Timestamp: time.Now().UTC(),
-		},
-	})
+	b.broadcastAction(api.NewContractRenewEvent(r, time.Now()))
 	return r, nil
 }
 
@@ -24,6 +24,12 @@ func (c *Client) RegisterWebhook(ctx context.Context, webhook webhooks.Webhook)
 	return err
 }
 
+// TestWebhook sends a ping to the given webhook without registering it.
+func (c *Client) TestWebhook(ctx context.Context, webhook webhooks.Webhook) error {
+	err := c.c.WithContext(ctx).POST("/webhooks/test", webhook, nil)
+	return err
+}
+
 // Webhooks returns all webhooks currently registered.
 func (c *Client) Webhooks(ctx context.Context) (resp api.WebhookResponse, err error) {
 	err = c.c.WithContext(ctx).GET("/webhooks", &resp)
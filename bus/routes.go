@@ -697,15 +697,7 @@ func (b *Bus) contractsArchiveHandlerPOST(jc jape.Context) {
 
 	if jc.Check("failed to archive contracts", b.ms.ArchiveContracts(jc.Request.Context(), toArchive)) == nil {
 		for fcid, reason := range toArchive {
-			b.broadcastAction(webhooks.Event{
-				Module: api.ModuleContract,
-				Event:  api.EventArchive,
-				Payload: api.EventContractArchive{
-					ContractID: fcid,
-					Reason:     reason,
-					Timestamp:  time.Now().UTC(),
-				},
-			})
+			b.broadcastAction(api.NewContractArchiveEvent(fcid, reason, time.Now()))
 		}
 	}
 }
@@ -727,15 +719,7 @@ func (b *Bus) contractsSetHandlerPUT(jc jape.Context) {
 	} else if jc.Check("could not add contracts to set", b.ms.SetContractSet(jc.Request.Context(), set, contractIds)) != nil {
 		return
 	} else {
-		b.broadcastAction(webhooks.Event{
-			Module: api.ModuleContractSet,
-			Event:  api.EventUpdate,
-			Payload: api.EventContractSetUpdate{
-				Name:        set,
-				ContractIDs: contractIds,
-				Timestamp:   time.Now().UTC(),
-			},
-		})
+		b.broadcastAction(api.NewContractSetUpdateEvent(set, contractIds, time.Now()))
 	}
 }
 
@@ -1536,15 +1520,7 @@ func (b *Bus) settingKeyHandlerPUT(jc jape.Context) {
 	}
 
 	if jc.Check("could not update setting", b.ss.UpdateSetting(jc.Request.Context(), key, string(data))) == nil {
-		b.broadcastAction(webhooks.Event{
-			Module: api.ModuleSetting,
-			Event:  api.EventUpdate,
-			Payload: api.EventSettingUpdate{
-				Key:       key,
-				Update:    value,
-				Timestamp: time.Now().UTC(),
-			},
-		})
+		b.broadcastAction(api.NewSettingUpdateEvent(key, value, time.Now()))
 	}
 }
 
@@ -1556,14 +1532,7 @@ func (b *Bus) settingKeyHandlerDELETE(jc jape.Context) {
 	}
 
 	if jc.Check("could not delete setting", b.ss.DeleteSetting(jc.Request.Context(), key)) == nil {
-		b.broadcastAction(webhooks.Event{
-			Module: api.ModuleSetting,
-			Event:  api.EventDelete,
-			Payload: api.EventSettingDelete{
-				Key:       key,
-				Timestamp: time.Now().UTC(),
-			},
-		})
+		b.broadcastAction(api.NewSettingDeleteEvent(key, time.Now()))
 	}
 }
 
@@ -1899,11 +1868,7 @@ func (b *Bus) webhookHandlerDelete(jc jape.Context) {
 }
 
 func (b *Bus) webhookHandlerGet(jc jape.Context) {
-	webhooks, queueInfos := b.webhooksMgr.Info()
-	jc.Encode(api.WebhookResponse{
-		Queues:   queueInfos,
-		Webhooks: webhooks,
-	})
+	jc.Encode(api.NewWebhookResponse(b.webhooksMgr.Info()))
 }
 
 func (b *Bus) webhookHandlerPost(jc jape.Context) {
@@ -1917,13 +1882,33 @@ func (b *Bus) webhookHandlerPost(jc jape.Context) {
 		Module:  req.Module,
 		URL:     req.URL,
 		Headers: req.Headers,
+		Method:  req.Method,
 	})
-	if err != nil {
+	if errors.Is(err, webhooks.ErrInvalidWebhookURL) || errors.Is(err, webhooks.ErrInvalidWebhookMethod) {
+		jc.Error(err, http.StatusBadRequest)
+		return
+	} else if err != nil {
 		jc.Error(fmt.Errorf("failed to add Webhook: %w", err), http.StatusInternalServerError)
 		return
 	}
 }
 
+func (b *Bus) webhookTestHandlerPost(jc jape.Context) {
+	var req webhooks.Webhook
+	if jc.Decode(&req) != nil {
+		return
+	}
+
+	err := b.webhooksMgr.Test(jc.Request.Context(), req)
+	if errors.Is(err, webhooks.ErrInvalidWebhookURL) || errors.Is(err, webhooks.ErrInvalidWebhookMethod) {
+		jc.Error(err, http.StatusBadRequest)
+		return
+	} else if err != nil {
+		jc.Error(fmt.Errorf("failed to test Webhook: %w", err), http.StatusInternalServerError)
+		return
+	}
+}
+
 func (b *Bus) metricsHandlerDELETE(jc jape.Context) {
 	metric := jc.PathParam("key")
 	if metric == "" {
@@ -128,6 +128,8 @@ func defaultConfig() config.Config {
 			Enabled:     true,
 			DisableAuth: false,
 			KeypairsV4:  nil,
+
+			MultipartSweepInterval: time.Hour,
 		},
 	}
 }
@@ -300,6 +302,7 @@ func parseCLIFlags(cfg *config.Config) {
 	flag.StringVar(&cfg.Database.MySQL.MetricsDatabase, "db.metricsName", cfg.Database.MySQL.MetricsDatabase, "Database for metrics (overrides with RENTERD_DB_METRICS_NAME)")
 
 	// bus
+	flag.BoolVar(&cfg.Bus.AllowInternalWebhookURLs, "bus.allowInternalWebhookURLs", cfg.Bus.AllowInternalWebhookURLs, "Allows registering webhooks with loopback or link-local URLs, e.g. for remote workers on the same machine")
 	flag.Uint64Var(&cfg.Bus.AnnouncementMaxAgeHours, "bus.announcementMaxAgeHours", cfg.Bus.AnnouncementMaxAgeHours, "Max age for announcements")
 	flag.BoolVar(&cfg.Bus.Bootstrap, "bus.bootstrap", cfg.Bus.Bootstrap, "Bootstraps gateway and consensus modules")
 	flag.StringVar(&cfg.Bus.GatewayAddr, "bus.gatewayAddr", cfg.Bus.GatewayAddr, "Address for Sia peer connections (overrides with RENTERD_BUS_GATEWAY_ADDR)")
@@ -339,6 +342,10 @@ func parseCLIFlags(cfg *config.Config) {
 	flag.BoolVar(&cfg.S3.Enabled, "s3.enabled", cfg.S3.Enabled, "Enables/disables S3 API (requires worker.enabled to be 'true', overrides with RENTERD_S3_ENABLED)")
 	flag.StringVar(&hostBasesStr, "s3.hostBases", "", "Enables bucket rewriting in the router for specific hosts provided via comma-separated list (overrides with RENTERD_S3_HOST_BUCKET_BASES)")
 	flag.BoolVar(&cfg.S3.HostBucketEnabled, "s3.hostBucketEnabled", cfg.S3.HostBucketEnabled, "Enables bucket rewriting in the router for all hosts (overrides with RENTERD_S3_HOST_BUCKET_ENABLED)")
+	flag.StringVar(&cfg.S3.Region, "s3.region", cfg.S3.Region, "Region reported for all buckets (overrides with RENTERD_S3_REGION)")
+	flag.Float64Var(&cfg.S3.MinObjectHealth, "s3.minObjectHealth", cfg.S3.MinObjectHealth, "Health below which GETs for objects are rejected, 0 disables it (overrides with RENTERD_S3_MIN_OBJECT_HEALTH)")
+	flag.DurationVar(&cfg.S3.MultipartUploadMaxAge, "s3.multipartUploadMaxAge", cfg.S3.MultipartUploadMaxAge, "Age after which incomplete multipart uploads are aborted, 0 disables it (overrides with RENTERD_S3_MULTIPART_UPLOAD_MAX_AGE)")
+	flag.DurationVar(&cfg.S3.MultipartSweepInterval, "s3.multipartSweepInterval", cfg.S3.MultipartSweepInterval, "Interval at which incomplete multipart uploads are checked (overrides with RENTERD_S3_MULTIPART_SWEEP_INTERVAL)")
 
 	// custom usage
 	flag.Usage = func() {
@@ -394,6 +401,10 @@ func parseEnvironmentVariables(cfg *config.Config) {
 	parseEnvVar("RENTERD_S3_DISABLE_AUTH", &cfg.S3.DisableAuth)
 	parseEnvVar("RENTERD_S3_HOST_BUCKET_ENABLED", &cfg.S3.HostBucketEnabled)
 	parseEnvVar("RENTERD_S3_HOST_BUCKET_BASES", &cfg.S3.HostBucketBases)
+	parseEnvVar("RENTERD_S3_REGION", &cfg.S3.Region)
+	parseEnvVar("RENTERD_S3_MIN_OBJECT_HEALTH", &cfg.S3.MinObjectHealth)
+	parseEnvVar("RENTERD_S3_MULTIPART_UPLOAD_MAX_AGE", &cfg.S3.MultipartUploadMaxAge)
+	parseEnvVar("RENTERD_S3_MULTIPART_SWEEP_INTERVAL", &cfg.S3.MultipartSweepInterval)
 
 	parseEnvVar("RENTERD_LOG_PATH", &cfg.Log.Path)
 	parseEnvVar("RENTERD_LOG_LEVEL", &cfg.Log.Level)
@@ -197,6 +197,11 @@ func newNode(cfg config.Config, network *consensus.Network, genesis types.Block)
 					AuthDisabled:      cfg.S3.DisableAuth,
 					HostBucketBases:   cfg.S3.HostBucketBases,
 					HostBucketEnabled: cfg.S3.HostBucketEnabled,
+					Region:            cfg.S3.Region,
+					MinObjectHealth:   cfg.S3.MinObjectHealth,
+
+					MultipartUploadMaxAge:  cfg.S3.MultipartUploadMaxAge,
+					MultipartSweepInterval: cfg.S3.MultipartSweepInterval,
 				})
 				if err != nil {
 					err = errors.Join(err, w.Shutdown(context.Background()))
@@ -215,6 +220,10 @@ func newNode(cfg config.Config, network *consensus.Network, genesis types.Block)
 					name: "S3",
 					fn:   s3Srv.Shutdown,
 				})
+				shutdownFns = append(shutdownFns, fn{
+					name: "S3 Handler",
+					fn:   s3Handler.Shutdown,
+				})
 			}
 		}
 	} else {
@@ -271,8 +280,12 @@ func newBus(ctx context.Context, cfg config.Config, pk types.PrivateKey, network
 		return nil, nil, err
 	}
 
-	// create webhooks manager
-	wh, err := webhooks.NewManager(sqlStore, logger)
+	// create webhooks manager, the local worker registers webhooks using the
+	// node's own API address so it's always allowed
+	wh, err := webhooks.NewManager(sqlStore, logger,
+		webhooks.WithAllowInternalURLs(cfg.Bus.AllowInternalWebhookURLs),
+		webhooks.WithAllowedURLPrefixes(cfg.HTTP.Address+"/api/worker/"),
+	)
 	if err != nil {
 		return nil, nil, err
 	}
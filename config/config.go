@@ -48,6 +48,7 @@ type (
 
 	// Bus contains the configuration for a bus.
 	Bus struct {
+		AllowInternalWebhookURLs      bool          `yaml:"allowInternalWebhookURLs,omitempty"`
 		AnnouncementMaxAgeHours       uint64        `yaml:"announcementMaxAgeHours,omitempty"`
 		Bootstrap                     bool          `yaml:"bootstrap,omitempty"`
 		GatewayAddr                   string        `yaml:"gatewayAddr,omitempty"`
@@ -110,6 +111,11 @@ type (
 		KeypairsV4        map[string]string `yaml:"keypairsV4,omitempty"` // deprecated. included for compatibility.
 		HostBucketEnabled bool              `yaml:"hostBucketEnabled,omitempty"`
 		HostBucketBases   []string          `yaml:"hostBucketBases,omitempty"`
+		Region            string            `yaml:"region,omitempty"`
+		MinObjectHealth   float64           `yaml:"minObjectHealth,omitempty"`
+
+		MultipartUploadMaxAge  time.Duration `yaml:"multipartUploadMaxAge,omitempty"`
+		MultipartSweepInterval time.Duration `yaml:"multipartSweepInterval,omitempty"`
 	}
 
 	// Worker contains the configuration for a worker.
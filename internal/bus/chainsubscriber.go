@@ -4,7 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
+	"slices"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.sia.tech/core/types"
@@ -18,16 +22,76 @@ import (
 )
 
 const (
+	// defaultCommitTimeout is the default timeout for processing and
+	// committing a batch of chain updates.
+	defaultCommitTimeout = 30 * time.Second
+
 	// updatesBatchSize is the maximum number of updates to fetch in a single
 	// call to the chain manager when we request updates since a given index.
 	updatesBatchSize = 100
 
 	// syncUpdateFrequency is the frequency with which we log sync progress.
 	syncUpdateFrequency = 1e3 * updatesBatchSize
+
+	// syncingEventThreshold is the number of blocks the subscriber has to fall
+	// behind the tip after it was synced before the 'syncing' event is
+	// broadcasted, that way blocks that are found while the subscriber is
+	// near the tip don't cause it to flap between 'synced' and 'syncing'.
+	syncingEventThreshold = 6
+
+	// resolveNetAddressTimeout is the timeout for resolving a host's net
+	// address in ValidNetAddress.
+	resolveNetAddressTimeout = 5 * time.Second
+
+	// unknownContractLogInterval is the minimum interval between two log
+	// lines about skipped updates of the same unknown contract.
+	unknownContractLogInterval = time.Hour
 )
 
 var (
+	// ErrChainUpdateConflict is returned when the chain updates since the
+	// subscriber's index can't be fetched from the chain manager, this usually
+	// happens when a reorg races with the sync and is considered transient.
+	ErrChainUpdateConflict = errors.New("chain update conflict")
+
+	// ErrCommitFailed is returned when a chain update failed to be committed
+	// to the store, e.g. due to database contention, and is considered
+	// transient.
+	ErrCommitFailed = errors.New("failed to commit chain update")
+
+	// ErrInvalidChainUpdate is returned when a chain update is inconsistent and
+	// can't be applied to the store, retrying won't help so it's considered
+	// permanent. Errors returned by the store itself are not invalid updates,
+	// they are considered to have failed to commit.
+	ErrInvalidChainUpdate = errors.New("invalid chain update")
+
+	// ErrReorgTooDeep is returned when a sync would revert more blocks than
+	// the configured maximum reorg depth allows.
+	ErrReorgTooDeep = errors.New("reorg too deep")
+
+	// ErrStalled is returned when the subscriber halted because too many
+	// consecutive syncs failed.
+	ErrStalled = errors.New("chain subscriber stalled")
+
+	// ErrStoreUnavailable is returned when the subscriber's chain index can't
+	// be read from the store, e.g. due to database contention, and is
+	// considered transient.
+	ErrStoreUnavailable = errors.New("chain store unavailable")
+
+	// defaultRetryTxIntervals are the intervals at which a sync that failed
+	// with a transient error is retried.
+	defaultRetryTxIntervals = []time.Duration{
+		200 * time.Millisecond,
+		500 * time.Millisecond,
+		time.Second,
+		3 * time.Second,
+		10 * time.Second,
+		10 * time.Second,
+	}
+
 	errClosed = errors.New("subscriber closed")
+
+	errInvalidAnnouncementMaxAge = errors.New("announcement max age must be greater than zero")
 )
 
 type (
@@ -43,42 +107,206 @@ type (
 		ProcessChainUpdate(ctx context.Context, applyFn func(sql.ChainUpdateTx) error) error
 	}
 
-	WebhookManager interface {
-		webhooks.Broadcaster
-		Delete(context.Context, webhooks.Webhook) error
-		Info() ([]webhooks.Webhook, []webhooks.WebhookQueueInfo)
-		Register(context.Context, webhooks.Webhook) error
-		Shutdown(context.Context) error
-	}
-
 	Wallet interface {
 		UpdateChainState(tx wallet.UpdateTx, reverted []chain.RevertUpdate, applied []chain.ApplyUpdate) error
 	}
 
-	chainSubscriber struct {
-		cm     ChainManager
-		cs     ChainStore
-		wm     WebhookManager
-		logger *zap.SugaredLogger
+	// Clock provides the current time and a way to wait for a given duration,
+	// it allows controlling time in tests.
+	Clock interface {
+		Now() time.Time
+		Sleep(ctx context.Context, d time.Duration) error
+	}
 
-		announcementMaxAge time.Duration
-		wallet             Wallet
+	// ChainSubscriberOption is an option that can be passed to
+	// NewChainSubscriber.
+	ChainSubscriberOption func(*chainSubscriber)
+
+	// ChainSubscriberMetrics contains counters that describe the work the chain
+	// subscriber performed since it was created. Only committed chain updates
+	// are counted. RetriesExhausted is the number of syncs that gave up after
+	// all retries failed. RetryStreak is a gauge, it's the number of
+	// consecutive retries since the last successful sync.
+	ChainSubscriberMetrics struct {
+		BlocksApplied    uint64 `json:"blocksApplied"`
+		BlocksReverted   uint64 `json:"blocksReverted"`
+		ContractsUpdated uint64 `json:"contractsUpdated"`
+		HostsAnnounced   uint64 `json:"hostsAnnounced"`
+		Retries          uint64 `json:"retries"`
+		RetriesExhausted uint64 `json:"retriesExhausted"`
+		RetryStreak      uint64 `json:"retryStreak"`
+	}
+
+	// AcceptAnnouncementFn decides whether a host announcement, found in a
+	// block at the given height, should be recorded.
+	AcceptAnnouncementFn func(hk types.PublicKey, ha chain.HostAnnouncement, bh uint64) bool
+
+	// HostResolver resolves host names, it's implemented by net.Resolver.
+	HostResolver interface {
+		LookupHost(ctx context.Context, host string) ([]string, error)
+	}
+
+	chainSubscriber struct {
+		cm          ChainManager
+		cs          ChainStore
+		broadcaster webhooks.Broadcaster
+		logger      *zap.SugaredLogger
+
+		acceptAnnouncement  AcceptAnnouncementFn
+		backfill            bool
+		clock               Clock
+		commitInterval      int
+		commitTimeout       time.Duration
+		dryRun              bool
+		logUnknownContracts bool
+		maxContractSize     uint64
+		maxReorgDepth       uint64
+		onApply             func(caus []chain.ApplyUpdate)
+		onReorgTooDeep      func(index types.ChainIndex, depth uint64)
+		onRevert            func(crus []chain.RevertUpdate)
+		onStall             func(failures int, err error)
+		rejectOversized     bool
+		retryTxIntervals    []time.Duration
+		stallThreshold      int
+		stopOnStall         bool
+		wallet              Wallet
 
 		shutdownCtx       context.Context
 		shutdownCtxCancel context.CancelCauseFunc
 		syncSig           chan struct{}
 		wg                sync.WaitGroup
 
-		mu             sync.Mutex
-		knownContracts map[types.FileContractID]bool
-		unsubscribeFn  func()
+		// syncMu is held while syncing, it prevents the chain index from
+		// being reset while a sync is in progress
+		syncMu sync.Mutex
+
+		// synced indicates whether the subscriber caught up with the tip, it's
+		// used to broadcast the 'synced' and 'syncing' events and is only
+		// accessed from within the sync loop
+		synced bool
+
+		// dryRunIndex is the chain index the subscriber got to in dry-run
+		// mode, it's nil until the first batch was processed and is only
+		// accessed from within the sync loop
+		dryRunIndex *types.ChainIndex
+
+		// initialSyncDone indicates whether the subscriber caught up with the
+		// tip at least once, unlike synced it's also set in dry-run mode and
+		// never reset, it is only accessed from within the sync loop
+		initialSyncDone bool
+
+		// unknownLogged contains the time an update of an unknown contract was
+		// last logged, it is only accessed from within the sync loop
+		unknownLogged map[types.FileContractID]time.Time
+
+		// pendingEvents contains the events that are broadcasted once the
+		// chain update that triggered them is committed, it is only accessed
+		// from within the sync loop
+		pendingEvents []webhooks.Event
+
+		// pendingMetrics contains the metrics of the chain update that is
+		// being processed, they are added to the metrics once it's committed
+		pendingMetrics ChainSubscriberMetrics
+		metrics        struct {
+			blocksApplied    atomic.Uint64
+			blocksReverted   atomic.Uint64
+			contractsUpdated atomic.Uint64
+			hostsAnnounced   atomic.Uint64
+			retries          atomic.Uint64
+			retriesExhausted atomic.Uint64
+			retryStreak      atomic.Uint64
+		}
+
+		mu                 sync.Mutex
+		announcementMaxAge time.Duration
+		knownContracts     map[types.FileContractID]bool
+		paused             bool
+		unsubscribeFn      func()
+
+		// haltErr is the error that caused the subscriber to halt, sync
+		// signals are ignored until it's reset by Resume or Resync,
+		// reorgApproved is set by Resume if the subscriber halted because of
+		// a reorg that was too deep, it lets the next sync process it
+		haltErr       error
+		reorgApproved bool
 	}
 )
 
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) Sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	case <-t.C:
+		return nil
+	}
+}
+
+// dryRunTx wraps a ChainUpdateTx and logs all writes instead of performing
+// them. Contract state updates are kept in memory so subsequent updates within
+// the same transaction observe them.
+type dryRunTx struct {
+	sql.ChainUpdateTx
+	logger *zap.SugaredLogger
+	states map[types.FileContractID]api.ContractState
+}
+
+func newDryRunTx(tx sql.ChainUpdateTx, logger *zap.SugaredLogger) *dryRunTx {
+	return &dryRunTx{
+		ChainUpdateTx: tx,
+		logger:        logger,
+		states:        make(map[types.FileContractID]api.ContractState),
+	}
+}
+
+func (tx *dryRunTx) ContractState(fcid types.FileContractID) (api.ContractState, error) {
+	if state, ok := tx.states[fcid]; ok {
+		return state, nil
+	}
+	return tx.ChainUpdateTx.ContractState(fcid)
+}
+
+func (tx *dryRunTx) UpdateChainIndex(index types.ChainIndex) error {
+	tx.logger.Infow("dry run: update chain index", "height", index.Height, "block_id", index.ID)
+	return nil
+}
+
+func (tx *dryRunTx) UpdateContract(fcid types.FileContractID, revisionHeight, revisionNumber, size uint64) error {
+	tx.logger.Infow("dry run: update contract", "fcid", fcid, "revision_height", revisionHeight, "revision_number", revisionNumber, "size", size)
+	return nil
+}
+
+func (tx *dryRunTx) UpdateContractState(fcid types.FileContractID, state api.ContractState) error {
+	tx.logger.Infow("dry run: update contract state", "fcid", fcid, "state", state)
+	tx.states[fcid] = state
+	return nil
+}
+
+func (tx *dryRunTx) UpdateContractProofHeight(fcid types.FileContractID, proofHeight uint64) error {
+	tx.logger.Infow("dry run: update contract proof height", "fcid", fcid, "proof_height", proofHeight)
+	return nil
+}
+
+func (tx *dryRunTx) UpdateFailedContracts(blockHeight uint64) error {
+	tx.logger.Infow("dry run: update failed contracts", "height", blockHeight)
+	return nil
+}
+
+func (tx *dryRunTx) UpdateHost(hk types.PublicKey, ha chain.HostAnnouncement, bh uint64, blockID types.BlockID, ts time.Time) error {
+	tx.logger.Infow("dry run: update host", "hk", hk, "net_address", ha.NetAddress, "height", bh, "block_id", blockID)
+	return nil
+}
+
 type (
 	revision struct {
 		revisionNumber uint64
 		fileSize       uint64
+		windowEnd      uint64
 	}
 
 	contractUpdate struct {
@@ -90,19 +318,190 @@ type (
 	}
 )
 
+// WithAcceptAnnouncement sets a predicate that host announcements have to pass
+// to be recorded, e.g. to ignore outdated hosts. By default every announcement
+// with a net address is recorded. The predicate is called before a batch of
+// chain updates is processed, so it doesn't hold the database transaction open.
+func WithAcceptAnnouncement(fn AcceptAnnouncementFn) ChainSubscriberOption {
+	return func(s *chainSubscriber) {
+		s.acceptAnnouncement = fn
+	}
+}
+
+// ValidNetAddress returns an AcceptAnnouncementFn that only accepts
+// announcements with a net address that is a valid host:port pair. If a
+// resolver is passed, e.g. net.DefaultResolver, the host also has to resolve.
+// Resolving hosts slows down syncing.
+func ValidNetAddress(r HostResolver) AcceptAnnouncementFn {
+	return func(_ types.PublicKey, ha chain.HostAnnouncement, _ uint64) bool {
+		host, port, err := net.SplitHostPort(ha.NetAddress)
+		if err != nil || host == "" {
+			return false
+		} else if p, err := strconv.ParseUint(port, 10, 16); err != nil || p == 0 {
+			return false
+		} else if r == nil {
+			return true
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), resolveNetAddressTimeout)
+		defer cancel()
+		addrs, err := r.LookupHost(ctx, host)
+		return err == nil && len(addrs) > 0
+	}
+}
+
+// WithAnnouncementBackfill makes the chain subscriber record host
+// announcements regardless of their age until it caught up with the tip for
+// the first time, that way a node that was offline for a while learns about
+// the hosts that announced in the meantime. After that, announcements older
+// than the announcement max age are ignored again.
+func WithAnnouncementBackfill(backfill bool) ChainSubscriberOption {
+	return func(s *chainSubscriber) {
+		s.backfill = backfill
+	}
+}
+
+// WithClock sets the clock used by the chain subscriber to decide whether host
+// announcements are recent enough to be recorded and to wait between retries.
+func WithClock(c Clock) ChainSubscriberOption {
+	return func(s *chainSubscriber) {
+		s.clock = c
+	}
+}
+
+// WithCommitInterval makes the chain subscriber commit every batch of chain
+// updates in checkpoints of the given number of blocks, each checkpoint
+// updates the chain index. That way a sync that fails only loses the progress
+// made since the last checkpoint. An interval of 0, the default, commits every
+// batch in a single transaction.
+func WithCommitInterval(blocks int) ChainSubscriberOption {
+	return func(s *chainSubscriber) {
+		s.commitInterval = blocks
+	}
+}
+
+// WithCommitTimeout sets the timeout for processing and committing a batch of
+// chain updates, a batch that times out is aborted and retried like a batch
+// that failed to commit. A timeout of 0 disables the timeout, by default it's
+// 30 seconds.
+func WithCommitTimeout(timeout time.Duration) ChainSubscriberOption {
+	return func(s *chainSubscriber) {
+		s.commitTimeout = timeout
+	}
+}
+
+// WithDryRun puts the chain subscriber in dry-run mode. In dry-run mode chain
+// updates are processed as usual but all intended changes to the store are
+// logged instead of performed and no events are broadcasted. The chain index
+// the dry run got to is kept in memory, so every update since the index that
+// is stored is only processed once.
+func WithDryRun(dryRun bool) ChainSubscriberOption {
+	return func(s *chainSubscriber) {
+		s.dryRun = dryRun
+	}
+}
+
+// WithLogUnknownContracts makes the chain subscriber log the contract updates
+// it skips because the contract is unknown at debug level, which helps when
+// debugging why a contract's state isn't updated. Every contract is logged at
+// most once per hour to avoid spam. By default they are skipped silently.
+func WithLogUnknownContracts(log bool) ChainSubscriberOption {
+	return func(s *chainSubscriber) {
+		s.logUnknownContracts = log
+	}
+}
+
+// WithMaxContractSize sets a ceiling for the file size of the contract
+// revisions the chain subscriber stores, it guards the store against bogus
+// revisions. Revisions with a larger file size are logged as a warning, if
+// reject is set their revision number and file size aren't stored either.
+// Contract state transitions are processed regardless. A size of 0, the
+// default, disables the check.
+func WithMaxContractSize(size uint64, reject bool) ChainSubscriberOption {
+	return func(s *chainSubscriber) {
+		s.maxContractSize = size
+		s.rejectOversized = reject
+	}
+}
+
+// WithMaxReorgDepth limits the number of blocks a reorg is allowed to revert.
+// The depth of a reorg is measured before any of its reverts are committed, if
+// it's too deep the sync is aborted with ErrReorgTooDeep and the given
+// callback, which is optional, is called with the subscriber's chain index and
+// the number of blocks that were about to be reverted. The subscriber then
+// halts until Resume or Resync is called. A depth of 0 means there is no
+// limit.
+func WithMaxReorgDepth(depth uint64, onReorgTooDeep func(index types.ChainIndex, depth uint64)) ChainSubscriberOption {
+	return func(s *chainSubscriber) {
+		s.maxReorgDepth = depth
+		s.onReorgTooDeep = onReorgTooDeep
+	}
+}
+
+// WithOnApply registers a callback that is called with the apply updates of
+// every batch of chain updates the subscriber committed, e.g. for custom
+// indexing. It's called after the batch was committed so updates of batches
+// that are retried are only observed once. The updates must not be modified.
+func WithOnApply(fn func(caus []chain.ApplyUpdate)) ChainSubscriberOption {
+	return func(s *chainSubscriber) {
+		s.onApply = fn
+	}
+}
+
+// WithOnRevert registers a callback that is called with the revert updates of
+// every batch of chain updates the subscriber committed. Within a batch it's
+// called before the callback registered with WithOnApply, the same rules
+// apply.
+func WithOnRevert(fn func(crus []chain.RevertUpdate)) ChainSubscriberOption {
+	return func(s *chainSubscriber) {
+		s.onRevert = fn
+	}
+}
+
+// WithStallDetector detects a subscriber that is stuck, e.g. because the store
+// keeps failing. Once the given number of consecutive syncs failed after
+// exhausting their retries, the stall is logged and the given callback, which
+// is optional, is called with the number of failed syncs and the last error.
+// If stop is true the subscriber also halts until Resume is called, otherwise
+// it keeps trying on every sync signal. A threshold of 0, the default,
+// disables the detector.
+func WithStallDetector(threshold int, stop bool, onStall func(failures int, err error)) ChainSubscriberOption {
+	return func(s *chainSubscriber) {
+		s.stallThreshold = threshold
+		s.stopOnStall = stop
+		s.onStall = onStall
+	}
+}
+
 // NewChainSubscriber creates a new chain subscriber that will sync with the
-// given chain manager and chain store. The returned subscriber is already
-// running and can be stopped by calling Shutdown.
-func NewChainSubscriber(whm WebhookManager, cm ChainManager, cs ChainStore, w Wallet, announcementMaxAge time.Duration, logger *zap.Logger) *chainSubscriber {
+// given chain manager and chain store. The broadcaster is optional, if it's nil
+// no events are broadcasted. The wallet is optional too, if it's nil wallet
+// updates aren't processed which is useful for read-only indexers. If the
+// logger is nil, nothing is logged. The returned subscriber is already running
+// and can be stopped by calling Shutdown.
+func NewChainSubscriber(broadcaster webhooks.Broadcaster, cm ChainManager, cs ChainStore, w Wallet, announcementMaxAge time.Duration, logger *zap.Logger, opts ...ChainSubscriberOption) (*chainSubscriber, error) {
+	if announcementMaxAge <= 0 {
+		return nil, fmt.Errorf("%w, got %v", errInvalidAnnouncementMaxAge, announcementMaxAge)
+	}
+	if broadcaster == nil {
+		broadcaster = webhooks.NoopBroadcaster{}
+	}
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
 	logger = logger.Named("chainsubscriber")
 	ctx, cancel := context.WithCancelCause(context.Background())
 	subscriber := &chainSubscriber{
-		cm:     cm,
-		cs:     cs,
-		wm:     whm,
-		logger: logger.Sugar(),
+		cm:          cm,
+		cs:          cs,
+		broadcaster: broadcaster,
+		logger:      logger.Sugar(),
 
 		announcementMaxAge: announcementMaxAge,
+		clock:              realClock{},
+		commitTimeout:      defaultCommitTimeout,
+		retryTxIntervals:   defaultRetryTxIntervals,
 		wallet:             w,
 
 		shutdownCtx:       ctx,
@@ -111,34 +510,161 @@ func NewChainSubscriber(whm WebhookManager, cm ChainManager, cs ChainStore, w Wa
 
 		knownContracts: make(map[types.FileContractID]bool),
 	}
+	for _, opt := range opts {
+		opt(subscriber)
+	}
+	if subscriber.dryRun {
+		subscriber.logger.Warn("chain subscriber is running in dry-run mode, chain updates won't be committed")
+	}
 
 	// start the subscriber
 	subscriber.run()
 
 	// trigger a sync on reorgs
 	subscriber.unsubscribeFn = cm.OnReorg(func(ci types.ChainIndex) {
-		select {
-		case subscriber.syncSig <- struct{}{}:
-			subscriber.logger.Debugw("reorg triggered", "height", ci.Height, "block_id", ci.ID)
-		default:
-		}
+		subscriber.TriggerSync()
+		subscriber.logger.Debugw("reorg triggered", "height", ci.Height, "block_id", ci.ID)
 	})
 
-	return subscriber
+	return subscriber, nil
 }
 
 func (s *chainSubscriber) ChainIndex(ctx context.Context) (types.ChainIndex, error) {
 	return s.cs.ChainIndex(ctx)
 }
 
+// IsTracking returns whether the subscriber knows the contract with given id,
+// i.e. whether it found the contract in the store while processing a chain
+// update that touched it.
+func (s *chainSubscriber) IsTracking(fcid types.FileContractID) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.knownContracts[fcid]
+}
+
+// Metrics returns the chain subscriber's metrics.
+func (s *chainSubscriber) Metrics() ChainSubscriberMetrics {
+	return ChainSubscriberMetrics{
+		BlocksApplied:    s.metrics.blocksApplied.Load(),
+		BlocksReverted:   s.metrics.blocksReverted.Load(),
+		ContractsUpdated: s.metrics.contractsUpdated.Load(),
+		HostsAnnounced:   s.metrics.hostsAnnounced.Load(),
+		Retries:          s.metrics.retries.Load(),
+		RetriesExhausted: s.metrics.retriesExhausted.Load(),
+		RetryStreak:      s.metrics.retryStreak.Load(),
+	}
+}
+
+// Pause halts chain processing without shutting down the subscriber. A sync
+// that is in progress is stopped after the batch it's processing, Pause blocks
+// until that batch is committed so no chain updates are committed after it
+// returns. Sync signals received while paused are ignored, Resume catches up.
+func (s *chainSubscriber) Pause() {
+	s.mu.Lock()
+	s.paused = true
+	s.mu.Unlock()
+
+	// wait for an ongoing sync to finish
+	s.syncMu.Lock()
+	s.syncMu.Unlock()
+	s.logger.Info("chain subscriber paused")
+}
+
+// Resume resumes chain processing after a call to Pause or after the subscriber
+// halted and triggers a sync to catch up on the blocks that were mined in the
+// meantime. If the subscriber halted because a reorg exceeded the max reorg
+// depth, Resume approves the reorg and the next sync processes it regardless
+// of its depth.
+func (s *chainSubscriber) Resume() {
+	s.mu.Lock()
+	resume := s.paused || s.haltErr != nil
+	if errors.Is(s.haltErr, ErrReorgTooDeep) {
+		s.reorgApproved = true
+	}
+	s.paused = false
+	s.haltErr = nil
+	s.mu.Unlock()
+
+	if resume {
+		s.logger.Info("chain subscriber resumed")
+		s.TriggerSync()
+	}
+}
+
+// Resync reprocesses the chain updates between the given index and the
+// subscriber's current index, which can be used to rebuild the contract states
+// in the store. The updates are reprocessed as apply updates in a single
+// transaction, contract revisions are only updated if their revision number
+// increases and contract states only transition if they differ, so states
+// aren't double counted. The wallet already processed these updates so they
+// aren't passed to it again. The given index has to be on the best chain and
+// can't be ahead of the subscriber's current index, which has to be on the
+// best chain as well. A successful resync resumes a halted subscriber.
+func (s *chainSubscriber) Resync(from types.ChainIndex) error {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	if s.isClosed() {
+		return errClosed
+	}
+
+	// sanity check the indices
+	curr, err := s.chainIndex()
+	if err != nil {
+		return fmt.Errorf("failed to get chain index: %w", err)
+	} else if from.Height > curr.Height {
+		return fmt.Errorf("resync index %v is ahead of the current index %v", from, curr)
+	} else if crus, _, err := s.cm.UpdatesSince(from, 1); err != nil {
+		return fmt.Errorf("resync index %v is not on the best chain: %w", from, err)
+	} else if len(crus) > 0 {
+		return fmt.Errorf("resync index %v is not on the best chain", from)
+	} else if crus, _, err := s.cm.UpdatesSince(curr, 1); err != nil {
+		return fmt.Errorf("current index %v is not on the best chain: %w", curr, err)
+	} else if len(crus) > 0 {
+		return fmt.Errorf("current index %v is not on the best chain, the subscriber has to sync first", curr)
+	}
+	s.logger.Infow("resyncing", "from_height", from.Height, "from_block_id", from.ID, "to_height", curr.Height, "to_block_id", curr.ID)
+
+	// in dry-run mode nothing was committed, rewinding the index is enough
+	if s.dryRun {
+		s.dryRunIndex = &from
+		s.TriggerSync()
+		return nil
+	}
+
+	if err := s.replayUpdates(from, curr); err != nil {
+		return fmt.Errorf("failed to resync: %w", err)
+	}
+	s.halt(nil)
+	s.TriggerSync()
+	return nil
+}
+
+// SetAnnouncementMaxAge updates the max age of host announcements, older
+// announcements are ignored. The new max age applies to all chain updates that
+// are processed after the call.
+func (s *chainSubscriber) SetAnnouncementMaxAge(d time.Duration) error {
+	if d <= 0 {
+		return errInvalidAnnouncementMaxAge
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.announcementMaxAge = d
+	return nil
+}
+
 func (s *chainSubscriber) Shutdown(ctx context.Context) error {
 	// cancel shutdown context
 	s.shutdownCtxCancel(errClosed)
 
-	// unsubscribe from the chain manager
+	// unsubscribe from the chain manager, the function is reset so calling
+	// Shutdown more than once is a no-op
+	s.mu.Lock()
 	if s.unsubscribeFn != nil {
 		s.unsubscribeFn()
+		s.unsubscribeFn = nil
 	}
+	s.mu.Unlock()
 
 	// wait for sync loop to finish
 	waitChan := make(chan struct{})
@@ -154,31 +680,41 @@ func (s *chainSubscriber) Shutdown(ctx context.Context) error {
 	return nil
 }
 
-func (s *chainSubscriber) applyChainUpdate(tx sql.ChainUpdateTx, cau chain.ApplyUpdate) error {
+// TrackedCount returns the number of contracts the subscriber knows.
+func (s *chainSubscriber) TrackedCount() (n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, known := range s.knownContracts {
+		if known {
+			n++
+		}
+	}
+	return
+}
+
+// TriggerSync requests a sync, e.g. after blocks were added to the chain
+// manager out of band. It doesn't block, if a sync is already pending the call
+// is a no-op.
+func (s *chainSubscriber) TriggerSync() {
+	select {
+	case s.syncSig <- struct{}{}:
+	default:
+	}
+}
+
+// applyChainUpdate applies the given apply update and records the given host
+// announcements, which were found in the update's block.
+func (s *chainSubscriber) applyChainUpdate(tx sql.ChainUpdateTx, cau chain.ApplyUpdate, hus map[types.PublicKey]chain.HostAnnouncement) error {
 	// apply host updates
 	b := cau.Block
-	if time.Since(b.Timestamp) <= s.announcementMaxAge {
-		hus := make(map[types.PublicKey]chain.HostAnnouncement)
-		chain.ForEachHostAnnouncement(b, func(hk types.PublicKey, ha chain.HostAnnouncement) {
-			if ha.NetAddress != "" {
-				hus[hk] = ha
-			}
-		})
-		for hk, ha := range hus {
-			if err := tx.UpdateHost(hk, ha, cau.State.Index.Height, b.ID(), b.Timestamp); err != nil {
-				return fmt.Errorf("failed to update host: %w", err)
-			} else if utils.IsSynced(b) {
-				// broadcast host update
-				s.wm.BroadcastAction(s.shutdownCtx, webhooks.Event{
-					Module: api.ModuleHost,
-					Event:  api.EventUpdate,
-					Payload: api.EventHostUpdate{
-						HostKey:   hk,
-						NetAddr:   ha.NetAddress,
-						Timestamp: time.Now().UTC(),
-					},
-				})
-			}
+	for hk, ha := range hus {
+		if err := tx.UpdateHost(hk, ha, cau.State.Index.Height, b.ID(), b.Timestamp); err != nil {
+			return fmt.Errorf("failed to update host: %w", err)
+		}
+		s.pendingMetrics.HostsAnnounced++
+		if utils.IsSynced(b) {
+			// broadcast host update
+			s.pendingEvents = append(s.pendingEvents, api.NewHostUpdateEvent(hk, ha.NetAddress, cau.State.Index.Height, s.clock.Now()))
 		}
 	}
 
@@ -216,13 +752,46 @@ func (s *chainSubscriber) applyChainUpdate(tx sql.ChainUpdateTx, cau chain.Apply
 	return nil
 }
 
+// hostAnnouncements returns the host announcements that should be recorded for
+// every given apply update, if a host announced more than once within a block
+// only its last announcement is returned. Announcements are filtered before the
+// updates are processed so a slow filter doesn't hold the transaction open.
+func (s *chainSubscriber) hostAnnouncements(caus []chain.ApplyUpdate) []map[types.PublicKey]chain.HostAnnouncement {
+	s.mu.Lock()
+	maxAge := s.announcementMaxAge
+	s.mu.Unlock()
+
+	hus := make([]map[types.PublicKey]chain.HostAnnouncement, len(caus))
+	for i, cau := range caus {
+		b := cau.Block
+		if !(s.backfill && !s.initialSyncDone) && s.clock.Now().Sub(b.Timestamp) > maxAge {
+			continue
+		}
+		hus[i] = make(map[types.PublicKey]chain.HostAnnouncement)
+		chain.ForEachHostAnnouncement(b, func(hk types.PublicKey, ha chain.HostAnnouncement) {
+			if ha.NetAddress == "" {
+				return
+			} else if s.acceptAnnouncement != nil && !s.acceptAnnouncement(hk, ha, cau.State.Index.Height) {
+				s.logger.Debugw("ignoring host announcement", "hk", hk, "net_address", ha.NetAddress)
+				return
+			}
+			hus[i][hk] = ha
+		})
+	}
+	return hus
+}
+
 func (s *chainSubscriber) revertChainUpdate(tx sql.ChainUpdateTx, cru chain.RevertUpdate) error {
 	// NOTE: host updates are not reverted
 
 	// v1 contracts
 	var cus []contractUpdate
-	cru.ForEachFileContractElement(func(fce types.FileContractElement, _ bool, rev *types.FileContractElement, resolved, valid bool) {
-		cus = append(cus, v1ContractUpdate(fce, rev, resolved, valid))
+	cru.ForEachFileContractElement(func(fce types.FileContractElement, created bool, rev *types.FileContractElement, resolved, valid bool) {
+		cus = append(cus, revertedContractUpdate(v1ContractUpdate(fce, rev, resolved, valid), created, revision{
+			revisionNumber: fce.FileContract.RevisionNumber,
+			fileSize:       fce.FileContract.Filesize,
+			windowEnd:      fce.FileContract.WindowEnd,
+		}))
 	})
 	for _, cu := range cus {
 		if err := s.updateContract(tx, cru.State.Index, cu.fcid, cu.prev, cu.curr, cu.resolved, cu.valid); err != nil {
@@ -232,8 +801,12 @@ func (s *chainSubscriber) revertChainUpdate(tx sql.ChainUpdateTx, cru chain.Reve
 
 	// v2 contracts
 	cus = cus[:0]
-	cru.ForEachV2FileContractElement(func(fce types.V2FileContractElement, _ bool, rev *types.V2FileContractElement, res types.V2FileContractResolutionType) {
-		cus = append(cus, v2ContractUpdate(fce, rev, res))
+	cru.ForEachV2FileContractElement(func(fce types.V2FileContractElement, created bool, rev *types.V2FileContractElement, res types.V2FileContractResolutionType) {
+		cus = append(cus, revertedContractUpdate(v2ContractUpdate(fce, rev, res), created, revision{
+			revisionNumber: fce.V2FileContract.RevisionNumber,
+			fileSize:       fce.V2FileContract.Filesize,
+			windowEnd:      fce.V2FileContract.ExpirationHeight,
+		}))
 	})
 	for _, cu := range cus {
 		if err := s.updateContract(tx, cru.State.Index, cu.fcid, cu.prev, cu.curr, cu.resolved, cu.valid); err != nil {
@@ -249,6 +822,9 @@ func (s *chainSubscriber) run() {
 	go func() {
 		defer s.wg.Done()
 
+		// failures is the number of consecutive syncs that failed after
+		// exhausting their retries
+		var failures int
 		for {
 			select {
 			case <-s.shutdownCtx.Done():
@@ -256,66 +832,185 @@ func (s *chainSubscriber) run() {
 			case <-s.syncSig:
 			}
 
-			if err := s.sync(); errors.Is(err, errClosed) || errors.Is(err, context.Canceled) {
+			// Resume triggers a sync so signals received while paused or
+			// halted can be ignored
+			if s.isPaused() || s.isHalted() {
+				continue
+			}
+
+			if err := s.syncWithRetry(); errors.Is(err, errClosed) || errors.Is(err, context.Canceled) {
 				return
+			} else if errors.Is(err, ErrReorgTooDeep) {
+				s.halt(err)
+				s.logger.Errorw("sync halted, call Resume to process the reorg", zap.Error(err))
+			} else if isRetryableSyncErr(err) {
+				failures++
+				s.logger.Errorw("sync failed after exhausting all retries, retrying on the next sync signal", zap.Error(err), "failures", failures)
+				if s.stallThreshold > 0 && failures == s.stallThreshold {
+					s.stalled(failures, err)
+					if s.stopOnStall {
+						failures = 0
+					}
+				}
 			} else if err != nil {
 				s.logger.Panicf("failed to sync: %v", err)
+			} else {
+				failures = 0
 			}
 		}
 	}()
 }
 
+// stalled is called when the given number of consecutive syncs failed, it halts
+// the subscriber if configured to do so and notifies the stall callback.
+func (s *chainSubscriber) stalled(failures int, err error) {
+	if s.stopOnStall {
+		s.halt(fmt.Errorf("%w: %d consecutive syncs failed: %w", ErrStalled, failures, err))
+		s.logger.Errorw("chain subscriber stalled, halting until resumed", zap.Error(err), "failures", failures)
+	} else {
+		s.logger.Errorw("chain subscriber stalled", zap.Error(err), "failures", failures)
+	}
+	if s.onStall != nil {
+		s.onStall(failures, err)
+	}
+}
+
+// syncWithRetry performs a sync, retrying it if it failed with a transient
+// error, permanent errors are returned right away. If all retries failed the
+// last transient error is returned.
+func (s *chainSubscriber) syncWithRetry() error {
+	for i := 0; ; i++ {
+		err := s.sync()
+		if err == nil {
+			s.metrics.retryStreak.Store(0)
+			return nil
+		} else if !isRetryableSyncErr(err) {
+			return err
+		} else if s.isPaused() {
+			s.logger.Warnw("sync failed while paused, retrying on resume", zap.Error(err))
+			return nil
+		} else if i >= len(s.retryTxIntervals) {
+			s.metrics.retriesExhausted.Add(1)
+			return err
+		}
+
+		s.logger.Warnw("sync failed, retrying", zap.Error(err), "attempt", i+1, "retry_in", s.retryTxIntervals[i])
+		s.metrics.retries.Add(1)
+		s.metrics.retryStreak.Add(1)
+		if err := s.clock.Sleep(s.shutdownCtx, s.retryTxIntervals[i]); err != nil {
+			return err
+		}
+	}
+}
+
 func (s *chainSubscriber) sync() error {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
 	start := time.Now()
 
 	// fetch current chain index
-	index, err := s.cs.ChainIndex(s.shutdownCtx)
+	index, err := s.chainIndex()
 	if err != nil {
-		return fmt.Errorf("failed to get chain index: %w", err)
+		return fmt.Errorf("%w: failed to get chain index: %w", ErrStoreUnavailable, err)
 	}
 	s.logger.Debugw("sync started", "height", index.Height, "block_id", index.ID)
 	sheight := index.Height / syncUpdateFrequency
 
+	// broadcast the syncing event if we fell behind after being synced
+	if tip := s.cm.Tip(); s.synced && tip.Height > index.Height+syncingEventThreshold && !s.dryRun {
+		s.synced = false
+		s.broadcaster.BroadcastAction(s.shutdownCtx, api.NewConsensusSyncingEvent(index.Height, tip.Height, s.clock.Now()))
+	}
+
 	// fetch updates until we're caught up
 	var cnt uint64
-	for index != s.cm.Tip() && !s.isClosed() {
+	for index != s.cm.Tip() && !s.isClosed() && !s.isPaused() {
 		// fetch updates
 		istart := time.Now()
 		crus, caus, err := s.cm.UpdatesSince(index, updatesBatchSize)
 		if err != nil {
-			return fmt.Errorf("failed to fetch updates: %w", err)
+			return fmt.Errorf("%w: failed to fetch updates: %w", ErrChainUpdateConflict, err)
 		}
 		s.logger.Debugw("fetched updates since", "caus", len(caus), "crus", len(crus), "since_height", index.Height, "since_block_id", index.ID, "ms", time.Since(istart).Milliseconds(), "batch_size", updatesBatchSize)
 
-		// process updates
+		// without updates we can't make progress, this can happen when a
+		// reorg races with the sync, the next sync signal will try again
+		if len(crus) == 0 && len(caus) == 0 {
+			s.logger.Warnw("no updates since index, aborting sync", "height", index.Height, "block_id", index.ID, "tip_height", s.cm.Tip().Height, "tip_block_id", s.cm.Tip().ID)
+			break
+		}
+
+		// check the reorg depth before anything is reverted, the reverts
+		// might span more than one batch
+		if len(crus) > 0 && s.maxReorgDepth > 0 && !s.isReorgApproved() {
+			depth, err := s.reorgDepth(crus, caus)
+			if err != nil {
+				return err
+			} else if depth > s.maxReorgDepth {
+				if s.onReorgTooDeep != nil {
+					s.onReorgTooDeep(index, depth)
+				}
+				return fmt.Errorf("%w: reverting at least %d blocks from height %d exceeds the max reorg depth of %d", ErrReorgTooDeep, depth, index.Height, s.maxReorgDepth)
+			}
+		}
+
+		// process updates, if a commit interval is configured the batch is
+		// committed in checkpoints
 		var block types.Block
 		istart = time.Now()
-		index, block, err = s.processUpdates(s.shutdownCtx, crus, caus)
-		if err != nil {
-			return fmt.Errorf("failed to process updates: %w", err)
+		applied := len(caus) > 0
+		for len(crus) > 0 || len(caus) > 0 {
+			n := len(crus) + len(caus)
+			if s.commitInterval > 0 && n > s.commitInterval {
+				n = s.commitInterval
+			}
+			nr := min(n, len(crus))
+			na := n - nr
+
+			index, block, err = s.processUpdates(s.shutdownCtx, crus[:nr], caus[:na])
+			if err != nil {
+				return fmt.Errorf("failed to process updates: %w", err)
+			}
+			crus, caus = crus[nr:], caus[na:]
+		}
+
+		// an approved reorg is done once blocks are applied again, the
+		// approval doesn't extend to later reorgs
+		if applied {
+			s.mu.Lock()
+			s.reorgApproved = false
+			s.mu.Unlock()
 		}
 		s.logger.Debugw("processed updates successfully", "new_height", index.Height, "new_block_id", index.ID, "ms", time.Since(istart).Milliseconds())
 		cnt++
 
 		// broadcast consensus update
-		if utils.IsSynced(block) {
-			s.wm.BroadcastAction(s.shutdownCtx, webhooks.Event{
-				Module: api.ModuleConsensus,
-				Event:  api.EventUpdate,
-				Payload: api.EventConsensusUpdate{
-					ConsensusState: api.ConsensusState{
-						BlockHeight:   index.Height,
-						LastBlockTime: api.TimeRFC3339(block.Timestamp),
-						Synced:        true,
-					},
-					TransactionFee: s.cm.RecommendedFee(),
-					Timestamp:      time.Now().UTC(),
-				}})
+		if utils.IsSynced(block) && !s.dryRun {
+			s.broadcaster.BroadcastAction(s.shutdownCtx, api.NewConsensusUpdateEvent(api.ConsensusState{
+				BlockHeight:   index.Height,
+				LastBlockTime: api.TimeRFC3339(block.Timestamp),
+				Synced:        true,
+			}, s.cm.RecommendedFee(), s.clock.Now()))
 		}
 	}
 
 	s.logger.Debugw("sync completed", "height", index.Height, "block_id", index.ID, "ms", time.Since(start).Milliseconds(), "iterations", cnt)
 
+	// the initial sync is done once we caught up with the tip
+	if !s.initialSyncDone && index == s.cm.Tip() {
+		s.initialSyncDone = true
+		if s.backfill {
+			s.logger.Infow("initial sync completed, announcement backfill disabled", "height", index.Height)
+		}
+	}
+
+	// broadcast the synced event if we caught up with the tip
+	if !s.synced && index == s.cm.Tip() && !s.dryRun {
+		s.synced = true
+		s.broadcaster.BroadcastAction(s.shutdownCtx, api.NewConsensusSyncedEvent(index.Height, s.clock.Now()))
+	}
+
 	// info log sync progress
 	if index.Height/syncUpdateFrequency != sheight {
 		s.logger.Infow("sync progress", "height", index.Height, "block_id", index.ID)
@@ -324,41 +1019,165 @@ func (s *chainSubscriber) sync() error {
 }
 
 func (s *chainSubscriber) processUpdates(ctx context.Context, crus []chain.RevertUpdate, caus []chain.ApplyUpdate) (index types.ChainIndex, tip types.Block, _ error) {
-	if err := s.cs.ProcessChainUpdate(ctx, func(tx sql.ChainUpdateTx) error {
-		// process wallet updates
-		if err := s.wallet.UpdateChainState(tx, crus, caus); err != nil {
-			return fmt.Errorf("failed to process wallet updates: %w", err)
+	// filter the host announcements before the transaction is opened
+	hus := s.hostAnnouncements(caus)
+
+	// apply the commit timeout, a batch that times out is considered to have
+	// failed to commit so it's retried
+	tctx := ctx
+	if s.commitTimeout > 0 {
+		var cancel context.CancelFunc
+		tctx, cancel = context.WithTimeout(ctx, s.commitTimeout)
+		defer cancel()
+	}
+
+	if err := s.cs.ProcessChainUpdate(tctx, func(tx sql.ChainUpdateTx) (err error) {
+		// reset pending events and metrics, the update might be retried
+		s.pendingEvents = s.pendingEvents[:0]
+		s.pendingMetrics = ChainSubscriberMetrics{
+			BlocksApplied:  uint64(len(caus)),
+			BlocksReverted: uint64(len(crus)),
 		}
 
-		// process revert updates
-		for _, cru := range crus {
-			if err := s.revertChainUpdate(tx, cru); err != nil {
-				return fmt.Errorf("failed to revert chain update: %w", err)
-			}
+		if s.dryRun {
+			tx = newDryRunTx(tx, s.logger)
 		}
 
-		// process apply updates
-		for _, cau := range caus {
-			if err := s.applyChainUpdate(tx, cau); err != nil {
-				return fmt.Errorf("failed to apply chain updates: %w", err)
+		index, tip, err = s.applyUpdates(tx, crus, caus, hus)
+		return err
+	}); err != nil && ctx.Err() == nil && errors.Is(tctx.Err(), context.DeadlineExceeded) {
+		return types.ChainIndex{}, types.Block{}, fmt.Errorf("%w: timed out after %v: %w", ErrCommitFailed, s.commitTimeout, err)
+	} else if errors.Is(err, ErrInvalidChainUpdate) || errors.Is(err, errClosed) || errors.Is(err, context.Canceled) {
+		return types.ChainIndex{}, types.Block{}, err
+	} else if err != nil {
+		return types.ChainIndex{}, types.Block{}, fmt.Errorf("%w: %w", ErrCommitFailed, err)
+	}
+
+	// in dry-run mode the chain index isn't committed, keep track of it so
+	// the next sync continues where this one left off
+	if s.dryRun {
+		s.dryRunIndex = &index
+	}
+
+	// update metrics now that the update was committed
+	s.metrics.blocksApplied.Add(s.pendingMetrics.BlocksApplied)
+	s.metrics.blocksReverted.Add(s.pendingMetrics.BlocksReverted)
+	s.metrics.contractsUpdated.Add(s.pendingMetrics.ContractsUpdated)
+	s.metrics.hostsAnnounced.Add(s.pendingMetrics.HostsAnnounced)
+
+	// broadcast events now that the update was committed, hosts that announced
+	// more than once within the batch are only broadcasted once
+	for _, e := range dedupHostUpdateEvents(s.pendingEvents) {
+		if s.dryRun {
+			s.logger.Infow("dry run: broadcast event", "event", e.String())
+			continue
+		}
+		s.broadcaster.BroadcastAction(ctx, e)
+	}
+	s.pendingEvents = s.pendingEvents[:0]
+
+	// notify the hooks, in dry-run mode nothing was committed
+	if !s.dryRun {
+		if s.onRevert != nil && len(crus) > 0 {
+			s.onRevert(crus)
+		}
+		if s.onApply != nil && len(caus) > 0 {
+			s.onApply(caus)
+		}
+	}
+	return
+}
+
+// replayUpdates reprocesses the apply updates between the given indices in a
+// single transaction without passing them to the wallet, that way the store
+// and the wallet never end up at different indices. Host announcements were
+// recorded when the updates were first processed so they aren't replayed.
+func (s *chainSubscriber) replayUpdates(from, to types.ChainIndex) error {
+	if err := s.cs.ProcessChainUpdate(s.shutdownCtx, func(tx sql.ChainUpdateTx) error {
+		// reset pending events and metrics, the update might be retried
+		s.pendingEvents = s.pendingEvents[:0]
+		s.pendingMetrics = ChainSubscriberMetrics{}
+
+		index := from
+		for index != to {
+			crus, caus, err := s.cm.UpdatesSince(index, updatesBatchSize)
+			if err != nil {
+				return fmt.Errorf("%w: failed to fetch updates: %w", ErrChainUpdateConflict, err)
+			} else if len(crus) > 0 || len(caus) == 0 {
+				return fmt.Errorf("%w: chain was reorged during resync", ErrChainUpdateConflict)
+			}
+			for _, cau := range caus {
+				if err := s.applyChainUpdate(tx, cau, nil); err != nil {
+					return fmt.Errorf("failed to apply chain update: %w", err)
+				}
+				s.pendingMetrics.BlocksApplied++
+				index = cau.State.Index
+				if index.Height >= to.Height {
+					break
+				}
+			}
+			if index.Height >= to.Height && index != to {
+				return fmt.Errorf("%w: chain was reorged during resync", ErrChainUpdateConflict)
 			}
 		}
+		return tx.UpdateFailedContracts(to.Height)
+	}); err != nil {
+		return err
+	}
 
-		// update chain index
-		index = caus[len(caus)-1].State.Index
-		if err := tx.UpdateChainIndex(index); err != nil {
-			return fmt.Errorf("failed to update chain index: %w", err)
+	// update metrics and broadcast events now that the update was committed
+	s.metrics.blocksApplied.Add(s.pendingMetrics.BlocksApplied)
+	s.metrics.contractsUpdated.Add(s.pendingMetrics.ContractsUpdated)
+	s.metrics.hostsAnnounced.Add(s.pendingMetrics.HostsAnnounced)
+	for _, e := range dedupHostUpdateEvents(s.pendingEvents) {
+		s.broadcaster.BroadcastAction(s.shutdownCtx, e)
+	}
+	s.pendingEvents = s.pendingEvents[:0]
+	return nil
+}
+
+// applyUpdates applies the given revert and apply updates using the given
+// transaction, it returns the new chain index and the block at the tip. The
+// host announcements that are recorded are passed for every apply update.
+func (s *chainSubscriber) applyUpdates(tx sql.ChainUpdateTx, crus []chain.RevertUpdate, caus []chain.ApplyUpdate, hus []map[types.PublicKey]chain.HostAnnouncement) (index types.ChainIndex, tip types.Block, _ error) {
+	// process wallet updates, if there is a wallet
+	if s.wallet != nil && s.dryRun {
+		s.logger.Infow("dry run: update wallet", "reverted", len(crus), "applied", len(caus))
+	} else if s.wallet != nil {
+		if err := s.wallet.UpdateChainState(tx, crus, caus); err != nil {
+			return types.ChainIndex{}, types.Block{}, fmt.Errorf("failed to process wallet updates: %w", err)
 		}
+	}
+
+	// process revert updates
+	for _, cru := range crus {
+		if err := s.revertChainUpdate(tx, cru); err != nil {
+			return types.ChainIndex{}, types.Block{}, fmt.Errorf("failed to revert chain update: %w", err)
+		}
+	}
 
-		// update failed contracts
-		if err := tx.UpdateFailedContracts(index.Height); err != nil {
-			return fmt.Errorf("failed to update failed contracts: %w", err)
+	// process apply updates
+	for i, cau := range caus {
+		if err := s.applyChainUpdate(tx, cau, hus[i]); err != nil {
+			return types.ChainIndex{}, types.Block{}, fmt.Errorf("failed to apply chain updates: %w", err)
 		}
+	}
 
+	// update chain index, a batch might only contain reverts in which case
+	// the index is the one of the last reverted block's parent
+	if len(caus) > 0 {
+		index = caus[len(caus)-1].State.Index
 		tip = caus[len(caus)-1].Block
-		return nil
-	}); err != nil {
-		return types.ChainIndex{}, types.Block{}, err
+	} else {
+		index = crus[len(crus)-1].State.Index
+	}
+	if err := tx.UpdateChainIndex(index); err != nil {
+		return types.ChainIndex{}, types.Block{}, fmt.Errorf("failed to update chain index: %w", err)
+	}
+
+	// update failed contracts
+	if err := tx.UpdateFailedContracts(index.Height); err != nil {
+		return types.ChainIndex{}, types.Block{}, fmt.Errorf("failed to update failed contracts: %w", err)
 	}
 	return
 }
@@ -366,11 +1185,12 @@ func (s *chainSubscriber) processUpdates(ctx context.Context, crus []chain.Rever
 func (s *chainSubscriber) updateContract(tx sql.ChainUpdateTx, index types.ChainIndex, fcid types.FileContractID, prev, curr *revision, resolved, valid bool) error {
 	// sanity check at least one is not nil
 	if prev == nil && curr == nil {
-		return errors.New("both prev and curr revisions are nil") // developer error
+		return fmt.Errorf("%w: both prev and curr revisions are nil", ErrInvalidChainUpdate) // developer error
 	}
 
 	// ignore unknown contracts
 	if !s.isKnownContract(fcid) {
+		s.logUnknownContract(fcid, index)
 		return nil
 	}
 
@@ -378,61 +1198,73 @@ func (s *chainSubscriber) updateContract(tx sql.ChainUpdateTx, index types.Chain
 	state, err := tx.ContractState(fcid)
 	if err != nil && utils.IsErr(err, api.ErrContractNotFound) {
 		s.updateKnownContracts(fcid, false) // ignore unknown contracts
+		s.logUnknownContract(fcid, index)
 		return nil
 	} else if err != nil {
 		return fmt.Errorf("failed to get contract state: %w", err)
 	} else {
 		s.updateKnownContracts(fcid, true) // update known contracts
 	}
+	s.pendingMetrics.ContractsUpdated++
 
-	// define a helper function to update the contract state
-	updateState := func(update api.ContractState) (err error) {
-		if state != update {
-			err = tx.UpdateContractState(fcid, update)
-			if err == nil {
-				state = update
-			}
+	// the contract's revision after the update, on revert that's the one we
+	// reverted to
+	post := curr
+	if prev != nil {
+		post = prev
+	}
+
+	// define a helper function to update the contract state, transitions to
+	// the current state are skipped to avoid redundant writes and log lines
+	updateState := func(update api.ContractState, reason string) error {
+		if state == update {
+			return nil
+		} else if err := tx.UpdateContractState(fcid, update); err != nil {
+			return fmt.Errorf("failed to update contract state: %w", err)
 		}
-		return
+		s.logger.Infow(fmt.Sprintf("contract state changed: %s -> %s", state, update),
+			"fcid", fcid,
+			"old_state", state.String(),
+			"new_state", update.String(),
+			"reason", reason)
+		state = update
+		s.addContractStateEvent(fcid, update, post)
+		return nil
 	}
 
 	// handle reverts
 	if prev != nil {
 		// update state from 'active' -> 'pending'
 		if curr == nil {
-			if err := updateState(api.ContractStatePending); err != nil {
-				return fmt.Errorf("failed to update contract state: %w", err)
+			if err := updateState(api.ContractStatePending, "contract reverted"); err != nil {
+				return err
 			}
 		}
 
 		// reverted renewal: 'complete' -> 'active'
 		if curr != nil {
-			if err := tx.UpdateContract(fcid, index.Height, prev.revisionNumber, prev.fileSize); err != nil {
-				return fmt.Errorf("failed to revert contract: %w", err)
+			if s.acceptContractSize(fcid, index, prev) {
+				if err := tx.UpdateContract(fcid, index.Height, prev.revisionNumber, prev.fileSize); err != nil {
+					return fmt.Errorf("failed to revert contract: %w", err)
+				}
 			}
 			if state == api.ContractStateComplete {
-				if err := updateState(api.ContractStateActive); err != nil {
-					return fmt.Errorf("failed to update contract state: %w", err)
+				if err := updateState(api.ContractStateActive, "final revision reverted"); err != nil {
+					return err
 				}
-				s.logger.Infow("contract state changed: complete -> active",
-					"fcid", fcid,
-					"reason", "final revision reverted")
 			}
 		}
 
-		// reverted storage proof: 'complete/failed' -> 'active'
-		if resolved {
-			if err := updateState(api.ContractStateActive); err != nil {
-				return fmt.Errorf("failed to update contract state: %w", err)
+		// reverted storage proof: 'complete/failed' -> 'active', unless the
+		// contract's window already ended at the height we reverted to, an
+		// unresolved contract past its window end has failed
+		if resolved && prev.windowEnd <= index.Height {
+			if err := updateState(api.ContractStateFailed, "storage proof reverted after window end"); err != nil {
+				return err
 			}
-			if valid {
-				s.logger.Infow("contract state changed: complete -> active",
-					"fcid", fcid,
-					"reason", "storage proof reverted")
-			} else {
-				s.logger.Infow("contract state changed: failed -> active",
-					"fcid", fcid,
-					"reason", "storage proof reverted")
+		} else if resolved {
+			if err := updateState(api.ContractStateActive, "storage proof reverted"); err != nil {
+				return err
 			}
 		}
 
@@ -440,28 +1272,24 @@ func (s *chainSubscriber) updateContract(tx sql.ChainUpdateTx, index types.Chain
 	}
 
 	// handle apply
-	if err := tx.UpdateContract(fcid, index.Height, curr.revisionNumber, curr.fileSize); err != nil {
-		return fmt.Errorf("failed to update contract %v: %w", fcid, err)
+	if s.acceptContractSize(fcid, index, curr) {
+		if err := tx.UpdateContract(fcid, index.Height, curr.revisionNumber, curr.fileSize); err != nil {
+			return fmt.Errorf("failed to update contract %v: %w", fcid, err)
+		}
 	}
 
 	// update state from 'pending' -> 'active'
 	if state == api.ContractStatePending || state == api.ContractStateUnknown {
-		if err := updateState(api.ContractStateActive); err != nil {
-			return fmt.Errorf("failed to update contract state: %w", err)
+		if err := updateState(api.ContractStateActive, "contract confirmed"); err != nil {
+			return err
 		}
-		s.logger.Infow("contract state changed: pending -> active",
-			"fcid", fcid,
-			"reason", "contract confirmed")
 	}
 
 	// renewed: 'active' -> 'complete'
 	if curr.revisionNumber == types.MaxRevisionNumber && curr.fileSize == 0 {
-		if err := updateState(api.ContractStateComplete); err != nil {
-			return fmt.Errorf("failed to update contract state: %w", err)
+		if err := updateState(api.ContractStateComplete, "final revision confirmed"); err != nil {
+			return err
 		}
-		s.logger.Infow("contract state changed: active -> complete",
-			"fcid", fcid,
-			"reason", "final revision confirmed")
 	}
 
 	// storage proof: 'active' -> 'complete/failed'
@@ -470,24 +1298,84 @@ func (s *chainSubscriber) updateContract(tx sql.ChainUpdateTx, index types.Chain
 			return fmt.Errorf("failed to update contract proof height: %w", err)
 		}
 		if valid {
-			if err := updateState(api.ContractStateComplete); err != nil {
-				return fmt.Errorf("failed to update contract state: %w", err)
+			if err := updateState(api.ContractStateComplete, "storage proof valid"); err != nil {
+				return err
 			}
-			s.logger.Infow("contract state changed: active -> complete",
-				"fcid", fcid,
-				"reason", "storage proof valid")
 		} else {
-			if err := updateState(api.ContractStateFailed); err != nil {
-				return fmt.Errorf("failed to update contract state: %w", err)
+			if err := updateState(api.ContractStateFailed, "storage proof missed"); err != nil {
+				return err
 			}
-			s.logger.Infow("contract state changed: active -> failed",
-				"fcid", fcid,
-				"reason", "storage proof missed")
 		}
 	}
 	return nil
 }
 
+// acceptContractSize returns false if the revision's file size exceeds the max
+// contract size and oversized revisions are rejected, oversized revisions are
+// logged either way.
+func (s *chainSubscriber) acceptContractSize(fcid types.FileContractID, index types.ChainIndex, rev *revision) bool {
+	if s.maxContractSize == 0 || rev.fileSize <= s.maxContractSize {
+		return true
+	}
+	s.logger.Warnw("contract revision exceeds the max contract size",
+		"fcid", fcid,
+		"height", index.Height,
+		"revision_number", rev.revisionNumber,
+		"file_size", rev.fileSize,
+		"max_size", s.maxContractSize,
+		"rejected", s.rejectOversized)
+	return !s.rejectOversized
+}
+
+// logUnknownContract logs that an update of the given unknown contract was
+// skipped, if enabled and the contract wasn't logged recently.
+func (s *chainSubscriber) logUnknownContract(fcid types.FileContractID, index types.ChainIndex) {
+	if !s.logUnknownContracts {
+		return
+	}
+	now := s.clock.Now()
+	if last, ok := s.unknownLogged[fcid]; ok && now.Sub(last) < unknownContractLogInterval {
+		return
+	} else if s.unknownLogged == nil {
+		s.unknownLogged = make(map[types.FileContractID]time.Time)
+	}
+	s.unknownLogged[fcid] = now
+	s.logger.Debugw("skipping update of unknown contract", "fcid", fcid, "height", index.Height, "block_id", index.ID)
+}
+
+func (s *chainSubscriber) addContractStateEvent(fcid types.FileContractID, state api.ContractState, rev *revision) {
+	event, err := api.NewContractStateEvent(fcid, state, rev.revisionNumber, rev.fileSize, s.clock.Now())
+	if err != nil {
+		return // not every state has an event
+	}
+	s.pendingEvents = append(s.pendingEvents, event)
+}
+
+// reorgDepth returns the number of blocks that have to be reverted to get back
+// to the best chain, the given updates are the first batch of updates since
+// the subscriber's index. Counting stops once the max reorg depth is exceeded.
+func (s *chainSubscriber) reorgDepth(crus []chain.RevertUpdate, caus []chain.ApplyUpdate) (uint64, error) {
+	depth := uint64(len(crus))
+	for len(caus) == 0 && len(crus) == updatesBatchSize && depth <= s.maxReorgDepth {
+		var err error
+		crus, caus, err = s.cm.UpdatesSince(crus[len(crus)-1].State.Index, updatesBatchSize)
+		if err != nil {
+			return 0, fmt.Errorf("%w: failed to fetch updates: %w", ErrChainUpdateConflict, err)
+		}
+		depth += uint64(len(crus))
+	}
+	return depth, nil
+}
+
+// chainIndex returns the index the next sync starts from, in dry-run mode
+// that's the index the dry run got to.
+func (s *chainSubscriber) chainIndex() (types.ChainIndex, error) {
+	if s.dryRun && s.dryRunIndex != nil {
+		return *s.dryRunIndex, nil
+	}
+	return s.cs.ChainIndex(s.shutdownCtx)
+}
+
 func (s *chainSubscriber) isClosed() bool {
 	select {
 	case <-s.shutdownCtx.Done():
@@ -497,6 +1385,30 @@ func (s *chainSubscriber) isClosed() bool {
 	return false
 }
 
+func (s *chainSubscriber) halt(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.haltErr = err
+}
+
+func (s *chainSubscriber) isHalted() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.haltErr != nil
+}
+
+func (s *chainSubscriber) isReorgApproved() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reorgApproved
+}
+
+func (s *chainSubscriber) isPaused() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused
+}
+
 func (s *chainSubscriber) isKnownContract(fcid types.FileContractID) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -517,10 +1429,12 @@ func v1ContractUpdate(fce types.FileContractElement, rev *types.FileContractElem
 	curr := &revision{
 		revisionNumber: fce.FileContract.RevisionNumber,
 		fileSize:       fce.FileContract.Filesize,
+		windowEnd:      fce.FileContract.WindowEnd,
 	}
 	if rev != nil {
 		curr.revisionNumber = rev.FileContract.RevisionNumber
 		curr.fileSize = rev.FileContract.Filesize
+		curr.windowEnd = rev.FileContract.WindowEnd
 	}
 	return contractUpdate{
 		fcid:     types.FileContractID(fce.ID),
@@ -535,10 +1449,12 @@ func v2ContractUpdate(fce types.V2FileContractElement, rev *types.V2FileContract
 	curr := &revision{
 		revisionNumber: fce.V2FileContract.RevisionNumber,
 		fileSize:       fce.V2FileContract.Filesize,
+		windowEnd:      fce.V2FileContract.ExpirationHeight,
 	}
 	if rev != nil {
 		curr.revisionNumber = rev.V2FileContract.RevisionNumber
 		curr.fileSize = rev.V2FileContract.Filesize
+		curr.windowEnd = rev.V2FileContract.ExpirationHeight
 	}
 
 	var resolved, valid bool
@@ -564,3 +1480,36 @@ func v2ContractUpdate(fce types.V2FileContractElement, rev *types.V2FileContract
 		valid:    valid,
 	}
 }
+
+// revertedContractUpdate turns the given contract update into one that reverts
+// it, the revision prior to the update becomes 'prev' and if the contract was
+// created in the reverted block 'curr' is unset.
+func revertedContractUpdate(cu contractUpdate, created bool, prev revision) contractUpdate {
+	cu.prev = &prev
+	if created {
+		cu.curr = nil
+	}
+	return cu
+}
+
+// dedupHostUpdateEvents removes all but the last host update event of every
+// host from the given events, the order of the remaining events is preserved.
+func dedupHostUpdateEvents(events []webhooks.Event) []webhooks.Event {
+	seen := make(map[types.PublicKey]struct{})
+	deduped := make([]webhooks.Event, 0, len(events))
+	for i := len(events) - 1; i >= 0; i-- {
+		if hu, ok := events[i].Payload.(api.EventHostUpdate); ok {
+			if _, ok := seen[hu.HostKey]; ok {
+				continue
+			}
+			seen[hu.HostKey] = struct{}{}
+		}
+		deduped = append(deduped, events[i])
+	}
+	slices.Reverse(deduped)
+	return deduped
+}
+
+func isRetryableSyncErr(err error) bool {
+	return errors.Is(err, ErrChainUpdateConflict) || errors.Is(err, ErrCommitFailed) || errors.Is(err, ErrStoreUnavailable)
+}
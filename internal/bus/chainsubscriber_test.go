@@ -0,0 +1,1831 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/consensus"
+	rhpv2 "go.sia.tech/core/rhp/v2"
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils"
+	"go.sia.tech/coreutils/chain"
+	"go.sia.tech/coreutils/testutil"
+	"go.sia.tech/coreutils/wallet"
+	"go.sia.tech/renterd/api"
+	"go.sia.tech/renterd/internal/stores"
+	"go.sia.tech/renterd/stores/sql"
+	"go.sia.tech/renterd/webhooks"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestChainSubscriberContractStates(t *testing.T) {
+	t.Run("Wallet", func(t *testing.T) { testChainSubscriberContractStates(t, true) })
+	t.Run("NoWallet", func(t *testing.T) { testChainSubscriberContractStates(t, false) })
+}
+
+func testChainSubscriberContractStates(t *testing.T, withWallet bool) {
+	// prepare a genesis block that funds the renter
+	renterKey := types.GeneratePrivateKey()
+	renterAddr := types.StandardUnlockHash(renterKey.PublicKey())
+	network, genesis := testutil.Network()
+	genesis.Transactions = append(genesis.Transactions, types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: renterAddr, Value: types.Siacoins(1e6)}},
+	})
+	genesisOutput := genesis.Transactions[len(genesis.Transactions)-1].SiacoinOutputID(0)
+
+	// create two chain managers, the second one is used to trigger reorgs
+	cm := newTestChainManager(t, network, genesis)
+	cm2 := newTestChainManager(t, network, genesis)
+
+	// create the subscriber, the wallet is optional
+	var w Wallet
+	if withWallet {
+		sw, err := wallet.NewSingleAddressWallet(renterKey, cm, testutil.NewEphemeralWalletStore())
+		if err != nil {
+			t.Fatal(err)
+		}
+		w = sw
+	}
+	cs := stores.NewEphemeralChainStore()
+	s := newTestChainSubscriber(cm, cs, w)
+
+	// define helpers
+	assertState := func(fcid types.FileContractID, expected api.ContractState) {
+		t.Helper()
+		if err := s.sync(); err != nil {
+			t.Fatal(err)
+		} else if index, _ := cs.ChainIndex(context.Background()); index != cm.Tip() {
+			t.Fatalf("expected index %v, got %v", cm.Tip(), index)
+		} else if c, ok := cs.Contract(fcid); !ok {
+			t.Fatal("contract not found")
+		} else if c.State != expected {
+			t.Fatalf("expected state %v, got %v", expected, c.State)
+		}
+	}
+	assertOutput := func(id types.Hash256) {
+		t.Helper()
+		outputs := cs.WalletOutputs()
+		if !withWallet {
+			if len(outputs) != 0 {
+				t.Fatalf("expected no outputs, got %v", len(outputs))
+			}
+			return
+		}
+		if len(outputs) != 1 {
+			t.Fatalf("expected 1 output, got %v", len(outputs))
+		} else if outputs[0].ID != id {
+			t.Fatal("unexpected output", outputs[0].ID)
+		}
+	}
+
+	// prepare a contract formation transaction, we mine a block first to get
+	// past the hardfork heights so the signature remains valid after a reorg
+	mineTestBlocks(t, cm, types.VoidAddress, 1)
+	host := rhpv2.HostSettings{WindowSize: 5, Address: types.VoidAddress}
+	fc := rhpv2.PrepareContractFormation(renterKey.PublicKey(), types.GeneratePrivateKey().PublicKey(), types.Siacoins(1), types.Siacoins(1), cm.Tip().Height+5, host, renterAddr)
+	txn := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{
+			ParentID:         genesisOutput,
+			UnlockConditions: types.StandardUnlockConditions(renterKey.PublicKey()),
+		}},
+		SiacoinOutputs: []types.SiacoinOutput{{
+			Address: renterAddr,
+			Value:   types.Siacoins(1e6).Sub(fc.Payout),
+		}},
+		FileContracts: []types.FileContract{fc},
+		Signatures: []types.TransactionSignature{{
+			ParentID:      types.Hash256(genesisOutput),
+			CoveredFields: types.CoveredFields{WholeTransaction: true},
+		}},
+	}
+	sig := renterKey.SignHash(cm.TipState().WholeSigHash(txn, types.Hash256(genesisOutput), 0, 0, nil))
+	txn.Signatures[0].Signature = sig[:]
+	fcid := txn.FileContractID(0)
+
+	// add the contract to the store and sync, it should be pending
+	cs.AddContract(fcid, stores.EphemeralContract{WindowEnd: fc.WindowEnd})
+	assertState(fcid, api.ContractStatePending)
+
+	// the subscriber hasn't seen the contract on chain yet
+	if s.IsTracking(fcid) || s.TrackedCount() != 0 {
+		t.Fatal("expected contract to not be tracked")
+	}
+
+	// confirm the contract, it should be active
+	if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+	mineTestBlocks(t, cm, types.VoidAddress, 1)
+	assertState(fcid, api.ContractStateActive)
+
+	// the subscriber should be tracking the contract now
+	if !s.IsTracking(fcid) || s.TrackedCount() != 1 {
+		t.Fatal("expected contract to be tracked")
+	}
+
+	// assert the wallet updates were applied
+	assertOutput(types.Hash256(txn.SiacoinOutputID(0)))
+
+	// reorg the block that confirmed the contract, it should be pending again
+	if err := cm.AddBlocks(mineTestBlocks(t, cm2, types.Address{1}, 3)); err != nil {
+		t.Fatal(err)
+	} else if cm.Tip() != cm2.Tip() {
+		t.Fatal("expected reorg")
+	}
+	assertState(fcid, api.ContractStatePending)
+
+	// the wallet should have its genesis output back
+	assertOutput(types.Hash256(genesisOutput))
+
+	// confirm the contract again
+	if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+	mineTestBlocks(t, cm, types.VoidAddress, 1)
+	assertState(fcid, api.ContractStateActive)
+
+	// mine until the proof window has passed, it should be failed
+	mineTestBlocks(t, cm, types.VoidAddress, int(fc.WindowEnd-cm.Tip().Height))
+	assertState(fcid, api.ContractStateFailed)
+	if c, _ := cs.Contract(fcid); c.ProofHeight != fc.WindowEnd {
+		t.Fatalf("expected proof height %v, got %v", fc.WindowEnd, c.ProofHeight)
+	}
+}
+
+func TestChainSubscriberResync(t *testing.T) {
+	// prepare a genesis block that funds the renter
+	renterKey := types.GeneratePrivateKey()
+	renterAddr := types.StandardUnlockHash(renterKey.PublicKey())
+	network, genesis := testutil.Network()
+	genesis.Transactions = append(genesis.Transactions, types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: renterAddr, Value: types.Siacoins(1e6)}},
+	})
+	genesisOutput := genesis.Transactions[len(genesis.Transactions)-1].SiacoinOutputID(0)
+
+	// create a subscriber with a wallet
+	cm := newTestChainManager(t, network, genesis)
+	cm2 := newTestChainManager(t, network, genesis)
+	w, err := wallet.NewSingleAddressWallet(renterKey, cm, testutil.NewEphemeralWalletStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := stores.NewEphemeralChainStore()
+	s := newTestChainSubscriber(cm, cs, w)
+
+	// form a contract that spends the genesis output
+	mineTestBlocks(t, cm, types.VoidAddress, 1)
+	host := rhpv2.HostSettings{WindowSize: 5, Address: types.VoidAddress}
+	fc := rhpv2.PrepareContractFormation(renterKey.PublicKey(), types.GeneratePrivateKey().PublicKey(), types.Siacoins(1), types.Siacoins(1), cm.Tip().Height+50, host, renterAddr)
+	txn := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{
+			ParentID:         genesisOutput,
+			UnlockConditions: types.StandardUnlockConditions(renterKey.PublicKey()),
+		}},
+		SiacoinOutputs: []types.SiacoinOutput{{
+			Address: renterAddr,
+			Value:   types.Siacoins(1e6).Sub(fc.Payout),
+		}},
+		FileContracts: []types.FileContract{fc},
+		Signatures: []types.TransactionSignature{{
+			ParentID:      types.Hash256(genesisOutput),
+			CoveredFields: types.CoveredFields{WholeTransaction: true},
+		}},
+	}
+	sig := renterKey.SignHash(cm.TipState().WholeSigHash(txn, types.Hash256(genesisOutput), 0, 0, nil))
+	txn.Signatures[0].Signature = sig[:]
+	fcid := txn.FileContractID(0)
+	cs.AddContract(fcid, stores.EphemeralContract{WindowEnd: fc.WindowEnd})
+
+	// confirm the contract and mine some more blocks on top
+	from := cm.Tip()
+	if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+	mineTestBlocks(t, cm, types.VoidAddress, 5)
+	if err := s.sync(); err != nil {
+		t.Fatal(err)
+	} else if c, _ := cs.Contract(fcid); c.State != api.ContractStateActive {
+		t.Fatalf("expected state %v, got %v", api.ContractStateActive, c.State)
+	}
+	outputs, events := cs.WalletOutputs(), cs.WalletEvents()
+
+	// corrupt the contract's state and resync from before it was confirmed
+	cs.AddContract(fcid, stores.EphemeralContract{WindowEnd: fc.WindowEnd})
+	if err := s.Resync(from); err != nil {
+		t.Fatal(err)
+	} else if c, _ := cs.Contract(fcid); c.State != api.ContractStateActive || c.RevisionNumber != fc.RevisionNumber {
+		t.Fatalf("unexpected contract %+v", c)
+	} else if index, _ := cs.ChainIndex(context.Background()); index != cm.Tip() {
+		t.Fatalf("expected index %v, got %v", cm.Tip(), index)
+	}
+
+	// the wallet shouldn't have processed the blocks again
+	if !reflect.DeepEqual(cs.WalletOutputs(), outputs) {
+		t.Fatal("unexpected outputs", cs.WalletOutputs())
+	} else if len(cs.WalletEvents()) != len(events) {
+		t.Fatalf("expected %v events, got %v", len(events), len(cs.WalletEvents()))
+	}
+
+	// syncing afterwards continues where the resync left off
+	mineTestBlocks(t, cm, types.VoidAddress, 1)
+	if err := s.sync(); err != nil {
+		t.Fatal(err)
+	} else if index, _ := cs.ChainIndex(context.Background()); index != cm.Tip() {
+		t.Fatalf("expected index %v, got %v", cm.Tip(), index)
+	}
+
+	// indices that are ahead of the subscriber or not on the best chain are
+	// rejected
+	orphan := mineTestBlocks(t, cm2, types.Address{1}, 1)[0]
+	if err := s.Resync(types.ChainIndex{Height: cm.Tip().Height + 1}); err == nil {
+		t.Fatal("expected error")
+	} else if err := s.Resync(types.ChainIndex{Height: 1, ID: orphan.ID()}); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestChainSubscriberRevertResolution(t *testing.T) {
+	network, genesis := testutil.Network()
+	cm := newTestChainManager(t, network, genesis)
+
+	cs := stores.NewEphemeralChainStore()
+	s := newTestChainSubscriber(cm, cs, nil)
+
+	const windowEnd = 10
+	rev := &revision{revisionNumber: 1, windowEnd: windowEnd}
+
+	// define helpers, every update is followed by updating the failed
+	// contracts like it's done at the end of every batch of chain updates
+	update := func(fcid types.FileContractID, height uint64, fn func(tx sql.ChainUpdateTx) error) {
+		t.Helper()
+		s.pendingEvents = s.pendingEvents[:0]
+		if err := cs.ProcessChainUpdate(context.Background(), func(tx sql.ChainUpdateTx) error {
+			if err := fn(tx); err != nil {
+				return err
+			}
+			return tx.UpdateFailedContracts(height)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resolve := func(fcid types.FileContractID, height uint64, valid bool) {
+		t.Helper()
+		update(fcid, height, func(tx sql.ChainUpdateTx) error {
+			return s.updateContract(tx, types.ChainIndex{Height: height}, fcid, nil, rev, true, valid)
+		})
+	}
+	revertResolution := func(fcid types.FileContractID, height uint64) {
+		t.Helper()
+		update(fcid, height, func(tx sql.ChainUpdateTx) error {
+			return s.updateContract(tx, types.ChainIndex{Height: height}, fcid, rev, rev, true, false)
+		})
+	}
+	mine := func(fcid types.FileContractID, height uint64) {
+		t.Helper()
+		update(fcid, height, func(sql.ChainUpdateTx) error { return nil })
+	}
+	assertState := func(fcid types.FileContractID, expected api.ContractState) {
+		t.Helper()
+		if c, ok := cs.Contract(fcid); !ok {
+			t.Fatal("contract not found")
+		} else if c.State != expected {
+			t.Fatalf("expected state %v, got %v", expected, c.State)
+		}
+	}
+
+	// a valid proof that is reverted within the window makes the contract
+	// active again, if no other proof is found it fails once the window ends
+	fcid := types.FileContractID{1}
+	cs.AddContract(fcid, stores.EphemeralContract{State: api.ContractStateActive, WindowEnd: windowEnd})
+	resolve(fcid, windowEnd-2, true)
+	assertState(fcid, api.ContractStateComplete)
+	revertResolution(fcid, windowEnd-3)
+	assertState(fcid, api.ContractStateActive)
+	mine(fcid, windowEnd)
+	assertState(fcid, api.ContractStateFailed)
+
+	// a missed proof that is reverted into the window makes the contract
+	// active again, a valid proof completes it
+	fcid = types.FileContractID{2}
+	cs.AddContract(fcid, stores.EphemeralContract{State: api.ContractStateActive, WindowEnd: windowEnd})
+	resolve(fcid, windowEnd, false)
+	assertState(fcid, api.ContractStateFailed)
+	revertResolution(fcid, windowEnd-1)
+	assertState(fcid, api.ContractStateActive)
+	resolve(fcid, windowEnd-1, true)
+	assertState(fcid, api.ContractStateComplete)
+
+	// an expiration that is reverted to a height past the window end leaves
+	// the contract failed without flapping through 'active'
+	fcid = types.FileContractID{3}
+	cs.AddContract(fcid, stores.EphemeralContract{State: api.ContractStateActive, WindowEnd: windowEnd})
+	mine(fcid, windowEnd)
+	assertState(fcid, api.ContractStateFailed)
+	resolve(fcid, windowEnd+2, false)
+	assertState(fcid, api.ContractStateFailed)
+	revertResolution(fcid, windowEnd+1)
+	assertState(fcid, api.ContractStateFailed)
+	if len(s.pendingEvents) != 0 {
+		t.Fatalf("expected no events, got %v", len(s.pendingEvents))
+	}
+
+	// a valid proof that is reverted to a height past the window end fails
+	// the contract
+	fcid = types.FileContractID{4}
+	cs.AddContract(fcid, stores.EphemeralContract{State: api.ContractStateActive, WindowEnd: windowEnd})
+	resolve(fcid, windowEnd-1, true)
+	assertState(fcid, api.ContractStateComplete)
+	revertResolution(fcid, windowEnd)
+	assertState(fcid, api.ContractStateFailed)
+}
+
+func TestChainSubscriberContractStateEvents(t *testing.T) {
+	network, genesis := testutil.Network()
+	cm := newTestChainManager(t, network, genesis)
+
+	cs := stores.NewEphemeralChainStore()
+	s := newTestChainSubscriber(cm, cs, nil)
+
+	fcid := types.FileContractID{1}
+	cs.AddContract(fcid, stores.EphemeralContract{WindowEnd: 10})
+
+	// define a helper to update the contract and return the state event
+	update := func(prev, curr *revision) api.EventContractStateUpdate {
+		t.Helper()
+		s.pendingEvents = s.pendingEvents[:0]
+		if err := cs.ProcessChainUpdate(context.Background(), func(tx sql.ChainUpdateTx) error {
+			return s.updateContract(tx, types.ChainIndex{Height: 1}, fcid, prev, curr, false, false)
+		}); err != nil {
+			t.Fatal(err)
+		} else if len(s.pendingEvents) != 1 {
+			t.Fatalf("expected 1 event, got %v", len(s.pendingEvents))
+		}
+		return s.pendingEvents[0].Payload.(api.EventContractStateUpdate)
+	}
+	assertEvent := func(e api.EventContractStateUpdate, state api.ContractState, revisionNumber, fileSize uint64) {
+		t.Helper()
+		if e.ContractID != fcid || e.State != state {
+			t.Fatalf("unexpected event %+v", e)
+		} else if e.RevisionNumber != revisionNumber || e.FileSize != fileSize {
+			t.Fatalf("expected revision %v and size %v, got %v and %v", revisionNumber, fileSize, e.RevisionNumber, e.FileSize)
+		}
+	}
+
+	// confirming the contract reports the confirmed revision
+	rev := &revision{revisionNumber: 1, fileSize: 100, windowEnd: 10}
+	assertEvent(update(nil, rev), api.ContractStateActive, 1, 100)
+
+	// the final revision shrinks the contract to zero
+	final := &revision{revisionNumber: types.MaxRevisionNumber, windowEnd: 10}
+	assertEvent(update(nil, final), api.ContractStateComplete, types.MaxRevisionNumber, 0)
+
+	// reverting the final revision reports the revision we reverted to
+	assertEvent(update(rev, final), api.ContractStateActive, 1, 100)
+}
+
+func TestChainSubscriberMaxReorgDepth(t *testing.T) {
+	network, genesis := testutil.Network()
+	cm := newTestChainManager(t, network, genesis)
+	cm2 := newTestChainManager(t, network, genesis)
+
+	// create a subscriber that allows reorgs of at most 2 blocks
+	cs := stores.NewEphemeralChainStore()
+	s := newTestChainSubscriber(cm, cs, nil)
+	var tooDeep uint64
+	WithMaxReorgDepth(2, func(_ types.ChainIndex, depth uint64) { tooDeep = depth })(s)
+
+	// sync 3 blocks
+	mineTestBlocks(t, cm, types.VoidAddress, 3)
+	if err := s.sync(); err != nil {
+		t.Fatal(err)
+	}
+	synced := cm.Tip()
+
+	// reorg all of them, the sync should be aborted
+	if err := cm.AddBlocks(mineTestBlocks(t, cm2, types.Address{1}, 4)); err != nil {
+		t.Fatal(err)
+	} else if err := s.sync(); !errors.Is(err, ErrReorgTooDeep) {
+		t.Fatal("unexpected error", err)
+	} else if tooDeep != 3 {
+		t.Fatal("unexpected depth", tooDeep)
+	} else if index, _ := cs.ChainIndex(context.Background()); index != synced {
+		t.Fatal("unexpected index", index)
+	}
+
+	// without a limit the sync should succeed
+	s.maxReorgDepth = 0
+	if err := s.sync(); err != nil {
+		t.Fatal(err)
+	} else if index, _ := cs.ChainIndex(context.Background()); index != cm.Tip() {
+		t.Fatal("unexpected index", index)
+	} else if m := s.Metrics(); m.BlocksApplied != 8 || m.BlocksReverted != 3 {
+		t.Fatal("unexpected metrics", m)
+	}
+}
+
+func TestChainSubscriberMaxReorgDepthBatches(t *testing.T) {
+	cm := newFakeChainManager(t)
+
+	// create a subscriber that allows reorgs that are deeper than a batch
+	cs := stores.NewEphemeralChainStore()
+	var tooDeep atomic.Uint64
+	s, err := NewChainSubscriber(nil, cm, cs, nil, time.Hour, zap.NewNop(), WithMaxReorgDepth(updatesBatchSize+1, func(_ types.ChainIndex, depth uint64) {
+		tooDeep.Store(depth)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown(context.Background())
+
+	// define a helper to wait for a condition
+	waitFor := func(fn func() bool) {
+		t.Helper()
+		for i := 0; i < 100; i++ {
+			if fn() {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatal("condition not met", s.Metrics())
+	}
+
+	// mine more blocks than fit in a batch
+	cm.MineBlocks(updatesBatchSize + 10)
+	waitFor(func() bool {
+		index, _ := cs.ChainIndex(context.Background())
+		return index == cm.Tip()
+	})
+	synced := cm.Tip()
+
+	// reorg more blocks than fit in a batch and more than the max depth, the
+	// sync should be aborted before anything is reverted
+	cm.Reorg(updatesBatchSize+5, updatesBatchSize+6)
+	waitFor(func() bool { return tooDeep.Load() > 0 })
+	if depth := tooDeep.Load(); depth != updatesBatchSize+5 {
+		t.Fatal("unexpected depth", depth)
+	} else if index, _ := cs.ChainIndex(context.Background()); index != synced {
+		t.Fatal("unexpected index", index)
+	} else if m := s.Metrics(); m.BlocksReverted != 0 {
+		t.Fatal("unexpected metrics", m)
+	}
+
+	// the subscriber should be halted, new blocks are ignored
+	cm.MineBlocks(1)
+	time.Sleep(200 * time.Millisecond)
+	if index, _ := cs.ChainIndex(context.Background()); index != synced {
+		t.Fatal("unexpected index", index)
+	} else if m := s.Metrics(); m.BlocksReverted != 0 {
+		t.Fatal("unexpected metrics", m)
+	}
+
+	// resume, the reorg should be processed
+	s.Resume()
+	waitFor(func() bool {
+		index, _ := cs.ChainIndex(context.Background())
+		return index == cm.Tip()
+	})
+	if m := s.Metrics(); m.BlocksReverted != updatesBatchSize+5 {
+		t.Fatal("unexpected metrics", m)
+	}
+
+	// the approval only applies to the reorg that was processed
+	tooDeep.Store(0)
+	cm.Reorg(updatesBatchSize+2, 1)
+	waitFor(func() bool { return tooDeep.Load() > 0 })
+	if depth := tooDeep.Load(); depth != updatesBatchSize+2 {
+		t.Fatal("unexpected depth", depth)
+	}
+}
+
+func TestChainSubscriberAnnouncementMaxAge(t *testing.T) {
+	network, genesis := testutil.Network()
+	cm := newTestChainManager(t, network, genesis)
+
+	cs := stores.NewEphemeralChainStore()
+	s := newTestChainSubscriber(cm, cs, nil)
+	clock := &mockClock{}
+	WithClock(clock)(s)
+
+	// define a helper to announce a host
+	announce := func() types.PublicKey {
+		t.Helper()
+		sk := types.GeneratePrivateKey()
+		ha := chain.HostAnnouncement{NetAddress: "foo.bar:1234"}
+		if _, err := cm.AddPoolTransactions([]types.Transaction{{ArbitraryData: [][]byte{ha.ToArbitraryData(sk)}}}); err != nil {
+			t.Fatal(err)
+		}
+		b := mineTestBlocks(t, cm, types.VoidAddress, 1)[0]
+		clock.now = b.Timestamp.Add(s.announcementMaxAge)
+		return sk.PublicKey()
+	}
+
+	// announce a host that is just old enough to be recorded
+	hk1 := announce()
+	if err := s.sync(); err != nil {
+		t.Fatal(err)
+	} else if _, ok := cs.Host(hk1); !ok {
+		t.Fatal("expected host to be recorded")
+	}
+
+	// announce a host that is too old
+	hk2 := announce()
+	clock.now = clock.now.Add(time.Second)
+	if err := s.sync(); err != nil {
+		t.Fatal(err)
+	} else if _, ok := cs.Host(hk2); ok {
+		t.Fatal("expected host to be ignored")
+	}
+
+	// a max age of zero or less is rejected
+	if err := s.SetAnnouncementMaxAge(0); !errors.Is(err, errInvalidAnnouncementMaxAge) {
+		t.Fatal("unexpected error", err)
+	} else if err := s.SetAnnouncementMaxAge(-time.Hour); !errors.Is(err, errInvalidAnnouncementMaxAge) {
+		t.Fatal("unexpected error", err)
+	}
+
+	// the same goes for the max age passed to the constructor
+	for _, d := range []time.Duration{0, -time.Hour} {
+		if _, err := NewChainSubscriber(nil, cm, cs, nil, d, zap.NewNop()); !errors.Is(err, errInvalidAnnouncementMaxAge) {
+			t.Fatal("unexpected error", err)
+		}
+	}
+
+	// announce a host that is too old and increase the max age, the
+	// announcement should be recorded
+	hk3 := announce()
+	clock.now = clock.now.Add(time.Second)
+	if err := s.SetAnnouncementMaxAge(s.announcementMaxAge + time.Minute); err != nil {
+		t.Fatal(err)
+	} else if err := s.sync(); err != nil {
+		t.Fatal(err)
+	} else if _, ok := cs.Host(hk3); !ok {
+		t.Fatal("expected host to be recorded")
+	}
+}
+
+func TestChainSubscriberAnnouncementBackfill(t *testing.T) {
+	network, genesis := testutil.Network()
+	cm := newTestChainManager(t, network, genesis)
+
+	cs := stores.NewEphemeralChainStore()
+	s := newTestChainSubscriber(cm, cs, nil)
+	clock := &mockClock{}
+	WithClock(clock)(s)
+	WithAnnouncementBackfill(true)(s)
+
+	// define a helper to announce a host that is too old to be recorded
+	announce := func() types.PublicKey {
+		t.Helper()
+		sk := types.GeneratePrivateKey()
+		ha := chain.HostAnnouncement{NetAddress: "foo.bar:1234"}
+		if _, err := cm.AddPoolTransactions([]types.Transaction{{ArbitraryData: [][]byte{ha.ToArbitraryData(sk)}}}); err != nil {
+			t.Fatal(err)
+		}
+		b := mineTestBlocks(t, cm, types.VoidAddress, 1)[0]
+		clock.now = b.Timestamp.Add(s.announcementMaxAge + time.Second)
+		return sk.PublicKey()
+	}
+
+	// announce a host before the initial sync, it should be recorded
+	// regardless of its age
+	hk1 := announce()
+	if err := s.sync(); err != nil {
+		t.Fatal(err)
+	} else if _, ok := cs.Host(hk1); !ok {
+		t.Fatal("expected host to be recorded")
+	}
+
+	// announce a host after the initial sync, the age filter applies again
+	hk2 := announce()
+	if err := s.sync(); err != nil {
+		t.Fatal(err)
+	} else if _, ok := cs.Host(hk2); ok {
+		t.Fatal("expected host to be ignored")
+	}
+}
+
+func TestChainSubscriberAcceptAnnouncement(t *testing.T) {
+	network, genesis := testutil.Network()
+	cm := newTestChainManager(t, network, genesis)
+
+	// only accept hosts on port 9982, the predicate shouldn't be called
+	// while a transaction is open
+	cs := &txTrackingChainStore{EphemeralChainStore: stores.NewEphemeralChainStore()}
+	s := newTestChainSubscriber(cm, cs, nil)
+	var calledInTx atomic.Bool
+	WithAcceptAnnouncement(func(_ types.PublicKey, ha chain.HostAnnouncement, _ uint64) bool {
+		if cs.inTx.Load() {
+			calledInTx.Store(true)
+		}
+		return strings.HasSuffix(ha.NetAddress, ":9982")
+	})(s)
+
+	// announce two hosts
+	sk1, sk2 := types.GeneratePrivateKey(), types.GeneratePrivateKey()
+	if _, err := cm.AddPoolTransactions([]types.Transaction{{ArbitraryData: [][]byte{
+		chain.HostAnnouncement{NetAddress: "foo.bar:9982"}.ToArbitraryData(sk1),
+		chain.HostAnnouncement{NetAddress: "foo.bar:1234"}.ToArbitraryData(sk2),
+	}}}); err != nil {
+		t.Fatal(err)
+	}
+	mineTestBlocks(t, cm, types.VoidAddress, 1)
+
+	// assert only the first host was recorded
+	if err := s.sync(); err != nil {
+		t.Fatal(err)
+	} else if _, ok := cs.Host(sk1.PublicKey()); !ok {
+		t.Fatal("expected host to be recorded")
+	} else if _, ok := cs.Host(sk2.PublicKey()); ok {
+		t.Fatal("expected host to be ignored")
+	} else if calledInTx.Load() {
+		t.Fatal("predicate was called within the transaction")
+	}
+}
+
+func TestChainSubscriberRetry(t *testing.T) {
+	network, genesis := testutil.Network()
+	cm := newTestChainManager(t, network, genesis)
+	mineTestBlocks(t, cm, types.VoidAddress, 1)
+
+	// create a store that fails to commit the first 3 updates
+	cs := &failingChainStore{EphemeralChainStore: stores.NewEphemeralChainStore(), failures: 3}
+	s := newTestChainSubscriber(cm, cs, nil)
+	clock := &mockClock{}
+	WithClock(clock)(s)
+
+	// sync, the subscriber should have waited between every attempt
+	if err := s.syncWithRetry(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(clock.slept, s.retryTxIntervals[:3]) {
+		t.Fatal("unexpected sleeps", clock.slept)
+	} else if m := s.Metrics(); m.Retries != 3 || m.BlocksApplied != 2 {
+		t.Fatal("unexpected metrics", m)
+	} else if index, _ := cs.ChainIndex(context.Background()); index != cm.Tip() {
+		t.Fatal("unexpected index", index)
+	}
+
+	// the streak should have been reset by the successful sync
+	if m := s.Metrics(); m.RetryStreak != 0 || m.RetriesExhausted != 0 {
+		t.Fatal("unexpected metrics", m)
+	}
+
+	// fail more often than we retry, the sync should fail
+	mineTestBlocks(t, cm, types.VoidAddress, 1)
+	cs.failures = len(s.retryTxIntervals) + 1
+	if err := s.syncWithRetry(); !errors.Is(err, ErrCommitFailed) {
+		t.Fatal("unexpected error", err)
+	} else if m := s.Metrics(); m.RetryStreak != uint64(len(s.retryTxIntervals)) || m.RetriesExhausted != 1 {
+		t.Fatal("unexpected metrics", m)
+	}
+
+	// recover after a single failure, the streak should be reset
+	cs.failures = 1
+	if err := s.syncWithRetry(); err != nil {
+		t.Fatal(err)
+	} else if m := s.Metrics(); m.RetryStreak != 0 || m.RetriesExhausted != 1 || m.Retries != uint64(3+len(s.retryTxIntervals)+1) {
+		t.Fatal("unexpected metrics", m)
+	}
+}
+
+func TestChainSubscriberRetriesExhausted(t *testing.T) {
+	cm := newFakeChainManager(t)
+
+	// create a subscriber using a store that fails more often than it retries
+	cs := &failingChainStore{EphemeralChainStore: stores.NewEphemeralChainStore(), failures: len(defaultRetryTxIntervals) + 1}
+	s, err := NewChainSubscriber(nil, cm, cs, nil, time.Hour, zap.NewNop(), WithClock(&mockClock{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown(context.Background())
+
+	// define a helper to wait for a condition
+	waitFor := func(fn func() bool) {
+		t.Helper()
+		for i := 0; i < 100; i++ {
+			if fn() {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatal("condition not met", s.Metrics())
+	}
+
+	// mine a block, the sync should give up without crashing the subscriber
+	cm.MineBlocks(1)
+	waitFor(func() bool { return s.Metrics().RetriesExhausted == 1 })
+
+	// the next sync signal tries again
+	cm.MineBlocks(1)
+	waitFor(func() bool {
+		index, _ := cs.ChainIndex(context.Background())
+		return index == cm.Tip()
+	})
+	if m := s.Metrics(); m.RetriesExhausted != 1 || m.RetryStreak != 0 {
+		t.Fatal("unexpected metrics", m)
+	}
+}
+
+func TestChainSubscriberStallDetector(t *testing.T) {
+	cm := newFakeChainManager(t)
+
+	// create a subscriber that halts after two failed syncs using a store
+	// that fails to return the chain index for two syncs
+	cs := &failingChainIndexStore{EphemeralChainStore: stores.NewEphemeralChainStore(), failures: 2 * (len(defaultRetryTxIntervals) + 1)}
+	var stalled atomic.Int64
+	s, err := NewChainSubscriber(nil, cm, cs, nil, time.Hour, zap.NewNop(), WithClock(&mockClock{}), WithStallDetector(2, true, func(failures int, err error) {
+		if errors.Is(err, ErrStoreUnavailable) {
+			stalled.Store(int64(failures))
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown(context.Background())
+
+	// define a helper to wait for a condition
+	waitFor := func(fn func() bool) {
+		t.Helper()
+		for i := 0; i < 100; i++ {
+			if fn() {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatal("condition not met", s.Metrics())
+	}
+
+	// mine a block, the first failed sync isn't a stall yet
+	cm.MineBlocks(1)
+	waitFor(func() bool { return s.Metrics().RetriesExhausted == 1 })
+	if n := stalled.Load(); n != 0 {
+		t.Fatal("unexpected stall", n)
+	}
+
+	// mine another block, the second failed sync is
+	cm.MineBlocks(1)
+	waitFor(func() bool { return stalled.Load() == 2 })
+
+	// the subscriber should be halted
+	cm.MineBlocks(1)
+	time.Sleep(200 * time.Millisecond)
+	if m := s.Metrics(); m.RetriesExhausted != 2 || m.BlocksApplied != 0 {
+		t.Fatal("unexpected metrics", m)
+	}
+
+	// resume, the subscriber should catch up
+	s.Resume()
+	waitFor(func() bool {
+		index, _ := cs.ChainIndex(context.Background())
+		return index == cm.Tip()
+	})
+}
+
+func TestChainSubscriberErrorClassification(t *testing.T) {
+	cm := newFakeChainManager(t)
+	cm.MineBlocks(1)
+
+	// create a subscriber using a store that fails within the transaction
+	cs := &failingTxChainStore{EphemeralChainStore: stores.NewEphemeralChainStore(), failures: 1}
+	s := newTestChainSubscriber(cm, cs, nil)
+
+	// errors returned by the store failed to commit, they are retryable
+	if err := s.sync(); !errors.Is(err, ErrCommitFailed) || errors.Is(err, ErrInvalidChainUpdate) {
+		t.Fatal("unexpected error", err)
+	} else if !isRetryableSyncErr(err) {
+		t.Fatal("expected error to be retryable", err)
+	} else if err := s.sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	// invalid updates are permanent
+	cs.AddContract(types.FileContractID{1}, stores.EphemeralContract{})
+	if err := cs.ProcessChainUpdate(context.Background(), func(tx sql.ChainUpdateTx) error {
+		return s.updateContract(tx, cm.Tip(), types.FileContractID{1}, nil, nil, false, false)
+	}); !errors.Is(err, ErrInvalidChainUpdate) {
+		t.Fatal("unexpected error", err)
+	} else if isRetryableSyncErr(err) {
+		t.Fatal("expected error to be permanent", err)
+	}
+}
+
+func TestChainSubscriberChainIndexRetry(t *testing.T) {
+	cm := newFakeChainManager(t)
+	cm.MineBlocks(1)
+
+	// create a store that fails to return the chain index
+	cs := &failingChainIndexStore{EphemeralChainStore: stores.NewEphemeralChainStore(), failures: 1}
+	s := newTestChainSubscriber(cm, cs, nil)
+	clock := &mockClock{}
+	WithClock(clock)(s)
+
+	// failing to read the chain index is transient
+	if err := s.sync(); !errors.Is(err, ErrStoreUnavailable) {
+		t.Fatal("unexpected error", err)
+	} else if !isRetryableSyncErr(err) {
+		t.Fatal("expected error to be retryable", err)
+	}
+
+	// sync with retries, the subscriber should recover
+	cs.failures = 2
+	if err := s.syncWithRetry(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(clock.slept, s.retryTxIntervals[:2]) {
+		t.Fatal("unexpected sleeps", clock.slept)
+	} else if index, _ := cs.ChainIndex(context.Background()); index != cm.Tip() {
+		t.Fatal("unexpected index", index)
+	}
+}
+
+func TestChainSubscriberNoUpdates(t *testing.T) {
+	network, genesis := testutil.Network()
+	cm := newTestChainManager(t, network, genesis)
+	mineTestBlocks(t, cm, types.VoidAddress, 1)
+
+	// create a subscriber using a chain manager that returns no updates
+	cs := stores.NewEphemeralChainStore()
+	s := newTestChainSubscriber(noUpdatesChainManager{cm}, cs, nil)
+
+	// sync, it should return right away instead of spinning
+	done := make(chan error, 1)
+	go func() { done <- s.sync() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("sync didn't return")
+	}
+	if index, _ := cs.ChainIndex(context.Background()); index != (types.ChainIndex{}) {
+		t.Fatal("unexpected index", index)
+	}
+}
+
+func TestChainSubscriberShutdownTwice(t *testing.T) {
+	network, genesis := testutil.Network()
+	cm := newTestChainManager(t, network, genesis)
+	cs := stores.NewEphemeralChainStore()
+	s, err := NewChainSubscriber(nil, cm, cs, nil, time.Hour, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// shut down the subscriber twice, the second call should be a no-op
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := s.Shutdown(ctx)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestChainSubscriberBatches(t *testing.T) {
+	cm := newFakeChainManager(t)
+	cm.MineBlocks(2*updatesBatchSize + 10)
+
+	// sync, the updates should be fetched in batches
+	cs := stores.NewEphemeralChainStore()
+	s := newTestChainSubscriber(cm, cs, nil)
+	if err := s.sync(); err != nil {
+		t.Fatal(err)
+	} else if index, _ := cs.ChainIndex(context.Background()); index != cm.Tip() {
+		t.Fatalf("expected index %v, got %v", cm.Tip(), index)
+	} else if calls := cm.UpdatesSinceCalls(); calls != 3 {
+		t.Fatalf("expected 3 batches, got %v", calls)
+	} else if m := s.Metrics(); m.BlocksApplied != cm.Tip().Height+1 {
+		t.Fatalf("expected %v blocks to be applied, got %v", cm.Tip().Height+1, m.BlocksApplied)
+	}
+
+	// a failure to fetch updates is a conflict
+	cm.MineBlocks(1)
+	cm.SetUpdatesSinceError(errors.New("reorg in progress"))
+	if err := s.sync(); !errors.Is(err, ErrChainUpdateConflict) {
+		t.Fatal("unexpected error", err)
+	}
+}
+
+func TestChainSubscriberReorgTriggersSync(t *testing.T) {
+	cm := newFakeChainManager(t)
+	cs := stores.NewEphemeralChainStore()
+	s, err := NewChainSubscriber(nil, cm, cs, nil, time.Hour, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown(context.Background())
+
+	// define a helper to wait for the subscriber to catch up
+	waitForSync := func() {
+		t.Helper()
+		for i := 0; i < 100; i++ {
+			if index, _ := cs.ChainIndex(context.Background()); index == cm.Tip() {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatal("subscriber didn't sync")
+	}
+
+	// mine some blocks, the subscriber should sync
+	cm.MineBlocks(10)
+	waitForSync()
+
+	// reorg the chain, the subscriber should revert and apply the new blocks
+	cm.Reorg(3, 5)
+	waitForSync()
+	if m := s.Metrics(); m.BlocksReverted != 3 {
+		t.Fatalf("expected 3 blocks to be reverted, got %v", m.BlocksReverted)
+	} else if m.BlocksApplied != 11+5 {
+		t.Fatalf("expected 16 blocks to be applied, got %v", m.BlocksApplied)
+	}
+}
+
+func TestChainSubscriberWaitForContractState(t *testing.T) {
+	cm := newFakeChainManager(t)
+	cs := stores.NewEphemeralChainStore()
+	s, err := NewChainSubscriber(nil, cm, cs, nil, time.Hour, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown(context.Background())
+
+	// add an active contract that expires at height 5
+	fcid := types.FileContractID{1}
+	cs.AddContract(fcid, stores.EphemeralContract{State: api.ContractStateActive, WindowEnd: 5})
+
+	// waiting for a state the contract doesn't reach should time out
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := cs.WaitForContractState(ctx, fcid, api.ContractStateFailed); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("unexpected error", err)
+	}
+
+	// mine past the window end, the contract should fail
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cm.MineBlocks(10)
+	if err := cs.WaitForContractState(ctx, fcid, api.ContractStateFailed); err != nil {
+		t.Fatal(err)
+	}
+
+	// waiting for an unknown contract should time out
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := cs.WaitForContractState(ctx, types.FileContractID{2}, api.ContractStateActive); !errors.Is(err, api.ErrContractNotFound) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("unexpected error", err)
+	}
+}
+
+func TestChainSubscriberNilLogger(t *testing.T) {
+	cm := newFakeChainManager(t)
+	cs := stores.NewEphemeralChainStore()
+	s, err := NewChainSubscriber(nil, cm, cs, nil, time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown(context.Background())
+
+	// mine some blocks, the subscriber should sync without panicking
+	cm.MineBlocks(10)
+	for i := 0; i < 100; i++ {
+		if index, _ := cs.ChainIndex(context.Background()); index == cm.Tip() {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("subscriber didn't sync")
+}
+
+func TestChainSubscriberPause(t *testing.T) {
+	cm := newFakeChainManager(t)
+	cs := stores.NewEphemeralChainStore()
+	s, err := NewChainSubscriber(nil, cm, cs, nil, time.Hour, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown(context.Background())
+
+	// define a helper to wait for the subscriber to catch up
+	waitForSync := func() {
+		t.Helper()
+		for i := 0; i < 100; i++ {
+			if index, _ := cs.ChainIndex(context.Background()); index == cm.Tip() {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatal("subscriber didn't sync")
+	}
+
+	// mine some blocks, the subscriber should sync
+	cm.MineBlocks(10)
+	waitForSync()
+
+	// pause the subscriber and mine some more blocks
+	s.Pause()
+	index, _ := cs.ChainIndex(context.Background())
+	applied := s.Metrics().BlocksApplied
+	cm.MineBlocks(5)
+	time.Sleep(200 * time.Millisecond)
+
+	// assert nothing was committed
+	if curr, _ := cs.ChainIndex(context.Background()); curr != index {
+		t.Fatalf("expected index %v, got %v", index, curr)
+	} else if m := s.Metrics(); m.BlocksApplied != applied {
+		t.Fatalf("expected %v blocks to be applied, got %v", applied, m.BlocksApplied)
+	}
+
+	// resume, the subscriber should catch up
+	s.Resume()
+	waitForSync()
+	if m := s.Metrics(); m.BlocksApplied != applied+5 {
+		t.Fatalf("expected %v blocks to be applied, got %v", applied+5, m.BlocksApplied)
+	}
+}
+
+func TestChainSubscriberTriggerSync(t *testing.T) {
+	cm := newFakeChainManager(t)
+	cs := stores.NewEphemeralChainStore()
+	s, err := NewChainSubscriber(nil, noReorgsChainManager{cm}, cs, nil, time.Hour, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown(context.Background())
+
+	// mine some blocks, without reorg notifications the subscriber shouldn't
+	// sync
+	cm.MineBlocks(10)
+	time.Sleep(100 * time.Millisecond)
+	if index, _ := cs.ChainIndex(context.Background()); index != (types.ChainIndex{}) {
+		t.Fatal("unexpected index", index)
+	}
+
+	// trigger a sync manually, the subscriber should catch up
+	s.TriggerSync()
+	for i := 0; i < 100; i++ {
+		if index, _ := cs.ChainIndex(context.Background()); index == cm.Tip() {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("subscriber didn't sync")
+}
+
+func TestChainSubscriberCommitInterval(t *testing.T) {
+	cm := newFakeChainManager(t)
+	cm.MineBlocks(49)
+
+	// create a subscriber that commits every 10 blocks using a store that
+	// fails on the third commit
+	cs := &failingChainStore{EphemeralChainStore: stores.NewEphemeralChainStore(), successes: 2, failures: 1}
+	s := newTestChainSubscriber(cm, cs, nil)
+	WithCommitInterval(10)(s)
+
+	// sync, the first two checkpoints should have been committed
+	if err := s.sync(); !errors.Is(err, ErrCommitFailed) {
+		t.Fatal("unexpected error", err)
+	} else if index, _ := cs.ChainIndex(context.Background()); index.Height != 19 {
+		t.Fatalf("expected index at height 19, got %v", index)
+	}
+
+	// sync again, the subscriber should resume from the last checkpoint
+	if err := s.sync(); err != nil {
+		t.Fatal(err)
+	} else if index, _ := cs.ChainIndex(context.Background()); index != cm.Tip() {
+		t.Fatalf("expected index %v, got %v", cm.Tip(), index)
+	} else if m := s.Metrics(); m.BlocksApplied != cm.Tip().Height+1 {
+		t.Fatalf("expected %v blocks to be applied, got %v", cm.Tip().Height+1, m.BlocksApplied)
+	}
+}
+
+func TestChainSubscriberCommitTimeout(t *testing.T) {
+	cm := newFakeChainManager(t)
+	cm.MineBlocks(5)
+
+	// create a subscriber using a store that hangs on the first commit
+	cs := &stuckChainStore{EphemeralChainStore: stores.NewEphemeralChainStore(), stuck: 1}
+	s := newTestChainSubscriber(cm, cs, nil)
+	WithClock(&mockClock{})(s)
+	WithCommitTimeout(50 * time.Millisecond)(s)
+
+	// sync, the stuck commit should be aborted and surface as a retryable
+	// error
+	if err := s.sync(); !errors.Is(err, ErrCommitFailed) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("unexpected error", err)
+	} else if !isRetryableSyncErr(err) {
+		t.Fatal("expected error to be retryable", err)
+	}
+
+	// hang again, the retry should succeed
+	cs.stuck = 1
+	if err := s.syncWithRetry(); err != nil {
+		t.Fatal(err)
+	} else if index, _ := cs.ChainIndex(context.Background()); index != cm.Tip() {
+		t.Fatalf("expected index %v, got %v", cm.Tip(), index)
+	} else if m := s.Metrics(); m.Retries != 1 {
+		t.Fatal("unexpected metrics", m)
+	}
+}
+
+func TestChainSubscriberSyncEvents(t *testing.T) {
+	cm := newFakeChainManager(t)
+	cs := stores.NewEphemeralChainStore()
+	s := newTestChainSubscriber(cm, cs, nil)
+	broadcaster := &mockBroadcaster{}
+	s.broadcaster = broadcaster
+
+	// define a helper to fetch the sync state events since the last call
+	syncEvents := func() (events []string, heights [][2]uint64) {
+		t.Helper()
+		for _, e := range broadcaster.events {
+			if e.Module != api.ModuleConsensus || (e.Event != api.EventSynced && e.Event != api.EventSyncing) {
+				continue
+			}
+			payload := e.Payload.(api.EventConsensusSyncState)
+			events = append(events, e.Event)
+			heights = append(heights, [2]uint64{payload.BlockHeight, payload.TipHeight})
+		}
+		broadcaster.events = nil
+		return
+	}
+
+	// sync, the subscriber should be synced
+	cm.MineBlocks(10)
+	if err := s.sync(); err != nil {
+		t.Fatal(err)
+	} else if events, heights := syncEvents(); !reflect.DeepEqual(events, []string{api.EventSynced}) || heights[0] != [2]uint64{10, 10} {
+		t.Fatal("unexpected events", events, heights)
+	}
+
+	// mine a few blocks, falling behind by less than the threshold should not
+	// trigger any events
+	cm.MineBlocks(syncingEventThreshold)
+	if err := s.sync(); err != nil {
+		t.Fatal(err)
+	} else if events, _ := syncEvents(); len(events) != 0 {
+		t.Fatal("unexpected events", events)
+	}
+
+	// fall behind by more than the threshold, the subscriber should be
+	// syncing before it's synced again
+	cm.MineBlocks(syncingEventThreshold + 1)
+	tip := cm.Tip().Height
+	if err := s.sync(); err != nil {
+		t.Fatal(err)
+	} else if events, heights := syncEvents(); !reflect.DeepEqual(events, []string{api.EventSyncing, api.EventSynced}) {
+		t.Fatal("unexpected events", events)
+	} else if !reflect.DeepEqual(heights, [][2]uint64{{tip - syncingEventThreshold - 1, tip}, {tip, tip}}) {
+		t.Fatal("unexpected heights", heights)
+	}
+}
+
+func TestChainSubscriberHostUpdateEvents(t *testing.T) {
+	cm := newFakeChainManager(t)
+	cs := stores.NewEphemeralChainStore()
+	s := newTestChainSubscriber(cm, cs, nil)
+	broadcaster := &mockBroadcaster{}
+	s.broadcaster = broadcaster
+
+	// define a helper to announce a host
+	announce := func(sk types.PrivateKey, netAddr string) {
+		ha := chain.HostAnnouncement{NetAddress: netAddr}
+		cm.MineBlocks(1, types.Transaction{ArbitraryData: [][]byte{ha.ToArbitraryData(sk)}})
+	}
+
+	// announce two hosts, the first one announces twice
+	sk1, sk2 := types.GeneratePrivateKey(), types.GeneratePrivateKey()
+	announce(sk1, "foo.bar:1234")
+	announce(sk2, "baz.qux:1234")
+	announce(sk1, "foo.bar:5678")
+
+	// sync, all announcements are processed in a single batch so the first
+	// host should only be broadcasted once, with its latest announcement
+	if err := s.sync(); err != nil {
+		t.Fatal(err)
+	}
+	var updates []api.EventHostUpdate
+	for _, e := range broadcaster.events {
+		if hu, ok := e.Payload.(api.EventHostUpdate); ok {
+			updates = append(updates, hu)
+		}
+	}
+	if len(updates) != 2 {
+		t.Fatalf("expected 2 host updates, got %v", len(updates))
+	} else if updates[0].HostKey != sk2.PublicKey() || updates[0].NetAddr != "baz.qux:1234" || updates[0].BlockHeight != 2 {
+		t.Fatal("unexpected host update", updates[0])
+	} else if updates[1].HostKey != sk1.PublicKey() || updates[1].NetAddr != "foo.bar:5678" || updates[1].BlockHeight != 3 {
+		t.Fatal("unexpected host update", updates[1])
+	}
+}
+
+func TestChainSubscriberUpdateHooks(t *testing.T) {
+	cm := newFakeChainManager(t)
+	cm.MineBlocks(10)
+
+	// create a subscriber using a store that fails the first commit
+	cs := &failingChainStore{EphemeralChainStore: stores.NewEphemeralChainStore(), failures: 1}
+	s := newTestChainSubscriber(cm, cs, nil)
+	WithClock(&mockClock{})(s)
+
+	// register hooks that record the heights of the updates
+	var applied, reverted []uint64
+	WithOnApply(func(caus []chain.ApplyUpdate) {
+		for _, cau := range caus {
+			applied = append(applied, cau.State.Index.Height)
+		}
+	})(s)
+	WithOnRevert(func(crus []chain.RevertUpdate) {
+		for _, cru := range crus {
+			reverted = append(reverted, cru.State.Index.Height+1)
+		}
+	})(s)
+
+	// sync, the failed commit should not have been observed
+	if err := s.syncWithRetry(); err != nil {
+		t.Fatal(err)
+	} else if len(applied) != 11 || applied[0] != 0 || applied[10] != 10 {
+		t.Fatal("unexpected apply updates", applied)
+	} else if len(reverted) != 0 {
+		t.Fatal("unexpected revert updates", reverted)
+	}
+
+	// reorg the chain and sync again
+	applied = applied[:0]
+	cm.Reorg(3, 4)
+	if err := s.sync(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(reverted, []uint64{10, 9, 8}) {
+		t.Fatal("unexpected revert updates", reverted)
+	} else if !reflect.DeepEqual(applied, []uint64{8, 9, 10, 11}) {
+		t.Fatal("unexpected apply updates", applied)
+	}
+}
+
+func TestChainSubscriberLogUnknownContracts(t *testing.T) {
+	network, genesis := testutil.Network()
+	cm := newTestChainManager(t, network, genesis)
+
+	cs := stores.NewEphemeralChainStore()
+	s := newTestChainSubscriber(cm, cs, nil)
+	clock := &mockClock{now: time.Now()}
+	WithClock(clock)(s)
+
+	observedZapCore, observedLogs := observer.New(zap.DebugLevel)
+	s.logger = zap.New(observedZapCore).Sugar()
+
+	// define a helper to update an unknown contract
+	fcid := types.FileContractID{1}
+	update := func() {
+		t.Helper()
+		if err := cs.ProcessChainUpdate(context.Background(), func(tx sql.ChainUpdateTx) error {
+			return s.updateContract(tx, cm.Tip(), fcid, nil, &revision{revisionNumber: 1}, false, false)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertLogged := func(n int) {
+		t.Helper()
+		if logged := observedLogs.FilterMessage("skipping update of unknown contract").Len(); logged != n {
+			t.Fatalf("expected %v log lines, got %v", n, logged)
+		}
+	}
+
+	// unknown contracts are skipped silently by default
+	update()
+	assertLogged(0)
+
+	// enable logging, the contract is logged once
+	WithLogUnknownContracts(true)(s)
+	update()
+	update()
+	assertLogged(1)
+
+	// the contract is logged again once the interval passed
+	clock.now = clock.now.Add(unknownContractLogInterval)
+	update()
+	assertLogged(2)
+}
+
+func TestChainSubscriberDryRun(t *testing.T) {
+	cm := newFakeChainManager(t)
+	cs := stores.NewEphemeralChainStore()
+	w := &mockWallet{}
+	s := newTestChainSubscriber(cm, cs, w)
+	broadcaster := &mockBroadcaster{}
+	s.broadcaster = broadcaster
+	WithDryRun(true)(s)
+
+	observedZapCore, observedLogs := observer.New(zap.DebugLevel)
+	s.logger = zap.New(observedZapCore).Sugar()
+
+	// form a contract and announce a host
+	sk := types.GeneratePrivateKey()
+	txn := types.Transaction{
+		FileContracts: []types.FileContract{{WindowStart: 100, WindowEnd: 110}},
+		ArbitraryData: [][]byte{chain.HostAnnouncement{NetAddress: "foo.bar:1234"}.ToArbitraryData(sk)},
+	}
+	fcid := txn.FileContractID(0)
+	cs.AddContract(fcid, stores.EphemeralContract{WindowEnd: 110})
+	cm.MineBlocks(10, txn)
+
+	// define a helper to assert the number of dry run log lines
+	assertLogged := func(msg string, n int) {
+		t.Helper()
+		if logged := observedLogs.FilterMessage("dry run: " + msg).Len(); logged != n {
+			t.Fatalf("expected %v '%v' log lines, got %v", n, msg, logged)
+		}
+	}
+
+	// sync, the intended changes should be logged
+	if err := s.sync(); err != nil {
+		t.Fatal(err)
+	}
+	assertLogged("update chain index", 1)
+	assertLogged("update contract state", 1)
+	assertLogged("update host", 1)
+	assertLogged("update wallet", 1)
+
+	// but nothing should have been written or broadcasted
+	if index, _ := cs.ChainIndex(context.Background()); index != (types.ChainIndex{}) {
+		t.Fatal("unexpected index", index)
+	} else if c, _ := cs.Contract(fcid); c.State != api.ContractStatePending || c.RevisionNumber != 0 {
+		t.Fatalf("unexpected contract %+v", c)
+	} else if _, ok := cs.Host(sk.PublicKey()); ok {
+		t.Fatal("unexpected host")
+	} else if w.updates != 0 {
+		t.Fatal("unexpected wallet updates", w.updates)
+	} else if len(broadcaster.events) != 0 {
+		t.Fatal("unexpected events", len(broadcaster.events))
+	}
+
+	// syncing again doesn't replay the updates
+	if err := s.sync(); err != nil {
+		t.Fatal(err)
+	}
+	assertLogged("update chain index", 1)
+
+	// new blocks are processed
+	cm.MineBlocks(1)
+	if err := s.sync(); err != nil {
+		t.Fatal(err)
+	}
+	assertLogged("update chain index", 2)
+	assertLogged("update contract state", 1)
+	if index, _ := cs.ChainIndex(context.Background()); index != (types.ChainIndex{}) {
+		t.Fatal("unexpected index", index)
+	}
+}
+
+func TestChainSubscriberMaxContractSize(t *testing.T) {
+	network, genesis := testutil.Network()
+	cm := newTestChainManager(t, network, genesis)
+
+	cs := stores.NewEphemeralChainStore()
+	s := newTestChainSubscriber(cm, cs, nil)
+
+	observedZapCore, observedLogs := observer.New(zap.DebugLevel)
+	s.logger = zap.New(observedZapCore).Sugar()
+
+	// define helpers
+	fcid := types.FileContractID{1}
+	cs.AddContract(fcid, stores.EphemeralContract{WindowEnd: 10})
+	update := func(rev *revision) {
+		t.Helper()
+		if err := cs.ProcessChainUpdate(context.Background(), func(tx sql.ChainUpdateTx) error {
+			return s.updateContract(tx, types.ChainIndex{Height: 1}, fcid, nil, rev, false, false)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertContract := func(revisionNumber, size uint64, logged int) {
+		t.Helper()
+		if c, _ := cs.Contract(fcid); c.RevisionNumber != revisionNumber || c.Size != size {
+			t.Fatalf("expected revision %v and size %v, got %v and %v", revisionNumber, size, c.RevisionNumber, c.Size)
+		} else if n := observedLogs.FilterMessage("contract revision exceeds the max contract size").Len(); n != logged {
+			t.Fatalf("expected %v log lines, got %v", logged, n)
+		}
+	}
+
+	// without a ceiling any size is stored
+	update(&revision{revisionNumber: 1, fileSize: 1 << 50})
+	assertContract(1, 1<<50, 0)
+
+	// oversized revisions are stored with a warning by default
+	WithMaxContractSize(1<<40, false)(s)
+	update(&revision{revisionNumber: 2, fileSize: 1 << 41})
+	assertContract(2, 1<<41, 1)
+
+	// revisions below the ceiling are stored silently
+	update(&revision{revisionNumber: 3, fileSize: 1 << 40})
+	assertContract(3, 1<<40, 1)
+
+	// oversized revisions are skipped if they are rejected, the contract's
+	// state is still updated
+	WithMaxContractSize(1<<40, true)(s)
+	update(&revision{revisionNumber: 4, fileSize: 1 << 41})
+	assertContract(3, 1<<40, 2)
+	if c, _ := cs.Contract(fcid); c.State != api.ContractStateActive {
+		t.Fatalf("expected state %v, got %v", api.ContractStateActive, c.State)
+	}
+}
+
+func TestValidNetAddress(t *testing.T) {
+	r := mockResolver{"foo.bar": {"1.2.3.4"}}
+	tests := []struct {
+		netAddress string
+		resolve    bool
+		valid      bool
+	}{
+		{"foo.bar:9982", false, true},
+		{"foo.bar:9982", true, true},
+		{"baz.qux:9982", false, true},
+		{"baz.qux:9982", true, false},
+		{"foo.bar", false, false},
+		{":9982", false, false},
+		{"foo.bar:0", false, false},
+		{"foo.bar:65536", false, false},
+		{"foo.bar:port", false, false},
+	}
+	for _, test := range tests {
+		var accept AcceptAnnouncementFn
+		if test.resolve {
+			accept = ValidNetAddress(r)
+		} else {
+			accept = ValidNetAddress(nil)
+		}
+		if valid := accept(types.PublicKey{}, chain.HostAnnouncement{NetAddress: test.netAddress}, 0); valid != test.valid {
+			t.Errorf("%v: expected %v, got %v", test.netAddress, test.valid, valid)
+		}
+	}
+}
+
+type noUpdatesChainManager struct {
+	*chain.Manager
+}
+
+func (noUpdatesChainManager) UpdatesSince(types.ChainIndex, int) ([]chain.RevertUpdate, []chain.ApplyUpdate, error) {
+	return nil, nil, nil
+}
+
+type noReorgsChainManager struct {
+	*fakeChainManager
+}
+
+func (noReorgsChainManager) OnReorg(func(types.ChainIndex)) func() {
+	return func() {}
+}
+
+// fakeChainManager is an in-memory ChainManager that allows tests to script
+// the chain, including reorgs, without mining. Blocks aren't validated, so the
+// transactions that are added to the chain must not spend any outputs.
+type fakeChainManager struct {
+	t *testing.T
+
+	mu         sync.Mutex
+	blocks     map[types.BlockID]fakeBlock // includes orphaned blocks
+	best       []types.BlockID             // best chain, indexed by height
+	nonce      uint64
+	onReorg    map[int]func(types.ChainIndex)
+	onReorgKey int
+
+	// updatesSinceCalls is the number of times UpdatesSince was called and
+	// updatesSinceErr is returned by UpdatesSince if set
+	updatesSinceCalls int
+	updatesSinceErr   error
+}
+
+type fakeBlock struct {
+	block  types.Block
+	parent consensus.State
+	state  consensus.State
+}
+
+func newFakeChainManager(t *testing.T) *fakeChainManager {
+	t.Helper()
+	network, genesis := testutil.Network()
+	state, _ := consensus.ApplyBlock(network.GenesisState(), genesis, fakeBlockSupplement(genesis), time.Time{})
+	return &fakeChainManager{
+		t:       t,
+		blocks:  map[types.BlockID]fakeBlock{genesis.ID(): {block: genesis, parent: network.GenesisState(), state: state}},
+		best:    []types.BlockID{genesis.ID()},
+		onReorg: make(map[int]func(types.ChainIndex)),
+	}
+}
+
+// MineBlocks adds n blocks to the tip of the best chain, the first block
+// contains the given transactions.
+func (cm *fakeChainManager) MineBlocks(n int, txns ...types.Transaction) {
+	cm.mu.Lock()
+	for i := 0; i < n; i++ {
+		cm.addBlock(txns)
+		txns = nil
+	}
+	tip := cm.tip()
+	cm.mu.Unlock()
+	cm.notifyReorg(tip)
+}
+
+// Reorg reverts depth blocks from the best chain and replaces them with n
+// new blocks, the fork becomes the best chain regardless of its length.
+func (cm *fakeChainManager) Reorg(depth, n int) {
+	cm.t.Helper()
+	cm.mu.Lock()
+	if depth >= len(cm.best) {
+		cm.mu.Unlock()
+		cm.t.Fatalf("can't revert %d blocks from a chain of %d blocks", depth, len(cm.best))
+	}
+	cm.best = cm.best[:len(cm.best)-depth]
+	for i := 0; i < n; i++ {
+		cm.addBlock(nil)
+	}
+	tip := cm.tip()
+	cm.mu.Unlock()
+	cm.notifyReorg(tip)
+}
+
+// SetUpdatesSinceError causes UpdatesSince to fail with the given error, nil
+// resets it.
+func (cm *fakeChainManager) SetUpdatesSinceError(err error) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.updatesSinceErr = err
+}
+
+// UpdatesSinceCalls returns the number of times UpdatesSince was called.
+func (cm *fakeChainManager) UpdatesSinceCalls() int {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	return cm.updatesSinceCalls
+}
+
+func (cm *fakeChainManager) OnReorg(fn func(types.ChainIndex)) (cancel func()) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	key := cm.onReorgKey
+	cm.onReorgKey++
+	cm.onReorg[key] = fn
+	return func() {
+		cm.mu.Lock()
+		defer cm.mu.Unlock()
+		delete(cm.onReorg, key)
+	}
+}
+
+func (cm *fakeChainManager) RecommendedFee() types.Currency {
+	return types.Siacoins(1).Div64(1000)
+}
+
+func (cm *fakeChainManager) Tip() types.ChainIndex {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	return cm.tip()
+}
+
+func (cm *fakeChainManager) UpdatesSince(index types.ChainIndex, max int) (rus []chain.RevertUpdate, aus []chain.ApplyUpdate, err error) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.updatesSinceCalls++
+	if cm.updatesSinceErr != nil {
+		return nil, nil, cm.updatesSinceErr
+	}
+
+	onBestChain := func(index types.ChainIndex) bool {
+		return index == (types.ChainIndex{}) || (index.Height < uint64(len(cm.best)) && cm.best[index.Height] == index.ID)
+	}
+
+	for index != cm.tip() && len(rus)+len(aus) < max {
+		if !onBestChain(index) {
+			// revert until we are on the best chain
+			b, ok := cm.blocks[index.ID]
+			if !ok {
+				return nil, nil, fmt.Errorf("missing block at index %v", index)
+			}
+			cru := consensus.RevertBlock(b.parent, b.block, fakeBlockSupplement(b.block))
+			rus = append(rus, chain.RevertUpdate{RevertUpdate: cru, Block: b.block, State: b.parent})
+			index = b.parent.Index
+		} else {
+			// then apply
+			if index == (types.ChainIndex{}) {
+				index = types.ChainIndex{Height: 0, ID: cm.best[0]}
+			} else {
+				index = types.ChainIndex{Height: index.Height + 1, ID: cm.best[index.Height+1]}
+			}
+			b := cm.blocks[index.ID]
+			_, cau := consensus.ApplyBlock(b.parent, b.block, fakeBlockSupplement(b.block), b.parent.PrevTimestamps[0])
+			aus = append(aus, chain.ApplyUpdate{ApplyUpdate: cau, Block: b.block, State: b.state})
+		}
+	}
+	return
+}
+
+func (cm *fakeChainManager) addBlock(txns []types.Transaction) {
+	parent := cm.blocks[cm.best[len(cm.best)-1]].state
+	cm.nonce++
+	b := types.Block{
+		ParentID:     parent.Index.ID,
+		Nonce:        cm.nonce,
+		Timestamp:    time.Now(),
+		MinerPayouts: []types.SiacoinOutput{{Address: types.VoidAddress, Value: parent.BlockReward()}},
+		Transactions: txns,
+	}
+	state, _ := consensus.ApplyBlock(parent, b, fakeBlockSupplement(b), parent.PrevTimestamps[0])
+	cm.blocks[b.ID()] = fakeBlock{block: b, parent: parent, state: state}
+	cm.best = append(cm.best, b.ID())
+}
+
+func (cm *fakeChainManager) notifyReorg(tip types.ChainIndex) {
+	cm.mu.Lock()
+	fns := make([]func(types.ChainIndex), 0, len(cm.onReorg))
+	for _, fn := range cm.onReorg {
+		fns = append(fns, fn)
+	}
+	cm.mu.Unlock()
+	for _, fn := range fns {
+		fn(tip)
+	}
+}
+
+func (cm *fakeChainManager) tip() types.ChainIndex {
+	return cm.blocks[cm.best[len(cm.best)-1]].state.Index
+}
+
+// fakeBlockSupplement returns an empty supplement for the given block, it's
+// only valid for blocks whose transactions don't spend any outputs.
+func fakeBlockSupplement(b types.Block) consensus.V1BlockSupplement {
+	return consensus.V1BlockSupplement{Transactions: make([]consensus.V1TransactionSupplement, len(b.Transactions))}
+}
+
+// failingChainStore fails the given number of chain updates after the given
+// number of successful ones.
+type failingChainStore struct {
+	*stores.EphemeralChainStore
+	successes int
+	failures  int
+}
+
+func (cs *failingChainStore) ProcessChainUpdate(ctx context.Context, applyFn func(sql.ChainUpdateTx) error) error {
+	if cs.successes > 0 {
+		cs.successes--
+	} else if cs.failures > 0 {
+		cs.failures--
+		return errors.New("database is locked")
+	}
+	return cs.EphemeralChainStore.ProcessChainUpdate(ctx, applyFn)
+}
+
+// failingChainIndexStore fails to return the chain index the given number of
+// times.
+type failingChainIndexStore struct {
+	*stores.EphemeralChainStore
+	failures int
+}
+
+func (cs *failingChainIndexStore) ChainIndex(ctx context.Context) (types.ChainIndex, error) {
+	if cs.failures > 0 {
+		cs.failures--
+		return types.ChainIndex{}, errors.New("database is locked")
+	}
+	return cs.EphemeralChainStore.ChainIndex(ctx)
+}
+
+// failingTxChainStore fails the given number of chain updates when the chain
+// index is updated, i.e. after the updates were applied within the
+// transaction.
+type failingTxChainStore struct {
+	*stores.EphemeralChainStore
+	failures int
+}
+
+func (cs *failingTxChainStore) ProcessChainUpdate(ctx context.Context, applyFn func(sql.ChainUpdateTx) error) error {
+	return cs.EphemeralChainStore.ProcessChainUpdate(ctx, func(tx sql.ChainUpdateTx) error {
+		if cs.failures > 0 {
+			cs.failures--
+			return applyFn(failingChainIndexTx{tx})
+		}
+		return applyFn(tx)
+	})
+}
+
+type failingChainIndexTx struct {
+	sql.ChainUpdateTx
+}
+
+func (failingChainIndexTx) UpdateChainIndex(types.ChainIndex) error {
+	return errors.New("database is locked")
+}
+
+// stuckChainStore is a chain store that hangs until the context is done on
+// the first stuck commits.
+type stuckChainStore struct {
+	*stores.EphemeralChainStore
+	stuck int
+}
+
+func (cs *stuckChainStore) ProcessChainUpdate(ctx context.Context, applyFn func(sql.ChainUpdateTx) error) error {
+	if cs.stuck > 0 {
+		cs.stuck--
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return cs.EphemeralChainStore.ProcessChainUpdate(ctx, applyFn)
+}
+
+// mockWallet is a Wallet that counts the chain updates it processed.
+type mockWallet struct {
+	updates int
+}
+
+func (w *mockWallet) UpdateChainState(tx wallet.UpdateTx, reverted []chain.RevertUpdate, applied []chain.ApplyUpdate) error {
+	w.updates++
+	return nil
+}
+
+// txTrackingChainStore is a chain store that tracks whether a chain update is
+// being processed.
+type txTrackingChainStore struct {
+	*stores.EphemeralChainStore
+	inTx atomic.Bool
+}
+
+func (cs *txTrackingChainStore) ProcessChainUpdate(ctx context.Context, applyFn func(sql.ChainUpdateTx) error) error {
+	cs.inTx.Store(true)
+	defer cs.inTx.Store(false)
+	return cs.EphemeralChainStore.ProcessChainUpdate(ctx, applyFn)
+}
+
+// mockResolver resolves the hosts in the map to their addresses.
+type mockResolver map[string][]string
+
+func (r mockResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	addrs, ok := r[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return addrs, nil
+}
+
+type mockClock struct {
+	now   time.Time
+	slept []time.Duration
+}
+
+func (c *mockClock) Now() time.Time { return c.now }
+
+func (c *mockClock) Sleep(ctx context.Context, d time.Duration) error {
+	c.slept = append(c.slept, d)
+	return nil
+}
+
+func mineTestBlocks(t *testing.T, cm *chain.Manager, addr types.Address, n int) (blocks []types.Block) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		b, ok := coreutils.MineBlock(cm, addr, 5*time.Second)
+		if !ok {
+			t.Fatal("failed to mine block")
+		} else if err := cm.AddBlocks([]types.Block{b}); err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, b)
+	}
+	return
+}
+
+func newTestChainManager(t *testing.T, network *consensus.Network, genesis types.Block) *chain.Manager {
+	t.Helper()
+	store, state, err := chain.NewDBStore(chain.NewMemDB(), network, genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chain.NewManager(store, state)
+}
+
+func newTestChainSubscriber(cm ChainManager, cs ChainStore, w Wallet) *chainSubscriber {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	return &chainSubscriber{
+		cm:          cm,
+		cs:          cs,
+		broadcaster: webhooks.NoopBroadcaster{},
+		logger:      zap.NewNop().Sugar(),
+
+		announcementMaxAge: time.Hour,
+		clock:              realClock{},
+		retryTxIntervals:   defaultRetryTxIntervals,
+		wallet:             w,
+
+		shutdownCtx:       ctx,
+		shutdownCtxCancel: cancel,
+		syncSig:           make(chan struct{}, 1),
+
+		knownContracts: make(map[types.FileContractID]bool),
+	}
+}
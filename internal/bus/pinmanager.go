@@ -304,15 +304,7 @@ func (pm *pinManager) updateGougingSettings(ctx context.Context, pins api.Gougin
 
 	// broadcast event
 	if err == nil {
-		pm.broadcaster.BroadcastAction(ctx, webhooks.Event{
-			Module: api.ModuleSetting,
-			Event:  api.EventUpdate,
-			Payload: api.EventSettingUpdate{
-				Key:       api.SettingGouging,
-				Update:    string(bytes),
-				Timestamp: time.Now().UTC(),
-			},
-		})
+		pm.broadcaster.BroadcastAction(ctx, api.NewSettingUpdateEvent(api.SettingGouging, string(bytes), time.Now()))
 	}
 
 	return err
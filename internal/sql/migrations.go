@@ -211,6 +211,18 @@ var (
 					return performMigration(ctx, tx, migrationsFs, dbIdentifier, "00016_account_owner", log)
 				},
 			},
+			{
+				ID: "00017_webhook_method",
+				Migrate: func(tx Tx) error {
+					return performMigration(ctx, tx, migrationsFs, dbIdentifier, "00017_webhook_method", log)
+				},
+			},
+			{
+				ID: "00018_object_parts_count",
+				Migrate: func(tx Tx) error {
+					return performMigration(ctx, tx, migrationsFs, dbIdentifier, "00018_object_parts_count", log)
+				},
+			},
 		}
 	}
 	MetricsMigrations = func(ctx context.Context, migrationsFs embed.FS, log *zap.SugaredLogger) []Migration {
@@ -0,0 +1,356 @@
+package stores
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
+	"go.sia.tech/coreutils/wallet"
+	"go.sia.tech/renterd/api"
+	"go.sia.tech/renterd/stores/sql"
+)
+
+type (
+	// EphemeralChainStore is an in-memory implementation of the store used by
+	// the chain subscriber. It's intended to be used in tests where spinning
+	// up a database is overkill. Chain updates are applied to a copy of the
+	// store's state which is only committed if the update succeeds.
+	EphemeralChainStore struct {
+		mu    sync.Mutex
+		state chainState
+
+		// updated is closed and replaced whenever the state changes
+		updated chan struct{}
+	}
+
+	// EphemeralContract is a contract as it's tracked by the
+	// EphemeralChainStore.
+	EphemeralContract struct {
+		State          api.ContractState
+		WindowEnd      uint64
+		ProofHeight    uint64
+		RevisionHeight uint64
+		RevisionNumber uint64
+		Size           uint64
+	}
+
+	// EphemeralHost is a host announcement as it's tracked by the
+	// EphemeralChainStore.
+	EphemeralHost struct {
+		NetAddress       string
+		BlockHeight      uint64
+		BlockID          types.BlockID
+		LastAnnouncement time.Time
+	}
+
+	chainState struct {
+		index     types.ChainIndex
+		contracts map[types.FileContractID]EphemeralContract
+		hosts     map[types.PublicKey]EphemeralHost
+		outputs   map[types.SiacoinOutputID]types.SiacoinElement
+		events    []wallet.Event
+	}
+
+	ephemeralChainUpdateTx struct {
+		state *chainState
+
+		// done is set once the function the transaction was passed to
+		// returned, the transaction can't be used after that
+		done atomic.Bool
+	}
+)
+
+// ErrTxDone is returned by the methods of the EphemeralChainStore's
+// transaction when it's used after it was committed or rolled back.
+var ErrTxDone = errors.New("transaction has already been committed or rolled back")
+
+var _ sql.ChainUpdateTx = (*ephemeralChainUpdateTx)(nil)
+
+// NewEphemeralChainStore returns a new, empty, EphemeralChainStore.
+func NewEphemeralChainStore() *EphemeralChainStore {
+	return &EphemeralChainStore{
+		state: chainState{
+			contracts: make(map[types.FileContractID]EphemeralContract),
+			hosts:     make(map[types.PublicKey]EphemeralHost),
+			outputs:   make(map[types.SiacoinOutputID]types.SiacoinElement),
+		},
+		updated: make(chan struct{}),
+	}
+}
+
+// AddContract adds a contract to the store, it will be tracked by the chain
+// subscriber from now on.
+func (s *EphemeralChainStore) AddContract(fcid types.FileContractID, c EphemeralContract) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c.State == "" {
+		c.State = api.ContractStatePending
+	}
+	s.state.contracts[fcid] = c
+	s.notifyUpdated()
+}
+
+// ChainIndex returns the store's chain index.
+func (s *EphemeralChainStore) ChainIndex(_ context.Context) (types.ChainIndex, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state.index, nil
+}
+
+// Contract returns the contract with given id.
+func (s *EphemeralChainStore) Contract(fcid types.FileContractID) (EphemeralContract, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.state.contracts[fcid]
+	return c, ok
+}
+
+// Host returns the most recent announcement of the host with given key.
+func (s *EphemeralChainStore) Host(hk types.PublicKey) (EphemeralHost, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.state.hosts[hk]
+	return h, ok
+}
+
+// WalletEvents returns the wallet events that were applied to the store.
+func (s *EphemeralChainStore) WalletEvents() []wallet.Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]wallet.Event(nil), s.state.events...)
+}
+
+// WalletOutputs returns the siacoin elements that are currently unspent.
+func (s *EphemeralChainStore) WalletOutputs() []types.SiacoinElement {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	outputs := make([]types.SiacoinElement, 0, len(s.state.outputs))
+	for _, sce := range s.state.outputs {
+		outputs = append(outputs, sce)
+	}
+	return outputs
+}
+
+// ProcessChainUpdate calls the given function with a transaction, the changes
+// made within the transaction are only committed if the function returns nil.
+func (s *EphemeralChainStore) ProcessChainUpdate(ctx context.Context, applyFn func(sql.ChainUpdateTx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := context.Cause(ctx); err != nil {
+		return err
+	}
+
+	state := s.state.clone()
+	tx := &ephemeralChainUpdateTx{state: &state}
+	err := applyFn(tx)
+	tx.done.Store(true)
+	if err != nil {
+		return err
+	}
+	s.state = state
+	s.notifyUpdated()
+	return nil
+}
+
+// WaitForContractState blocks until the contract with given id reaches the
+// given state or the context is done, in which case the context's error is
+// returned. The state is re-checked every time a chain update is committed.
+func (s *EphemeralChainStore) WaitForContractState(ctx context.Context, fcid types.FileContractID, state api.ContractState) error {
+	for {
+		s.mu.Lock()
+		c, ok := s.state.contracts[fcid]
+		updated := s.updated
+		s.mu.Unlock()
+
+		if ok && c.State == state {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			if !ok {
+				return fmt.Errorf("%w: %v: %w", api.ErrContractNotFound, fcid, context.Cause(ctx))
+			}
+			return fmt.Errorf("contract %v is in state %v, expected %v: %w", fcid, c.State, state, context.Cause(ctx))
+		case <-updated:
+		}
+	}
+}
+
+func (s *EphemeralChainStore) notifyUpdated() {
+	close(s.updated)
+	s.updated = make(chan struct{})
+}
+
+func (cs chainState) clone() chainState {
+	c := chainState{
+		index:     cs.index,
+		contracts: make(map[types.FileContractID]EphemeralContract, len(cs.contracts)),
+		hosts:     make(map[types.PublicKey]EphemeralHost, len(cs.hosts)),
+		outputs:   make(map[types.SiacoinOutputID]types.SiacoinElement, len(cs.outputs)),
+		events:    append([]wallet.Event(nil), cs.events...),
+	}
+	for fcid, contract := range cs.contracts {
+		c.contracts[fcid] = contract
+	}
+	for hk, host := range cs.hosts {
+		c.hosts[hk] = host
+	}
+	for id, sce := range cs.outputs {
+		c.outputs[id] = sce
+	}
+	return c
+}
+
+func (tx *ephemeralChainUpdateTx) ContractState(fcid types.FileContractID) (api.ContractState, error) {
+	if tx.done.Load() {
+		return "", ErrTxDone
+	}
+	c, ok := tx.state.contracts[fcid]
+	if !ok {
+		return "", fmt.Errorf("%w: %v", api.ErrContractNotFound, fcid)
+	}
+	return c.State, nil
+}
+
+func (tx *ephemeralChainUpdateTx) UpdateChainIndex(index types.ChainIndex) error {
+	if tx.done.Load() {
+		return ErrTxDone
+	}
+	tx.state.index = index
+	return nil
+}
+
+func (tx *ephemeralChainUpdateTx) UpdateContract(fcid types.FileContractID, revisionHeight, revisionNumber, size uint64) error {
+	if tx.done.Load() {
+		return ErrTxDone
+	}
+	c, ok := tx.state.contracts[fcid]
+	if !ok {
+		return fmt.Errorf("%w: %v", api.ErrContractNotFound, fcid)
+	}
+	if revisionNumber > c.RevisionNumber {
+		c.RevisionHeight = revisionHeight
+		c.RevisionNumber = revisionNumber
+		c.Size = size
+	} else if revisionHeight > c.RevisionHeight {
+		c.RevisionHeight = revisionHeight
+	}
+	tx.state.contracts[fcid] = c
+	return nil
+}
+
+func (tx *ephemeralChainUpdateTx) UpdateContractProofHeight(fcid types.FileContractID, proofHeight uint64) error {
+	if tx.done.Load() {
+		return ErrTxDone
+	}
+	if c, ok := tx.state.contracts[fcid]; ok {
+		c.ProofHeight = proofHeight
+		tx.state.contracts[fcid] = c
+	}
+	return nil
+}
+
+func (tx *ephemeralChainUpdateTx) UpdateContractState(fcid types.FileContractID, state api.ContractState) error {
+	if tx.done.Load() {
+		return ErrTxDone
+	}
+	if c, ok := tx.state.contracts[fcid]; ok {
+		c.State = state
+		tx.state.contracts[fcid] = c
+	}
+	return nil
+}
+
+func (tx *ephemeralChainUpdateTx) UpdateFailedContracts(blockHeight uint64) error {
+	if tx.done.Load() {
+		return ErrTxDone
+	}
+	for fcid, c := range tx.state.contracts {
+		if c.State == api.ContractStateActive && c.WindowEnd <= blockHeight {
+			c.State = api.ContractStateFailed
+			tx.state.contracts[fcid] = c
+		}
+	}
+	return nil
+}
+
+func (tx *ephemeralChainUpdateTx) UpdateHost(hk types.PublicKey, ha chain.HostAnnouncement, bh uint64, blockID types.BlockID, ts time.Time) error {
+	if tx.done.Load() {
+		return ErrTxDone
+	}
+	tx.state.hosts[hk] = EphemeralHost{
+		NetAddress:       ha.NetAddress,
+		BlockHeight:      bh,
+		BlockID:          blockID,
+		LastAnnouncement: ts,
+	}
+	return nil
+}
+
+func (tx *ephemeralChainUpdateTx) WalletStateElements() ([]types.StateElement, error) {
+	if tx.done.Load() {
+		return nil, ErrTxDone
+	}
+	elements := make([]types.StateElement, 0, len(tx.state.outputs))
+	for _, sce := range tx.state.outputs {
+		elements = append(elements, sce.StateElement)
+	}
+	return elements, nil
+}
+
+func (tx *ephemeralChainUpdateTx) UpdateWalletStateElements(elements []types.StateElement) error {
+	if tx.done.Load() {
+		return ErrTxDone
+	}
+	for _, el := range elements {
+		id := types.SiacoinOutputID(el.ID)
+		if sce, ok := tx.state.outputs[id]; ok {
+			sce.StateElement = el
+			tx.state.outputs[id] = sce
+		}
+	}
+	return nil
+}
+
+func (tx *ephemeralChainUpdateTx) WalletApplyIndex(index types.ChainIndex, created, spent []types.SiacoinElement, events []wallet.Event, timestamp time.Time) error {
+	if tx.done.Load() {
+		return ErrTxDone
+	}
+	for _, sce := range spent {
+		delete(tx.state.outputs, types.SiacoinOutputID(sce.ID))
+	}
+	for _, sce := range created {
+		tx.state.outputs[types.SiacoinOutputID(sce.ID)] = sce
+	}
+	tx.state.events = append(tx.state.events, events...)
+	return nil
+}
+
+func (tx *ephemeralChainUpdateTx) WalletRevertIndex(index types.ChainIndex, removed, unspent []types.SiacoinElement, timestamp time.Time) error {
+	if tx.done.Load() {
+		return ErrTxDone
+	}
+	for _, sce := range removed {
+		delete(tx.state.outputs, types.SiacoinOutputID(sce.ID))
+	}
+	for _, sce := range unspent {
+		tx.state.outputs[types.SiacoinOutputID(sce.ID)] = sce
+	}
+
+	// remove events created at the reverted index
+	events := tx.state.events[:0]
+	for _, e := range tx.state.events {
+		if e.Index != index {
+			events = append(events, e)
+		}
+	}
+	tx.state.events = events
+	return nil
+}
@@ -0,0 +1,49 @@
+package stores
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.sia.tech/core/types"
+	"go.sia.tech/renterd/api"
+	"go.sia.tech/renterd/stores/sql"
+)
+
+func TestEphemeralChainUpdateTxDone(t *testing.T) {
+	s := NewEphemeralChainStore()
+	fcid := types.FileContractID{1}
+	s.AddContract(fcid, EphemeralContract{})
+
+	// leak the transaction of a committed and a rolled back update
+	var committed, rolledBack sql.ChainUpdateTx
+	if err := s.ProcessChainUpdate(context.Background(), func(tx sql.ChainUpdateTx) error {
+		committed = tx
+		return tx.UpdateChainIndex(types.ChainIndex{Height: 1})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	errRollback := errors.New("rollback")
+	if err := s.ProcessChainUpdate(context.Background(), func(tx sql.ChainUpdateTx) error {
+		rolledBack = tx
+		return errRollback
+	}); !errors.Is(err, errRollback) {
+		t.Fatal("unexpected error", err)
+	}
+
+	// using either of them should fail without modifying the store
+	for _, tx := range []sql.ChainUpdateTx{committed, rolledBack} {
+		if _, err := tx.ContractState(fcid); !errors.Is(err, ErrTxDone) {
+			t.Fatal("unexpected error", err)
+		} else if err := tx.UpdateContractState(fcid, api.ContractStateActive); !errors.Is(err, ErrTxDone) {
+			t.Fatal("unexpected error", err)
+		} else if err := tx.UpdateChainIndex(types.ChainIndex{Height: 2}); !errors.Is(err, ErrTxDone) {
+			t.Fatal("unexpected error", err)
+		}
+	}
+	if c, _ := s.Contract(fcid); c.State != api.ContractStatePending {
+		t.Fatalf("expected state %v, got %v", api.ContractStatePending, c.State)
+	} else if index, _ := s.ChainIndex(context.Background()); index.Height != 1 {
+		t.Fatalf("expected height 1, got %v", index.Height)
+	}
+}
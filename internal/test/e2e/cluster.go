@@ -64,6 +64,8 @@ type TestCluster struct {
 	S3        *minio.Client
 	S3Core    *minio.Core
 
+	s3Handler *s3.Handler
+
 	workerShutdownFns    []func(context.Context) error
 	busShutdownFns       []func(context.Context) error
 	autopilotShutdownFns []func(context.Context) error
@@ -80,6 +82,9 @@ type TestCluster struct {
 	tt           test.TT
 	wk           types.PrivateKey
 	wg           sync.WaitGroup
+
+	mu            sync.Mutex
+	lastBlockTxns int
 }
 
 type dbConfig struct {
@@ -184,6 +189,7 @@ type testClusterOptions struct {
 	autopilotCfg      *config.Autopilot
 	autopilotSettings *api.AutopilotConfig
 	busCfg            *config.Bus
+	s3Cfg             *config.S3
 	workerCfg         *config.Worker
 }
 
@@ -243,6 +249,10 @@ func newTestCluster(t *testing.T, opts testClusterOptions) *TestCluster {
 	if opts.autopilotCfg != nil {
 		apCfg = *opts.autopilotCfg
 	}
+	var s3Cfg config.S3
+	if opts.s3Cfg != nil {
+		s3Cfg = *opts.s3Cfg
+	}
 	funding := true
 	if opts.funding != nil {
 		funding = *opts.funding
@@ -351,12 +361,21 @@ func newTestCluster(t *testing.T, opts testClusterOptions) *TestCluster {
 	workerShutdownFns = append(workerShutdownFns, w.Shutdown)
 
 	// Create S3 API.
-	s3Handler, err := s3.New(busClient, w, logger, s3.Opts{})
+	s3Handler, err := s3.New(busClient, w, logger, s3.Opts{
+		HostBucketBases:   s3Cfg.HostBucketBases,
+		HostBucketEnabled: s3Cfg.HostBucketEnabled,
+		Region:            s3Cfg.Region,
+		MinObjectHealth:   s3Cfg.MinObjectHealth,
+
+		MultipartUploadMaxAge:  s3Cfg.MultipartUploadMaxAge,
+		MultipartSweepInterval: s3Cfg.MultipartSweepInterval,
+	})
 	tt.OK(err)
 
 	s3Server := http.Server{Handler: s3Handler}
 	var s3ShutdownFns []func(context.Context) error
 	s3ShutdownFns = append(s3ShutdownFns, s3Server.Shutdown)
+	s3ShutdownFns = append(s3ShutdownFns, s3Handler.Shutdown)
 
 	// Create autopilot.
 	ap, err := autopilot.New(apCfg, busClient, []autopilot.Worker{workerClient}, logger)
@@ -390,6 +409,8 @@ func newTestCluster(t *testing.T, opts testClusterOptions) *TestCluster {
 		S3:        s3Client,
 		S3Core:    s3Core,
 
+		s3Handler: s3Handler,
+
 		workerShutdownFns:    workerShutdownFns,
 		busShutdownFns:       busShutdownFns,
 		autopilotShutdownFns: autopilotShutdownFns,
@@ -507,7 +528,7 @@ func newTestBus(ctx context.Context, dir string, cfg config.Bus, cfgDb dbConfig,
 	}
 
 	// create webhooks manager
-	wh, err := webhooks.NewManager(sqlStore, logger)
+	wh, err := webhooks.NewManager(sqlStore, logger, webhooks.WithAllowInternalURLs(true))
 	if err != nil {
 		return nil, nil, nil, nil, err
 	}
@@ -680,6 +701,84 @@ func (c *TestCluster) MineBlocks(n uint64) {
 	c.sync()
 }
 
+// Height returns the height of the cluster's chain tip.
+func (c *TestCluster) Height() uint64 {
+	return c.cm.Tip().Height
+}
+
+// Target returns the target a block mined on top of the cluster's chain tip
+// has to meet.
+func (c *TestCluster) Target() types.BlockID {
+	return c.cm.TipState().ChildTarget
+}
+
+// LastBlockTxnCount returns the number of transactions, v1 and v2, in the last
+// block the cluster mined.
+func (c *TestCluster) LastBlockTxnCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastBlockTxns
+}
+
+// MineTo mines n empty blocks that split the block reward across the given
+// payouts instead of paying it to the bus wallet, the payouts have to add up to
+// the block reward. Only v1 blocks can have more than one miner payout, so
+// MineTo fails once v2 blocks are required.
+func (c *TestCluster) MineTo(payouts []types.SiacoinOutput, n uint64) error {
+	c.tt.Helper()
+	for i := uint64(0); i < n; i++ {
+		cs := c.cm.TipState()
+		if cs.Index.Height+1 >= cs.Network.HardforkV2.RequireHeight {
+			return errors.New("v2 blocks must have exactly one miner payout")
+		}
+
+		var sum types.Currency
+		for _, payout := range payouts {
+			sum = sum.Add(payout.Value)
+		}
+		if !sum.Equals(cs.BlockReward()) {
+			return fmt.Errorf("payouts add up to %v, expected the block reward %v", sum, cs.BlockReward())
+		}
+
+		block := types.Block{
+			ParentID:     cs.Index.ID,
+			Timestamp:    types.CurrentTimestamp(),
+			MinerPayouts: append([]types.SiacoinOutput(nil), payouts...),
+		}
+		if !coreutils.FindBlockNonce(cs, &block, 5*time.Second) {
+			return errors.New("failed to mine block")
+		} else if err := c.Bus.AcceptBlock(context.Background(), block); err != nil {
+			return err
+		}
+		c.mu.Lock()
+		c.lastBlockTxns = 0
+		c.mu.Unlock()
+
+		// sync every 10 blocks to avoid going out of sync with hosts
+		if (i+1)%10 == 0 {
+			c.sync()
+		}
+	}
+	c.sync()
+	return nil
+}
+
+// MineToMaturity mines n blocks followed by as many blocks as necessary for the
+// miner payout of the first block to mature, i.e. to become spendable. It
+// returns the number of blocks that were mined on top of the n requested ones.
+func (c *TestCluster) MineToMaturity(n uint64) uint64 {
+	c.tt.Helper()
+	tip := c.cm.Tip()
+	maturityHeight := c.cm.TipState().MaturityHeight()
+
+	var extra uint64
+	if tip.Height+n < maturityHeight {
+		extra = maturityHeight - (tip.Height + n)
+	}
+	c.MineBlocks(n + extra)
+	return extra
+}
+
 func (c *TestCluster) sync() {
 	tip := c.cm.Tip()
 	c.tt.Retry(300, 100*time.Millisecond, func() error {
@@ -975,11 +1074,15 @@ func (c *TestCluster) waitForHostContracts(hosts map[types.PublicKey]struct{}) {
 
 func (c *TestCluster) mineBlocks(addr types.Address, n uint64) error {
 	for i := uint64(0); i < n; i++ {
-		if block, found := coreutils.MineBlock(c.cm, addr, 5*time.Second); !found {
+		block, found := coreutils.MineBlock(c.cm, addr, 5*time.Second)
+		if !found {
 			c.tt.Fatal("failed to mine block")
 		} else if err := c.Bus.AcceptBlock(context.Background(), block); err != nil {
 			return err
 		}
+		c.mu.Lock()
+		c.lastBlockTxns = len(block.Transactions) + len(block.V2Transactions())
+		c.mu.Unlock()
 	}
 	return nil
 }
@@ -2283,6 +2283,102 @@ func TestMultipartUploads(t *testing.T) {
 	}
 }
 
+func TestMineToMaturity(t *testing.T) {
+	cluster := newTestCluster(t, testClusterOptions{
+		funding:              &clusterOptNoFunding,
+		skipRunningAutopilot: true,
+	})
+	defer cluster.Shutdown()
+	b := cluster.Bus
+	tt := cluster.tt
+
+	// the wallet has nothing to spend yet
+	wr, err := b.Wallet(context.Background())
+	tt.OK(err)
+	if !wr.Spendable.IsZero() {
+		t.Fatal("wallet should not have a spendable balance", wr.Spendable)
+	}
+
+	// mine to maturity, the extra blocks should be the maturity delay
+	start := cluster.Height()
+	delay := cluster.cm.TipState().MaturityHeight() - (start + 1)
+	extra := cluster.MineToMaturity(1)
+	if extra != delay {
+		t.Fatalf("expected %v extra blocks, got %v", delay, extra)
+	} else if height := cluster.Height(); height != start+1+extra {
+		t.Fatalf("expected height %v, got %v", start+1+extra, height)
+	}
+
+	// the first block's payout should be spendable
+	tt.Retry(100, 100*time.Millisecond, func() error {
+		wr, err := b.Wallet(context.Background())
+		if err != nil {
+			return err
+		} else if wr.Spendable.IsZero() {
+			return errors.New("wallet has no spendable balance")
+		}
+		return nil
+	})
+
+	// mining more blocks than the delay requires no extra blocks
+	if extra := cluster.MineToMaturity(delay + 2); extra != 0 {
+		t.Fatalf("expected no extra blocks, got %v", extra)
+	}
+}
+
+func TestClusterTip(t *testing.T) {
+	cluster := newTestCluster(t, testClusterOptions{
+		funding:              &clusterOptNoFunding,
+		skipRunningAutopilot: true,
+	})
+	defer cluster.Shutdown()
+
+	// mine a block, it should extend the tip and meet the reported target
+	height, target := cluster.Height(), cluster.Target()
+	cluster.MineBlocks(1)
+	if cluster.Height() != height+1 {
+		t.Fatalf("expected height %v, got %v", height+1, cluster.Height())
+	} else if tip := cluster.cm.Tip(); tip.ID.CmpWork(target) < 0 {
+		t.Fatalf("block %v doesn't meet target %v", tip.ID, target)
+	}
+}
+
+func TestMineTo(t *testing.T) {
+	cluster := newTestCluster(t, testClusterOptions{
+		funding:              &clusterOptNoFunding,
+		skipRunningAutopilot: true,
+	})
+	defer cluster.Shutdown()
+	tt := cluster.tt
+
+	// split the block reward across two addresses
+	reward := cluster.cm.TipState().BlockReward()
+	third := reward.Div64(3)
+	payouts := []types.SiacoinOutput{
+		{Address: types.StandardAddress(types.GeneratePrivateKey().PublicKey()), Value: third},
+		{Address: types.StandardAddress(types.GeneratePrivateKey().PublicKey()), Value: reward.Sub(third)},
+	}
+	height := cluster.Height()
+	tt.OK(cluster.MineTo(payouts, 1))
+	if cluster.Height() != height+1 {
+		t.Fatalf("expected height %v, got %v", height+1, cluster.Height())
+	}
+	block, ok := cluster.cm.Block(cluster.cm.Tip().ID)
+	if !ok {
+		t.Fatal("block not found")
+	} else if !reflect.DeepEqual(block.MinerPayouts, payouts) {
+		t.Fatalf("unexpected payouts %v", block.MinerPayouts)
+	}
+
+	// payouts that don't add up to the block reward are rejected
+	payouts[0].Value = payouts[0].Value.Add(types.NewCurrency64(1))
+	if err := cluster.MineTo(payouts, 1); err == nil {
+		t.Fatal("expected an error")
+	} else if cluster.Height() != height+1 {
+		t.Fatal("expected no block to be mined")
+	}
+}
+
 func TestWalletSendUnconfirmed(t *testing.T) {
 	cluster := newTestCluster(t, clusterOptsDefault)
 	defer cluster.Shutdown()
@@ -2331,6 +2427,9 @@ func TestWalletSendUnconfirmed(t *testing.T) {
 
 	// mine a block, this should confirm the transactions
 	cluster.MineBlocks(1)
+	if n := cluster.LastBlockTxnCount(); n != 2 {
+		t.Fatalf("expected 2 transactions in the mined block, got %v", n)
+	}
 	tt.Retry(100, time.Millisecond, func() error {
 		wr, err = b.Wallet(context.Background())
 		tt.OK(err)
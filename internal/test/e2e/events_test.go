@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -74,11 +76,28 @@ func TestEvents(t *testing.T) {
 		return nil
 	}
 
+	// define a helper to assert every event has a unique ID that matches the
+	// ID in the header
+	ids := make(map[string]struct{})
+	checkID := func(event webhooks.Event, header string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if event.ID == "" || event.ID != header {
+			return fmt.Errorf("unexpected event ID %q, header %q", event.ID, header)
+		} else if _, ok := ids[event.ID]; ok {
+			return fmt.Errorf("duplicate event ID %q", event.ID)
+		}
+		ids[event.ID] = struct{}{}
+		return nil
+	}
+
 	// setup test server to receive webhooks
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var webhook webhooks.Event
 		if err := json.NewDecoder(r.Body).Decode(&webhook); err != nil {
 			t.Fatal(err)
+		} else if err := checkID(webhook, r.Header.Get(webhooks.HeaderEventID)); err != nil {
+			t.Fatal(err)
 		} else if err := receiveEvent(webhook); err != nil {
 			t.Fatal(err)
 		}
@@ -189,3 +208,42 @@ func TestEvents(t *testing.T) {
 		}
 	}
 }
+
+func TestWebhookTest(t *testing.T) {
+	// setup test server that fails everything but pings
+	var pings atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event webhooks.Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil || event.Event != webhooks.WebhookEventPing {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		pings.Add(1)
+	}))
+	defer server.Close()
+
+	cluster := newTestCluster(t, testClusterOptions{})
+	defer cluster.Shutdown()
+	b := cluster.Bus
+	tt := cluster.tt
+
+	// testing the webhook pings it without registering it
+	wh := webhooks.Webhook{Module: api.ModuleContract, URL: server.URL}
+	tt.OK(b.TestWebhook(context.Background(), wh))
+	if n := pings.Load(); n != 1 {
+		t.Fatalf("expected 1 ping, got %v", n)
+	}
+	resp, err := b.Webhooks(context.Background())
+	tt.OK(err)
+	for _, hook := range resp.Webhooks {
+		if hook.URL == server.URL {
+			t.Fatal("webhook was registered")
+		}
+	}
+
+	// invalid webhooks are rejected
+	wh.Method = http.MethodGet
+	if err := b.TestWebhook(context.Background(), wh); err == nil || !strings.Contains(err.Error(), webhooks.ErrInvalidWebhookMethod.Error()) {
+		t.Fatal("unexpected error", err)
+	}
+}
@@ -74,6 +74,7 @@ func TestObjectMetadata(t *testing.T) {
 	} else if !reflect.DeepEqual(hor, &api.HeadObjectResponse{
 		ContentType:  or.Object.ContentType(),
 		Etag:         gor.Etag,
+		Health:       1,
 		LastModified: api.TimeRFC3339(orModtime),
 		Range:        &api.ContentRange{Offset: 1, Length: 1, Size: int64(len(data))},
 		Size:         int64(len(data)),
@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"context"
 	"crypto/md5"
+	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"strings"
 	"testing"
 	"time"
@@ -15,9 +17,12 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/minio/minio-go/v7"
 	rhpv2 "go.sia.tech/core/rhp/v2"
+	"go.sia.tech/core/types"
 	"go.sia.tech/gofakes3"
 	"go.sia.tech/renterd/api"
+	"go.sia.tech/renterd/config"
 	"go.sia.tech/renterd/internal/test"
+	"go.sia.tech/renterd/internal/utils"
 	"lukechampine.com/frand"
 )
 
@@ -304,6 +309,135 @@ func TestS3ObjectMetadata(t *testing.T) {
 	}, head.UserMetadata)
 }
 
+func TestS3CopyObjectMetadataDirective(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	cluster := newTestCluster(t, testClusterOptions{
+		hosts: test.RedundancySettings.TotalShards,
+	})
+	defer cluster.Shutdown()
+
+	s3 := cluster.S3
+	core := cluster.S3Core
+	tt := cluster.tt
+
+	// upload an object with metadata
+	data := frand.Bytes(32)
+	_, err := s3.PutObject(context.Background(), api.DefaultBucketName, "src", bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType:  "text/plain",
+		UserMetadata: map[string]string{"Foo": "bar", "Baz": "qux"},
+	})
+	tt.OK(err)
+
+	// helper to assert an object's data and metadata
+	assertObject := func(key, contentType string, metadata map[string]string) {
+		t.Helper()
+		obj, err := s3.GetObject(context.Background(), api.DefaultBucketName, key, minio.GetObjectOptions{})
+		tt.OK(err)
+		defer obj.Close()
+		b, err := io.ReadAll(obj)
+		tt.OK(err)
+		info, err := obj.Stat()
+		tt.OK(err)
+		if !bytes.Equal(b, data) {
+			t.Fatal("data mismatch")
+		} else if info.ContentType != contentType {
+			t.Fatalf("expected content type %q, got %q", contentType, info.ContentType)
+		} else if len(info.UserMetadata) != len(metadata) {
+			t.Fatalf("expected metadata %v, got %v", metadata, info.UserMetadata)
+		}
+		for k, v := range metadata {
+			if info.UserMetadata[k] != v {
+				t.Fatalf("expected metadata %v, got %v", metadata, info.UserMetadata)
+			}
+		}
+	}
+
+	// copy with REPLACE, the request's metadata replaces the source's
+	_, err = core.CopyObject(context.Background(), api.DefaultBucketName, "src", api.DefaultBucketName, "replaced", map[string]string{
+		"X-Amz-Metadata-Directive": "REPLACE",
+		"X-Amz-Meta-Foo":           "new",
+		"Content-Type":             "application/json",
+	}, minio.CopySrcOptions{}, minio.PutObjectOptions{})
+	tt.OK(err)
+	assertObject("replaced", "application/json", map[string]string{"Foo": "new"})
+
+	// copy without a directive, the request's metadata is ignored
+	_, err = core.CopyObject(context.Background(), api.DefaultBucketName, "src", api.DefaultBucketName, "copied", map[string]string{
+		"X-Amz-Meta-Foo": "ignored",
+		"Content-Type":   "application/json",
+	}, minio.CopySrcOptions{}, minio.PutObjectOptions{})
+	tt.OK(err)
+	assertObject("copied", "text/plain", map[string]string{"Foo": "bar", "Baz": "qux"})
+
+	// the source is unchanged
+	assertObject("src", "text/plain", map[string]string{"Foo": "bar", "Baz": "qux"})
+
+	// unknown directives are rejected
+	_, err = core.CopyObject(context.Background(), api.DefaultBucketName, "src", api.DefaultBucketName, "invalid", map[string]string{
+		"X-Amz-Metadata-Directive": "MERGE",
+	}, minio.CopySrcOptions{}, minio.PutObjectOptions{})
+	if err == nil || !strings.Contains(err.Error(), "unknown metadata directive") {
+		t.Fatal("unexpected error", err)
+	}
+}
+
+func TestS3UpdateObjectMetadata(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	cluster := newTestCluster(t, testClusterOptions{
+		hosts: test.RedundancySettings.TotalShards,
+	})
+	defer cluster.Shutdown()
+
+	s3 := cluster.S3
+	core := cluster.S3Core
+	tt := cluster.tt
+
+	// upload an object that spans multiple slabs
+	data := frand.Bytes(3 * int(test.RedundancySettings.SlabSizeNoRedundancy()))
+	uploadInfo, err := s3.PutObject(context.Background(), api.DefaultBucketName, "object", bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType:  "application/octet-stream",
+		UserMetadata: map[string]string{"Foo": "bar"},
+	})
+	tt.OK(err)
+
+	// update the object's content type and metadata by copying it onto itself
+	_, err = core.CopyObject(context.Background(), api.DefaultBucketName, "object", api.DefaultBucketName, "object", map[string]string{
+		"X-Amz-Metadata-Directive": "REPLACE",
+		"X-Amz-Meta-Baz":           "qux",
+		"Content-Type":             "video/mp4",
+	}, minio.CopySrcOptions{}, minio.PutObjectOptions{})
+	tt.OK(err)
+
+	// assert the data wasn't uploaded again
+	if bs := cluster.s3Handler.TransferStats()[api.DefaultBucketName]; bs.Uploaded != uint64(len(data)) {
+		t.Fatalf("expected %v bytes to be uploaded, got %v", len(data), bs.Uploaded)
+	}
+
+	// assert the metadata was updated and the data is unchanged
+	info, err := s3.StatObject(context.Background(), api.DefaultBucketName, "object", minio.StatObjectOptions{})
+	tt.OK(err)
+	obj, err := s3.GetObject(context.Background(), api.DefaultBucketName, "object", minio.GetObjectOptions{})
+	tt.OK(err)
+	defer obj.Close()
+	if b, err := io.ReadAll(obj); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(b, data) {
+		t.Fatal("data mismatch")
+	} else if info.ContentType != "video/mp4" {
+		t.Fatal("unexpected content type", info.ContentType)
+	} else if info.ETag != uploadInfo.ETag {
+		t.Fatal("unexpected etag", info.ETag, uploadInfo.ETag)
+	} else if len(info.UserMetadata) != 1 || info.UserMetadata["Baz"] != "qux" {
+		t.Fatal("unexpected metadata", info.UserMetadata)
+	}
+}
+
 func TestS3Authentication(t *testing.T) {
 	if testing.Short() {
 		t.SkipNow()
@@ -375,6 +509,63 @@ func TestS3Authentication(t *testing.T) {
 	assertAuth(s3Unauthenticated, false)
 }
 
+func TestS3PresignedURLs(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	cluster := newTestCluster(t, testClusterOptions{
+		hosts: test.RedundancySettings.TotalShards,
+	})
+	defer cluster.Shutdown()
+
+	s3 := cluster.S3
+	tt := cluster.tt
+
+	do := func(method, url string, body []byte) (int, []byte) {
+		t.Helper()
+		req, err := http.NewRequest(method, url, bytes.NewReader(body))
+		tt.OK(err)
+		resp, err := http.DefaultClient.Do(req)
+		tt.OK(err)
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		tt.OK(err)
+		return resp.StatusCode, b
+	}
+
+	// upload an object using a presigned PUT
+	data := frand.Bytes(32)
+	putURL, err := s3.PresignedPutObject(context.Background(), api.DefaultBucketName, "presigned", time.Minute)
+	tt.OK(err)
+	if status, body := do(http.MethodPut, putURL.String(), data); status != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", status, body)
+	}
+
+	// download it using a presigned GET
+	getURL, err := s3.PresignedGetObject(context.Background(), api.DefaultBucketName, "presigned", time.Minute, nil)
+	tt.OK(err)
+	if status, body := do(http.MethodGet, getURL.String(), nil); status != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", status, body)
+	} else if !bytes.Equal(body, data) {
+		t.Fatal("data mismatch")
+	}
+
+	// the GET URL can't be used to upload
+	if status, _ := do(http.MethodPut, getURL.String(), data); status != http.StatusForbidden {
+		t.Fatalf("unexpected status %d", status)
+	}
+
+	// tampering with the URL invalidates the signature
+	tampered := *getURL
+	tampered.Path += "2"
+	if status, body := do(http.MethodGet, tampered.String(), nil); status != http.StatusForbidden {
+		t.Fatalf("unexpected status %d", status)
+	} else if !strings.Contains(string(body), "SignatureDoesNotMatch") {
+		t.Fatalf("unexpected error: %s", body)
+	}
+}
+
 func TestS3List(t *testing.T) {
 	cluster := newTestCluster(t, testClusterOptions{
 		hosts:         test.RedundancySettings.TotalShards,
@@ -530,6 +721,592 @@ func TestS3List(t *testing.T) {
 	}
 }
 
+func TestS3ListSpecialPrefixes(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	cluster := newTestCluster(t, testClusterOptions{
+		hosts:         test.RedundancySettings.TotalShards,
+		uploadPacking: true,
+	})
+	defer cluster.Shutdown()
+
+	s3 := cluster.S3
+	core := cluster.S3Core
+	tt := cluster.tt
+
+	// create bucket
+	tt.OK(s3.MakeBucket(context.Background(), "bucket", minio.MakeBucketOptions{}))
+
+	// upload objects with utf8 and mixed-case prefixes
+	objects := []string{
+		"fileś/śpecial",
+		"FOO/bar",
+		"foo/bar",
+		"foo/baz/quux",
+		"gab/guub",
+	}
+	for _, object := range objects {
+		data := frand.Bytes(10)
+		tt.OKAll(s3.PutObject(context.Background(), "bucket", object, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{}))
+	}
+
+	flatten := func(res minio.ListBucketV2Result) []string {
+		var objs []string
+		for _, obj := range res.Contents {
+			objs = append(objs, obj.Key)
+		}
+		for _, cp := range res.CommonPrefixes {
+			objs = append(objs, cp.Prefix)
+		}
+		return objs
+	}
+
+	tests := []struct {
+		delimiter  string
+		prefix     string
+		startAfter string
+		want       []string
+	}{
+		// keys are sorted by their bytes, uppercase before lowercase
+		{"/", "", "", []string{"FOO/", "fileś/", "foo/", "gab/"}},
+		// prefixes are case sensitive
+		{"/", "F", "", []string{"FOO/"}},
+		{"/", "f", "", []string{"fileś/", "foo/"}},
+		{"", "FOO/", "", []string{"FOO/bar"}},
+		// utf8 prefixes
+		{"/", "fileś/", "", []string{"fileś/śpecial"}},
+		{"/", "fileś/ś", "", []string{"fileś/śpecial"}},
+		// a prefix without trailing slash matches the directory itself
+		{"/", "foo", "", []string{"foo/"}},
+		{"/", "foo/", "", []string{"foo/bar", "foo/baz/"}},
+		{"", "foo", "", []string{"foo/bar", "foo/baz/quux"}},
+		// start after keys with utf8 characters and mixed case
+		{"", "", "FOO/bar", []string{"fileś/śpecial", "foo/bar", "foo/baz/quux", "gab/guub"}},
+		{"", "", "fileś/śpecial", []string{"foo/bar", "foo/baz/quux", "gab/guub"}},
+		{"/", "", "fileś/", []string{"foo/", "gab/"}},
+		{"", "f", "fileś/śpecial", []string{"foo/bar", "foo/baz/quux"}},
+	}
+	for i, test := range tests {
+		result, err := core.ListObjectsV2("bucket", test.prefix, test.startAfter, "", test.delimiter, 1000)
+		tt.OK(err)
+		if got := flatten(result); !cmp.Equal(test.want, got) {
+			t.Errorf("test %d: unexpected response, want %v got %v", i, test.want, got)
+		}
+	}
+
+	// paginate over the objects one-by-one, starting after the mixed-case key
+	var got []string
+	var token string
+	for {
+		result, err := core.ListObjectsV2("bucket", "", "FOO/bar", token, "", 1)
+		tt.OK(err)
+		got = append(got, flatten(result)...)
+		if !result.IsTruncated {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	if want := []string{"fileś/śpecial", "foo/bar", "foo/baz/quux", "gab/guub"}; !cmp.Equal(want, got) {
+		t.Fatalf("unexpected keys, want %v got %v", want, got)
+	}
+}
+
+func TestS3ListLastModified(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	cluster := newTestCluster(t, testClusterOptions{
+		hosts: test.RedundancySettings.TotalShards,
+	})
+	defer cluster.Shutdown()
+
+	s3 := cluster.S3
+	tt := cluster.tt
+
+	// create bucket
+	tt.OK(s3.MakeBucket(context.Background(), "bucket", minio.MakeBucketOptions{}))
+
+	// define a helper to fetch the last modified time of an object through
+	// both the listing with and without delimiter
+	lastModified := func(key string) time.Time {
+		t.Helper()
+		var times []time.Time
+		for _, recursive := range []bool{false, true} {
+			var found bool
+			for obj := range s3.ListObjects(context.Background(), "bucket", minio.ListObjectsOptions{Recursive: recursive}) {
+				tt.OK(obj.Err)
+				if obj.Key != key {
+					continue
+				} else if obj.LastModified.IsZero() {
+					t.Fatal("expected non-zero LastModified", obj.Key)
+				}
+				times = append(times, obj.LastModified)
+				found = true
+			}
+			if !found {
+				t.Fatal("object not found", key)
+			}
+		}
+		if !times[0].Equal(times[1]) {
+			t.Fatalf("LastModified mismatch, %v != %v", times[0], times[1])
+		}
+		return times[0]
+	}
+
+	// upload an object
+	data := frand.Bytes(10)
+	tt.OKAll(s3.PutObject(context.Background(), "bucket", "object", bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{}))
+	lm := lastModified("object")
+
+	// overwrite the object, LastModified only has second precision so we have
+	// to wait for a second for the overwrite to produce a later timestamp
+	time.Sleep(time.Second)
+	data = frand.Bytes(10)
+	tt.OKAll(s3.PutObject(context.Background(), "bucket", "object", bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{}))
+
+	// assert LastModified advanced
+	if updated := lastModified("object"); !updated.After(lm) {
+		t.Fatalf("expected LastModified to advance, %v <= %v", updated, lm)
+	}
+}
+
+func TestS3BucketCreationDate(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	cluster := newTestCluster(t, testClusterOptions{})
+	defer cluster.Shutdown()
+
+	s3 := cluster.S3
+	tt := cluster.tt
+
+	// create two buckets, creation dates only have second precision so we
+	// wait for a second in between to make sure they differ
+	tt.OK(s3.MakeBucket(context.Background(), "bucket1", minio.MakeBucketOptions{}))
+	time.Sleep(time.Second)
+	tt.OK(s3.MakeBucket(context.Background(), "bucket2", minio.MakeBucketOptions{}))
+
+	// define a helper to fetch the creation dates of all buckets
+	creationDates := func() map[string]time.Time {
+		t.Helper()
+		buckets, err := s3.ListBuckets(context.Background())
+		tt.OK(err)
+		dates := make(map[string]time.Time)
+		for _, b := range buckets {
+			if b.CreationDate.IsZero() {
+				t.Fatal("expected non-zero creation date", b.Name)
+			}
+			dates[b.Name] = b.CreationDate
+		}
+		return dates
+	}
+
+	// assert the creation dates are monotonic
+	dates := creationDates()
+	if !dates["bucket2"].After(dates["bucket1"]) {
+		t.Fatalf("expected creation dates to be monotonic, %v <= %v", dates["bucket2"], dates["bucket1"])
+	}
+
+	// assert the creation dates are stable
+	for name, date := range creationDates() {
+		if !date.Equal(dates[name]) {
+			t.Fatalf("creation date of %v changed, %v != %v", name, date, dates[name])
+		}
+	}
+}
+
+func TestS3BucketLocation(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	region := "eu-central-1"
+	cluster := newTestCluster(t, testClusterOptions{
+		s3Cfg: &config.S3{Region: region},
+	})
+	defer cluster.Shutdown()
+
+	s3 := cluster.S3
+	tt := cluster.tt
+
+	// create bucket
+	tt.OK(s3.MakeBucket(context.Background(), "bucket", minio.MakeBucketOptions{}))
+
+	// assert the configured region is reported, we use a new client since
+	// the existing one cached the location when it created the bucket
+	client, err := minio.New(s3.EndpointURL().Host, &minio.Options{
+		Creds: test.S3Credentials,
+	})
+	tt.OK(err)
+	location, err := client.GetBucketLocation(context.Background(), "bucket")
+	tt.OK(err)
+	if location != region {
+		t.Fatalf("unexpected location, %q != %q", location, region)
+	}
+}
+
+func TestS3GetObjectMinHealth(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	cluster := newTestCluster(t, testClusterOptions{
+		hosts: test.RedundancySettings.TotalShards,
+		s3Cfg: &config.S3{MinObjectHealth: 0.5},
+	})
+	defer cluster.Shutdown()
+
+	b := cluster.Bus
+	w := cluster.Worker
+	s3 := cluster.S3
+	tt := cluster.tt
+
+	// create a contract set the autopilot doesn't manage so we can lower the
+	// object's health without the autopilot restoring it
+	contracts, err := b.Contracts(context.Background(), api.ContractsOpts{ContractSet: test.ContractSet})
+	tt.OK(err)
+	if len(contracts) != test.RedundancySettings.TotalShards {
+		t.Fatalf("expected %v contracts, got %v", test.RedundancySettings.TotalShards, len(contracts))
+	}
+	fcids := make([]types.FileContractID, 0, len(contracts))
+	for _, c := range contracts {
+		fcids = append(fcids, c.ID)
+	}
+	tt.OK(b.SetContractSet(context.Background(), t.Name(), fcids))
+
+	// create bucket and upload an object to that set
+	tt.OK(s3.MakeBucket(context.Background(), "bucket", minio.MakeBucketOptions{}))
+	data := frand.Bytes(10)
+	tt.OKAll(w.UploadObject(context.Background(), bytes.NewReader(data), "bucket", "object", api.UploadObjectOptions{ContractSet: t.Name()}))
+
+	// the client doesn't expose the headers of error responses, so we use a
+	// client that records them
+	recorder := &headerRecorder{RoundTripper: http.DefaultTransport}
+	client, err := minio.New(s3.EndpointURL().Host, &minio.Options{
+		Creds:     test.S3Credentials,
+		Transport: recorder,
+	})
+	tt.OK(err)
+
+	// assert the object can be downloaded while it's healthy
+	obj, err := client.GetObject(context.Background(), "bucket", "object", minio.GetObjectOptions{})
+	tt.OK(err)
+	if got, err := io.ReadAll(obj); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(got, data) {
+		t.Fatal("unexpected data")
+	}
+	tt.OK(obj.Close())
+
+	// remove all but one contract from the set to simulate a loss of
+	// redundancy
+	tt.OK(b.SetContractSet(context.Background(), t.Name(), fcids[:1]))
+	tt.OK(b.RefreshHealth(context.Background()))
+
+	// assert the GET is rejected with a 503 and a Retry-After header
+	obj, err = client.GetObject(context.Background(), "bucket", "object", minio.GetObjectOptions{})
+	tt.OK(err)
+	_, err = io.ReadAll(obj)
+	if resp := minio.ToErrorResponse(err); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatal("unexpected status code", resp.StatusCode, err)
+	} else if resp.Code != "ServiceUnavailable" {
+		t.Fatal("unexpected error code", resp.Code)
+	} else if retryAfter := recorder.header.Get("Retry-After"); retryAfter == "" {
+		t.Fatal("missing Retry-After header")
+	}
+
+	// assert HEAD requests are unaffected
+	tt.OKAll(client.StatObject(context.Background(), "bucket", "object", minio.StatObjectOptions{}))
+}
+
+func TestS3PutObjectBadDigest(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	cluster := newTestCluster(t, testClusterOptions{
+		hosts: test.RedundancySettings.TotalShards,
+	})
+	defer cluster.Shutdown()
+
+	s3 := cluster.S3
+	core := cluster.S3Core
+	tt := cluster.tt
+
+	// create bucket
+	tt.OK(s3.MakeBucket(context.Background(), "bucket", minio.MakeBucketOptions{}))
+
+	// upload an object with the MD5 of different data
+	data := frand.Bytes(100)
+	wrongMD5 := md5.Sum(frand.Bytes(100))
+	_, err := core.PutObject(context.Background(), "bucket", "object", bytes.NewReader(data), int64(len(data)), base64.StdEncoding.EncodeToString(wrongMD5[:]), "", minio.PutObjectOptions{})
+	if err == nil {
+		t.Fatal("expected upload to fail")
+	} else if code := minio.ToErrorResponse(err).Code; code != string(gofakes3.ErrBadDigest) {
+		t.Fatal("unexpected error code", code, err)
+	}
+
+	// the object should not exist
+	_, err = s3.StatObject(context.Background(), "bucket", "object", minio.StatObjectOptions{})
+	if code := minio.ToErrorResponse(err).Code; code != string(gofakes3.ErrNoSuchKey) {
+		t.Fatal("unexpected error code", code, err)
+	}
+
+	// upload it again with the right MD5
+	rightMD5 := md5.Sum(data)
+	tt.OKAll(core.PutObject(context.Background(), "bucket", "object", bytes.NewReader(data), int64(len(data)), base64.StdEncoding.EncodeToString(rightMD5[:]), "", minio.PutObjectOptions{}))
+}
+
+func TestS3HeadObject(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	cluster := newTestCluster(t, testClusterOptions{
+		hosts: test.RedundancySettings.TotalShards,
+	})
+	defer cluster.Shutdown()
+
+	s3 := cluster.S3
+	tt := cluster.tt
+
+	// create bucket
+	tt.OK(s3.MakeBucket(context.Background(), "bucket", minio.MakeBucketOptions{}))
+
+	// upload an object with metadata
+	data := frand.Bytes(100)
+	metadata := map[string]string{
+		"Foo": "bar",
+		"Baz": "quux",
+	}
+	uploadInfo, err := s3.PutObject(context.Background(), "bucket", "object", bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType:  "application/octet-stream",
+		UserMetadata: metadata,
+	})
+	tt.OK(err)
+
+	// fetch the object's info
+	info, err := s3.StatObject(context.Background(), "bucket", "object", minio.StatObjectOptions{})
+	tt.OK(err)
+
+	// assert every field is set
+	checksum := md5.Sum(data)
+	if info.Key != "object" {
+		t.Fatal("unexpected key", info.Key)
+	} else if info.Size != int64(len(data)) {
+		t.Fatal("unexpected size", info.Size)
+	} else if info.ETag != hex.EncodeToString(checksum[:]) || info.ETag != uploadInfo.ETag {
+		t.Fatal("unexpected etag", info.ETag, uploadInfo.ETag)
+	} else if info.ContentType != "application/octet-stream" {
+		t.Fatal("unexpected content type", info.ContentType)
+	} else if info.LastModified.IsZero() || time.Since(info.LastModified) > time.Minute {
+		t.Fatal("unexpected last modified", info.LastModified)
+	} else if diff := cmp.Diff(minio.StringMap(metadata), info.UserMetadata); diff != "" {
+		t.Fatal("unexpected metadata", diff)
+	}
+
+	// the client doesn't expose custom headers, so we use a client that
+	// records the response headers to assert the object's health is returned
+	// on HEAD and GET requests
+	recorder := &headerRecorder{RoundTripper: http.DefaultTransport}
+	client, err := minio.New(s3.EndpointURL().Host, &minio.Options{
+		Creds:     test.S3Credentials,
+		Transport: recorder,
+	})
+	tt.OK(err)
+	tt.OKAll(client.StatObject(context.Background(), "bucket", "object", minio.StatObjectOptions{}))
+	if health := recorder.header.Get(api.ObjectHealthHeader); health != "1" {
+		t.Fatal("unexpected health", health)
+	}
+	obj, err := client.GetObject(context.Background(), "bucket", "object", minio.GetObjectOptions{})
+	tt.OK(err)
+	tt.OKAll(io.ReadAll(obj))
+	tt.OK(obj.Close())
+	if health := recorder.header.Get(api.ObjectHealthHeader); health != "1" {
+		t.Fatal("unexpected health", health)
+	}
+
+	// a missing key should return NoSuchKey
+	_, err = s3.StatObject(context.Background(), "bucket", "missing", minio.StatObjectOptions{})
+	if code := minio.ToErrorResponse(err).Code; code != string(gofakes3.ErrNoSuchKey) {
+		t.Fatal("unexpected error code", code, err)
+	}
+}
+
+func TestS3GetObjectAttributes(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	cluster := newTestCluster(t, testClusterOptions{
+		hosts: test.RedundancySettings.TotalShards,
+	})
+	defer cluster.Shutdown()
+
+	s3 := cluster.S3
+	core := cluster.S3Core
+	tt := cluster.tt
+
+	// create bucket
+	tt.OK(s3.MakeBucket(context.Background(), "bucket", minio.MakeBucketOptions{}))
+
+	// upload an object in two parts
+	part1, part2 := frand.Bytes(100), frand.Bytes(50)
+	uploadID, err := core.NewMultipartUpload(context.Background(), "bucket", "object", minio.PutObjectOptions{})
+	tt.OK(err)
+	p1, err := core.PutObjectPart(context.Background(), "bucket", "object", uploadID, 1, bytes.NewReader(part1), int64(len(part1)), minio.PutObjectPartOptions{})
+	tt.OK(err)
+	p2, err := core.PutObjectPart(context.Background(), "bucket", "object", uploadID, 2, bytes.NewReader(part2), int64(len(part2)), minio.PutObjectPartOptions{})
+	tt.OK(err)
+	tt.OKAll(core.CompleteMultipartUpload(context.Background(), "bucket", "object", uploadID, []minio.CompletePart{
+		{PartNumber: 1, ETag: p1.ETag},
+		{PartNumber: 2, ETag: p2.ETag},
+	}, minio.PutObjectOptions{}))
+
+	// fetch the object's attributes
+	info, err := s3.StatObject(context.Background(), "bucket", "object", minio.StatObjectOptions{})
+	tt.OK(err)
+	attrs, err := s3.GetObjectAttributes(context.Background(), "bucket", "object", minio.ObjectAttributesOptions{})
+	tt.OK(err)
+	if attrs.ObjectSize != len(part1)+len(part2) {
+		t.Fatal("unexpected size", attrs.ObjectSize)
+	} else if attrs.ETag != info.ETag {
+		t.Fatal("unexpected etag", attrs.ETag, info.ETag)
+	} else if attrs.StorageClass != "STANDARD" {
+		t.Fatal("unexpected storage class", attrs.StorageClass)
+	} else if attrs.LastModified.IsZero() || !attrs.LastModified.Equal(info.LastModified) {
+		t.Fatal("unexpected last modified", attrs.LastModified, info.LastModified)
+	}
+
+	// the part count of the multipart upload is reported
+	if attrs.ObjectParts.PartsCount != 2 {
+		t.Fatal("unexpected part count", attrs.ObjectParts.PartsCount)
+	}
+
+	// the object's contents aren't downloaded
+	if bs := cluster.s3Handler.TransferStats()["bucket"]; bs.Downloaded != 0 {
+		t.Fatal("unexpected download", bs.Downloaded)
+	}
+
+	// an object that wasn't uploaded in parts doesn't report a part count
+	tt.OKAll(s3.PutObject(context.Background(), "bucket", "single", bytes.NewReader(part1), int64(len(part1)), minio.PutObjectOptions{}))
+	attrs, err = s3.GetObjectAttributes(context.Background(), "bucket", "single", minio.ObjectAttributesOptions{})
+	tt.OK(err)
+	if attrs.ObjectSize != len(part1) {
+		t.Fatal("unexpected size", attrs.ObjectSize)
+	} else if attrs.ObjectParts.PartsCount != 0 {
+		t.Fatal("unexpected part count", attrs.ObjectParts.PartsCount)
+	}
+
+	// a missing key should return NoSuchKey
+	_, err = s3.GetObjectAttributes(context.Background(), "bucket", "missing", minio.ObjectAttributesOptions{})
+	if code := minio.ToErrorResponse(err).Code; code != string(gofakes3.ErrNoSuchKey) {
+		t.Fatal("unexpected error code", code, err)
+	}
+}
+
+func TestS3PutObjectUnknownSize(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	cluster := newTestCluster(t, testClusterOptions{
+		hosts: test.RedundancySettings.TotalShards,
+	})
+	defer cluster.Shutdown()
+
+	s3 := cluster.S3
+	tt := cluster.tt
+
+	// create bucket
+	tt.OK(s3.MakeBucket(context.Background(), "bucket", minio.MakeBucketOptions{}))
+
+	// upload objects without specifying their size, one that fits in a
+	// single part and one that spans multiple parts
+	partSize := uint64(5 << 20) // minimum part size
+	for _, size := range []int{10, int(partSize) + 10} {
+		data := frand.Bytes(size)
+		path := fmt.Sprintf("object%d", size)
+		tt.OKAll(s3.PutObject(context.Background(), "bucket", path, io.LimitReader(bytes.NewReader(data), int64(size)), -1, minio.PutObjectOptions{PartSize: partSize}))
+
+		// assert the stored size matches the bytes written
+		info, err := s3.StatObject(context.Background(), "bucket", path, minio.StatObjectOptions{})
+		tt.OK(err)
+		if info.Size != int64(size) {
+			t.Fatalf("unexpected size, %v != %v", info.Size, size)
+		}
+
+		// assert the data was stored correctly
+		obj, err := s3.GetObject(context.Background(), "bucket", path, minio.GetObjectOptions{})
+		tt.OK(err)
+		if b, err := io.ReadAll(obj); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(b, data) {
+			t.Fatal("data mismatch")
+		}
+		tt.OK(obj.Close())
+	}
+}
+
+func TestS3TransferStats(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	cluster := newTestCluster(t, testClusterOptions{
+		hosts: test.RedundancySettings.TotalShards,
+	})
+	defer cluster.Shutdown()
+
+	s3 := cluster.S3
+	tt := cluster.tt
+
+	// create buckets
+	tt.OK(s3.MakeBucket(context.Background(), "bucket1", minio.MakeBucketOptions{}))
+	tt.OK(s3.MakeBucket(context.Background(), "bucket2", minio.MakeBucketOptions{}))
+
+	// upload an object to both buckets and download the first one
+	data1, data2 := frand.Bytes(100), frand.Bytes(200)
+	tt.OKAll(s3.PutObject(context.Background(), "bucket1", "object", bytes.NewReader(data1), int64(len(data1)), minio.PutObjectOptions{}))
+	tt.OKAll(s3.PutObject(context.Background(), "bucket2", "object", bytes.NewReader(data2), int64(len(data2)), minio.PutObjectOptions{}))
+	obj, err := s3.GetObject(context.Background(), "bucket1", "object", minio.GetObjectOptions{})
+	tt.OK(err)
+	tt.OKAll(io.ReadAll(obj))
+	tt.OK(obj.Close())
+
+	// HEAD requests don't transfer any data
+	tt.OKAll(s3.StatObject(context.Background(), "bucket2", "object", minio.StatObjectOptions{}))
+
+	// assert the stats
+	stats := cluster.s3Handler.TransferStats()
+	if len(stats) != 2 {
+		t.Fatal("unexpected number of buckets", len(stats))
+	} else if bs := stats["bucket1"]; bs.Uploaded != 100 || bs.Downloaded != 100 {
+		t.Fatal("unexpected stats for bucket1", bs)
+	} else if bs := stats["bucket2"]; bs.Uploaded != 200 || bs.Downloaded != 0 {
+		t.Fatal("unexpected stats for bucket2", bs)
+	}
+}
+
+// headerRecorder is a http.RoundTripper that records the headers of the last
+// response.
+type headerRecorder struct {
+	http.RoundTripper
+	header http.Header
+}
+
+func (r *headerRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.RoundTripper.RoundTrip(req)
+	if err == nil {
+		r.header = resp.Header
+	}
+	return resp, err
+}
+
 func TestS3MultipartUploads(t *testing.T) {
 	if testing.Short() {
 		t.SkipNow()
@@ -672,6 +1449,60 @@ func TestS3MultipartUploads(t *testing.T) {
 	}
 }
 
+func TestS3AbortIncompleteMultipartUploads(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	cluster := newTestCluster(t, testClusterOptions{
+		hosts:         test.RedundancySettings.TotalShards,
+		uploadPacking: true,
+	})
+	defer cluster.Shutdown()
+	s3 := cluster.S3
+	core := cluster.S3Core
+	tt := cluster.tt
+
+	// start a multipart upload and add a part
+	tt.OK(s3.MakeBucket(context.Background(), "multipart", minio.MakeBucketOptions{}))
+	uploadID, err := core.NewMultipartUpload(context.Background(), "multipart", "foo", minio.PutObjectOptions{})
+	tt.OK(err)
+	tt.OKAll(core.PutObjectPart(context.Background(), "multipart", "foo", uploadID, 1, bytes.NewReader([]byte("hello")), 5, minio.PutObjectPartOptions{}))
+
+	// sweep, the upload is too recent to be aborted
+	if aborted, err := cluster.s3Handler.SweepMultipartUploads(context.Background(), time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	} else if aborted != 0 {
+		t.Fatal("unexpected number of aborted uploads", aborted)
+	}
+	lop, err := core.ListObjectParts(context.Background(), "multipart", "foo", uploadID, 0, 0)
+	tt.OK(err)
+	if len(lop.ObjectParts) != 1 {
+		t.Fatal("unexpected number of parts", len(lop.ObjectParts))
+	}
+
+	// sweep again with a cutoff in the future, as if the max age passed
+	if aborted, err := cluster.s3Handler.SweepMultipartUploads(context.Background(), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	} else if aborted != 1 {
+		t.Fatal("unexpected number of aborted uploads", aborted)
+	}
+
+	// assert the upload and its parts are gone
+	lmu, err := core.ListMultipartUploads(context.Background(), "multipart", "", "", "", "", 0)
+	tt.OK(err)
+	if len(lmu.Uploads) != 0 {
+		t.Fatal("expected no uploads", len(lmu.Uploads))
+	}
+	if _, err := cluster.Bus.MultipartUpload(context.Background(), uploadID); !utils.IsErr(err, api.ErrMultipartUploadNotFound) {
+		t.Fatal("unexpected error", err)
+	} else if parts, err := cluster.Bus.MultipartUploadParts(context.Background(), "multipart", "/foo", uploadID, 0, 0); err != nil {
+		t.Fatal(err)
+	} else if len(parts.Parts) != 0 {
+		t.Fatal("expected parts to be removed", len(parts.Parts))
+	}
+}
+
 // TestS3MultipartPruneSlabs is a regression test for an edge case where a
 // packed slab is referenced by both a regular upload as well as a part of a
 // multipart upload. Deleting the regularly uploaded object by e.g. overwriting
@@ -381,6 +381,18 @@ func (a *AccountMgr) refillAccount(ctx context.Context, contract api.ContractMet
 		_ = a.alerts.DismissAlerts(a.shutdownCtx, alerts.IDForAccount(alertAccountRefillID, account.ID))
 	}
 
+	// an overdrawn account indicates the host disagrees with us on the
+	// balance, schedule a sync to resolve it
+	if account.IsOverdrawn() && !account.RequiresSync {
+		a.logger.Warnw("account is overdrawn, scheduling a sync",
+			"account", account.ID,
+			"host", contract.HostKey,
+			"balance", account.Balance.String(),
+			"drift", account.Drift.String())
+		a.ForHost(contract.HostKey).ScheduleSync()
+		account = a.Account(contract.HostKey)
+	}
+
 	// check if a resync is needed
 	if account.RequiresSync {
 		// sync the account
@@ -431,15 +443,15 @@ func (a *Account) Key() types.PrivateKey {
 
 // WithDeposit increases the balance of an account by the amount returned by
 // amtFn if amtFn doesn't return an error.
-func (a *Account) WithDeposit(amtFn func(types.Currency) (types.Currency, error)) error {
+func (a *Account) WithDeposit(amtFn func(api.Account) (types.Currency, error)) error {
 	a.rwmu.RLock()
 	defer a.rwmu.RUnlock()
 
 	a.mu.Lock()
-	balance := types.NewCurrency(a.acc.Balance.Uint64(), new(big.Int).Rsh(a.acc.Balance, 64).Uint64())
+	acc := a.acc.Clone()
 	a.mu.Unlock()
 
-	amt, err := amtFn(balance)
+	amt, err := amtFn(acc)
 	if err != nil {
 		return err
 	}
@@ -509,15 +521,7 @@ func (a *Account) addAmount(amt *big.Int) {
 func (a *Account) convert() api.Account {
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	return api.Account{
-		ID:            a.acc.ID,
-		CleanShutdown: a.acc.CleanShutdown,
-		HostKey:       a.acc.HostKey,
-		Balance:       new(big.Int).Set(a.acc.Balance),
-		Drift:         new(big.Int).Set(a.acc.Drift),
-		Owner:         a.acc.Owner,
-		RequiresSync:  a.acc.RequiresSync,
-	}
+	return a.acc.Clone()
 }
 
 func (a *Account) resetDrift() {
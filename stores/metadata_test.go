@@ -210,6 +210,47 @@ func TestObjectBasic(t *testing.T) {
 	}
 }
 
+// TestObjectModTimeOverwrite asserts an object's ModTime is updated when it is
+// overwritten, the S3 layer relies on this for the LastModified field.
+func TestObjectModTimeOverwrite(t *testing.T) {
+	ss := newTestSQLStore(t, defaultTestSQLStoreConfig)
+	defer ss.Close()
+
+	// add an object
+	obj := object.Object{Key: object.GenerateEncryptionKey(), Slabs: []object.SlabSlice{}}
+	if _, err := ss.addTestObject("/foo", obj); err != nil {
+		t.Fatal(err)
+	}
+
+	// backdate the object
+	past := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	if _, err := ss.DB().Exec(context.Background(), "UPDATE objects SET created_at = ? WHERE object_id = ?", past, "/foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	// define a helper to fetch the object's ModTime through the listing
+	modTime := func() time.Time {
+		t.Helper()
+		res, err := ss.ListObjects(context.Background(), api.DefaultBucketName, "/foo", "", "", "", -1)
+		if err != nil {
+			t.Fatal(err)
+		} else if len(res.Objects) != 1 {
+			t.Fatalf("unexpected number of objects, %d != 1", len(res.Objects))
+		}
+		return res.Objects[0].ModTime.Std()
+	}
+	if mt := modTime(); !mt.Equal(past) {
+		t.Fatalf("unexpected ModTime, %v != %v", mt, past)
+	}
+
+	// overwrite the object and assert the ModTime was updated
+	if _, err := ss.addTestObject("/foo", obj); err != nil {
+		t.Fatal(err)
+	} else if mt := modTime(); !mt.After(past) {
+		t.Fatalf("expected ModTime to be updated, %v <= %v", mt, past)
+	}
+}
+
 func TestObjectMetadata(t *testing.T) {
 	ss := newTestSQLStore(t, defaultTestSQLStoreConfig)
 	defer ss.Close()
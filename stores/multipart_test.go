@@ -119,6 +119,8 @@ func TestMultipartUploadWithUploadPackingRegression(t *testing.T) {
 		t.Fatalf("expected object size to be %v, got %v", totalSize, obj.Size)
 	} else if obj.TotalSize() != totalSize {
 		t.Fatalf("expected object total size to be %v, got %v", totalSize, obj.TotalSize())
+	} else if obj.PartsCount != len(parts) {
+		t.Fatalf("expected object to have %v parts, got %v", len(parts), obj.PartsCount)
 	}
 
 	// Assert it has the metadata
@@ -18,6 +18,8 @@ import (
 // The database interfaces define all methods that a SQL database must implement
 // to be used by the SQLStore.
 type (
+	// ChainUpdateTx is the transaction passed to the function given to
+	// ProcessChainUpdate, it must not be used after that function returns.
 	ChainUpdateTx interface {
 		ContractState(fcid types.FileContractID) (api.ContractState, error)
 		UpdateChainIndex(index types.ChainIndex) error
@@ -391,8 +391,8 @@ func CopyObject(ctx context.Context, tx sql.Tx, srcBucket, dstBucket, srcKey, ds
 
 	// helper to fetch metadata
 	fetchMetadata := func(objID int64) (om api.ObjectMetadata, err error) {
-		err = tx.QueryRow(ctx, "SELECT etag, health, created_at, object_id, size, mime_type FROM objects WHERE id = ?", objID).
-			Scan(&om.ETag, &om.Health, (*time.Time)(&om.ModTime), &om.Name, &om.Size, &om.MimeType)
+		err = tx.QueryRow(ctx, "SELECT etag, health, created_at, object_id, size, mime_type, parts_count FROM objects WHERE id = ?", objID).
+			Scan(&om.ETag, &om.Health, (*time.Time)(&om.ModTime), &om.Name, &om.Size, &om.MimeType, &om.PartsCount)
 		if err != nil {
 			return api.ObjectMetadata{}, fmt.Errorf("failed to fetch new object: %w", err)
 		}
@@ -420,8 +420,8 @@ func CopyObject(ctx context.Context, tx sql.Tx, srcBucket, dstBucket, srcKey, ds
 	}
 
 	// copy object
-	res, err := tx.Exec(ctx, `INSERT INTO objects (created_at, object_id, db_directory_id, db_bucket_id,`+"`key`"+`, size, mime_type, etag)
-						SELECT ?, ?, db_directory_id, ?, `+"`key`"+`, size, ?, etag
+	res, err := tx.Exec(ctx, `INSERT INTO objects (created_at, object_id, db_directory_id, db_bucket_id,`+"`key`"+`, size, mime_type, etag, parts_count)
+						SELECT ?, ?, db_directory_id, ?, `+"`key`"+`, size, ?, etag, parts_count
 						FROM objects
 						WHERE id = ?`, time.Now(), dstKey, dstBID, mimeType, srcObjID)
 	if err != nil {
@@ -1529,14 +1529,16 @@ func ObjectMetadata(ctx context.Context, tx Tx, bucket, key string) (api.Object,
 	}
 
 	// fetch metadata
+	var partsCount int
 	om, err := tx.ScanObjectMetadata(tx.QueryRow(ctx, fmt.Sprintf(`
-		SELECT %s
+		SELECT %s, o.parts_count
 		FROM objects o
 		WHERE o.id = ?
-	`, tx.SelectObjectMetadataExpr()), objID))
+	`, tx.SelectObjectMetadataExpr()), objID), &partsCount)
 	if err != nil {
 		return api.Object{}, fmt.Errorf("failed to fetch object metadata: %w", err)
 	}
+	om.PartsCount = partsCount
 
 	// fetch user metadata
 	rows, err := tx.Query(ctx, `
@@ -2420,7 +2422,7 @@ func UpdatePeerInfo(ctx context.Context, tx sql.Tx, addr string, fn func(*syncer
 }
 
 func Webhooks(ctx context.Context, tx sql.Tx) ([]webhooks.Webhook, error) {
-	rows, err := tx.Query(ctx, "SELECT module, event, url, headers FROM webhooks")
+	rows, err := tx.Query(ctx, "SELECT module, event, url, headers, method FROM webhooks")
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch webhooks: %w", err)
 	}
@@ -2430,7 +2432,7 @@ func Webhooks(ctx context.Context, tx sql.Tx) ([]webhooks.Webhook, error) {
 	for rows.Next() {
 		var webhook webhooks.Webhook
 		var headers string
-		if err := rows.Scan(&webhook.Module, &webhook.Event, &webhook.URL, &headers); err != nil {
+		if err := rows.Scan(&webhook.Module, &webhook.Event, &webhook.URL, &headers, &webhook.Method); err != nil {
 			return nil, fmt.Errorf("failed to scan webhook: %w", err)
 		} else if err := json.Unmarshal([]byte(headers), &webhook.Headers); err != nil {
 			return nil, fmt.Errorf("failed to unmarshal headers: %w", err)
@@ -2790,7 +2792,7 @@ func RecordContractSpending(ctx context.Context, tx Tx, fcid types.FileContractI
 func Object(ctx context.Context, tx Tx, bucket, key string) (api.Object, error) {
 	/// fetch object metadata
 	row := tx.QueryRow(ctx, fmt.Sprintf(`
-		SELECT %s, o.id, o.key
+		SELECT %s, o.id, o.key, o.parts_count
 		FROM objects o
 		INNER JOIN buckets b ON o.db_bucket_id = b.id
 		WHERE o.object_id = ? AND b.name = ?
@@ -2798,12 +2800,14 @@ func Object(ctx context.Context, tx Tx, bucket, key string) (api.Object, error)
 		tx.SelectObjectMetadataExpr()), key, bucket)
 	var objID int64
 	var ec object.EncryptionKey
-	om, err := tx.ScanObjectMetadata(row, &objID, (*EncryptionKey)(&ec))
+	var partsCount int
+	om, err := tx.ScanObjectMetadata(row, &objID, (*EncryptionKey)(&ec), &partsCount)
 	if errors.Is(err, dsql.ErrNoRows) {
 		return api.Object{}, api.ErrObjectNotFound
 	} else if err != nil {
 		return api.Object{}, err
 	}
+	om.PartsCount = partsCount
 
 	// fetch user metadata
 	rows, err := tx.Query(ctx, `
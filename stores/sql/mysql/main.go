@@ -164,8 +164,8 @@ func (tx *MainDatabaseTx) AddWebhook(ctx context.Context, wh webhooks.Webhook) e
 		}
 		headers = string(h)
 	}
-	_, err := tx.Exec(ctx, "INSERT INTO webhooks (created_at, module, event, url, headers) VALUES (?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE headers = VALUES(headers)",
-		time.Now(), wh.Module, wh.Event, wh.URL, headers)
+	_, err := tx.Exec(ctx, "INSERT INTO webhooks (created_at, module, event, url, headers, method) VALUES (?, ?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE headers = VALUES(headers), method = VALUES(method)",
+		time.Now(), wh.Module, wh.Event, wh.URL, headers, wh.Method)
 	if err != nil {
 		return fmt.Errorf("failed to insert webhook: %w", err)
 	}
@@ -230,6 +230,11 @@ func (tx *MainDatabaseTx) CompleteMultipartUpload(ctx context.Context, bucket, k
 		return "", fmt.Errorf("failed to insert object: %w", err)
 	}
 
+	// store the number of parts, the ETag doesn't encode it
+	if _, err := tx.Exec(ctx, "UPDATE objects SET parts_count = ? WHERE id = ?", len(neededParts), objID); err != nil {
+		return "", fmt.Errorf("failed to update parts count: %w", err)
+	}
+
 	// update slices
 	updateSlicesStmt, err := tx.Prepare(ctx, `
 			UPDATE slices s
@@ -0,0 +1 @@
+ALTER TABLE `webhooks` ADD COLUMN `method` varchar(8) NOT NULL DEFAULT 'POST';
@@ -0,0 +1 @@
+ALTER TABLE `objects` ADD COLUMN `parts_count` int NOT NULL DEFAULT 0;
@@ -339,6 +339,7 @@ CREATE TABLE `objects` (
   `size` bigint DEFAULT NULL,
   `mime_type` longtext,
   `etag` varchar(191) DEFAULT NULL,
+  `parts_count` int NOT NULL DEFAULT '0',
   PRIMARY KEY (`id`),
   UNIQUE KEY `idx_object_bucket` (`db_bucket_id`,`object_id`),
   KEY `idx_objects_db_bucket_id` (`db_bucket_id`),
@@ -391,6 +392,7 @@ CREATE TABLE `webhooks` (
   `event` varchar(255) NOT NULL,
   `url` varchar(255) NOT NULL,
   `headers` JSON DEFAULT ('{}'),
+  `method` varchar(8) NOT NULL DEFAULT 'POST',
   PRIMARY KEY (`id`),
   UNIQUE KEY `idx_module_event_url` (`module`,`event`,`url`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
@@ -163,8 +163,8 @@ func (tx *MainDatabaseTx) AddWebhook(ctx context.Context, wh webhooks.Webhook) e
 		}
 		headers = string(h)
 	}
-	_, err := tx.Exec(ctx, "INSERT INTO webhooks (created_at, module, event, url, headers) VALUES (?, ?, ?, ?, ?) ON CONFLICT DO UPDATE SET headers = EXCLUDED.headers",
-		time.Now(), wh.Module, wh.Event, wh.URL, headers)
+	_, err := tx.Exec(ctx, "INSERT INTO webhooks (created_at, module, event, url, headers, method) VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT DO UPDATE SET headers = EXCLUDED.headers, method = EXCLUDED.method",
+		time.Now(), wh.Module, wh.Event, wh.URL, headers, wh.Method)
 	if err != nil {
 		return fmt.Errorf("failed to insert webhook: %w", err)
 	}
@@ -229,6 +229,11 @@ func (tx *MainDatabaseTx) CompleteMultipartUpload(ctx context.Context, bucket, k
 		return "", fmt.Errorf("failed to insert object: %w", err)
 	}
 
+	// store the number of parts, the ETag doesn't encode it
+	if _, err := tx.Exec(ctx, "UPDATE objects SET parts_count = ? WHERE id = ?", len(neededParts), objID); err != nil {
+		return "", fmt.Errorf("failed to update parts count: %w", err)
+	}
+
 	// update slices
 	updateSlicesStmt, err := tx.Prepare(ctx, `
 			WITH cte AS (
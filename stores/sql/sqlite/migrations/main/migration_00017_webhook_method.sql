@@ -0,0 +1 @@
+ALTER TABLE `webhooks` ADD COLUMN `method` text NOT NULL DEFAULT 'POST';
@@ -27,6 +27,7 @@ func TestWebhooks(t *testing.T) {
 		Headers: map[string]string{
 			"foo2": "bar2",
 		},
+		Method: "PUT",
 	}
 
 	// Add hook.
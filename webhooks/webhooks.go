@@ -2,20 +2,61 @@ package webhooks
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"go.uber.org/zap"
+	"lukechampine.com/frand"
 )
 
-var ErrWebhookNotFound = errors.New("Webhook not found")
+var (
+	ErrWebhookNotFound = errors.New("Webhook not found")
+
+	// ErrQueueNotFound is returned when there is no queue for a URL.
+	ErrQueueNotFound = errors.New("queue not found")
+
+	// ErrPayloadTooLarge is returned when the marshaled event exceeds the
+	// manager's max payload size.
+	ErrPayloadTooLarge = errors.New("payload too large")
+
+	// ErrInvalidWebhookURL is returned when a webhook is registered with a
+	// URL that is malformed, doesn't use http(s) or points to an internal
+	// address while those aren't allowed.
+	ErrInvalidWebhookURL = errors.New("invalid webhook URL")
+
+	// ErrInvalidWebhookMethod is returned when a webhook is registered with
+	// an HTTP method other than POST or PUT.
+	ErrInvalidWebhookMethod = errors.New("invalid webhook method")
+
+	// ErrShuttingDown is returned when an event is broadcasted after the
+	// manager started shutting down.
+	ErrShuttingDown = errors.New("manager is shutting down")
+
+	// ErrShutdownTimeout is returned by Shutdown when deliveries don't return
+	// in time after they were cancelled.
+	ErrShutdownTimeout = errors.New("timed out waiting for deliveries to be cancelled")
+)
+
+const (
+	LifecycleWebhookRegistered LifecycleEventType = "webhookRegistered"
+	LifecycleWebhookDeleted    LifecycleEventType = "webhookDeleted"
+	LifecycleQueueCreated      LifecycleEventType = "queueCreated"
+	LifecycleQueueDrained      LifecycleEventType = "queueDrained"
+)
 
 type (
 	WebhookStore interface {
@@ -31,6 +72,108 @@ type (
 
 type HeaderOption func(headers map[string]string)
 
+// ManagerOption is an option that can be passed to NewManager.
+type ManagerOption func(m *Manager)
+
+// WithMaxPayloadSize configures the maximum size of an event's marshaled body,
+// events that exceed it are dropped instead of being delivered. A size of 0
+// means no limit.
+func WithMaxPayloadSize(size int) ManagerOption {
+	return func(m *Manager) {
+		m.cfg.maxPayloadSize = size
+	}
+}
+
+// WithGzip enables gzip compression of request bodies that exceed the given
+// threshold in bytes. Compressed requests are sent with the 'Content-Encoding:
+// gzip' header, this includes the ping that is sent when registering a Webhook
+// so receivers that don't support compression are rejected early.
+func WithGzip(threshold int) ManagerOption {
+	return func(m *Manager) {
+		m.cfg.gzip = true
+		m.cfg.gzipThreshold = threshold
+	}
+}
+
+// WithMaxConcurrentDeliveries limits the number of events that are delivered
+// concurrently across all webhook URLs. Events for the same URL are always
+// delivered in order. A limit of 0 means no limit.
+func WithMaxConcurrentDeliveries(n int) ManagerOption {
+	return func(m *Manager) {
+		m.maxConcurrentDeliveries = n
+	}
+}
+
+// WithAllowInternalURLs allows registering webhooks with URLs that point to
+// loopback, link-local or unspecified addresses, e.g. 'localhost' or the cloud
+// metadata address. Such URLs are rejected by default.
+func WithAllowInternalURLs(allow bool) ManagerOption {
+	return func(m *Manager) {
+		m.allowInternalURLs = allow
+	}
+}
+
+// WithAllowedURLPrefixes allows registering webhooks with URLs that start with
+// one of the given prefixes even if they point to an internal address, e.g.
+// the node's own API address.
+func WithAllowedURLPrefixes(prefixes ...string) ManagerOption {
+	return func(m *Manager) {
+		m.allowedURLPrefixes = append(m.allowedURLPrefixes, prefixes...)
+	}
+}
+
+// WithHeartbeat configures the manager to broadcast a ping event to all
+// registered webhooks on startup and every interval after that, allowing
+// receivers to monitor the manager's liveness.
+func WithHeartbeat(interval time.Duration) ManagerOption {
+	return func(m *Manager) {
+		m.heartbeatInterval = interval
+	}
+}
+
+// WithReplayBuffer keeps the last size broadcasted events in memory, when a
+// new webhook is registered the buffered events that match it are replayed to
+// it. If maxAge is not 0, events older than maxAge aren't replayed. A size of 0
+// disables the buffer.
+func WithReplayBuffer(size int, maxAge time.Duration) ManagerOption {
+	return func(m *Manager) {
+		m.replaySize = size
+		m.replayMaxAge = maxAge
+	}
+}
+
+// WithQueuePerModule configures the manager to use a separate queue per URL
+// and module instead of a single queue per URL. That way a receiver that is
+// slow to handle the events of one module doesn't delay the events of other
+// modules sent to the same URL. The tradeoff is that events are only
+// delivered in order within a module, events of different modules might
+// arrive in a different order than they were broadcasted. It also increases
+// the number of concurrent requests to a URL.
+func WithQueuePerModule(perModule bool) ManagerOption {
+	return func(m *Manager) {
+		m.queuePerModule = perModule
+	}
+}
+
+// WithShutdownTimeout configures how long Shutdown waits for in-flight
+// deliveries to return after they were cancelled, if they don't return in time
+// Shutdown returns ErrShutdownTimeout instead of blocking forever.
+func WithShutdownTimeout(timeout time.Duration) ManagerOption {
+	return func(m *Manager) {
+		m.shutdownTimeout = timeout
+	}
+}
+
+// WithObserver registers a callback that is called when a webhook is
+// registered or deleted, when a queue is created and when a queue was drained.
+// It's called synchronously, possibly while the manager's lock is held, so it
+// must not block or call into the manager.
+func WithObserver(fn func(LifecycleEvent)) ManagerOption {
+	return func(m *Manager) {
+		m.observer = fn
+	}
+}
+
 func WithBasicAuth(username, password string) HeaderOption {
 	return func(headers map[string]string) {
 		headers["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
@@ -42,8 +185,27 @@ type NoopBroadcaster struct{}
 func (NoopBroadcaster) BroadcastAction(_ context.Context, _ Event) error { return nil }
 
 const (
-	webhookTimeout   = 10 * time.Second
-	WebhookEventPing = "ping"
+	defaultShutdownTimeout = 10 * time.Second
+	webhookTimeout         = 10 * time.Second
+	WebhookEventPing       = "ping"
+
+	// maxErrorBodySize is the maximum number of bytes of a response body that
+	// are included in the error when a receiver responds with an unexpected
+	// status code.
+	maxErrorBodySize = 1 << 10
+
+	// EventVersion is the version of the event payloads sent by the manager,
+	// it's bumped whenever the shape of a payload changes so receivers can
+	// branch on it. Events without a version are considered version 1.
+	EventVersion = 1
+
+	// HeaderEventID is the header that contains the ID of the event in the
+	// request body.
+	HeaderEventID = "X-Renterd-Event-ID"
+
+	// HeaderEventVersion is the header that contains the version of the
+	// event in the request body.
+	HeaderEventVersion = "X-Renterd-Event-Version"
 )
 
 type (
@@ -52,18 +214,47 @@ type (
 		Event   string            `json:"event"`
 		URL     string            `json:"url"`
 		Headers map[string]string `json:"headers,omitempty"`
+
+		// Method is the HTTP method used to deliver events, either POST or
+		// PUT. It defaults to POST if empty.
+		Method string `json:"method,omitempty"`
 	}
 
+	// WebhookQueueInfo describes a webhook queue, the bus serves it as an
+	// api.WebhookQueueInfo.
 	WebhookQueueInfo struct {
-		URL  string `json:"url"`
-		Size int    `json:"size"`
+		URL             string        `json:"url"`
+		Module          string        `json:"module,omitempty"`
+		Size            int           `json:"size"`
+		DroppedOversize uint64        `json:"droppedOversize"`
+		OldestEventAge  time.Duration `json:"oldestEventAge"`
 	}
 
-	// Event describes an event that has been triggered.
+	// LifecycleEventType is the type of a LifecycleEvent.
+	LifecycleEventType string
+
+	// LifecycleEvent describes a change to the manager's webhooks or queues,
+	// it's passed to the observer registered with WithObserver. Webhook is
+	// only set for webhook events and omits the webhook's headers. URL is set
+	// for all events, Module only for queue events if there is a queue per
+	// module.
+	LifecycleEvent struct {
+		Type    LifecycleEventType
+		Webhook Webhook
+		URL     string
+		Module  string
+	}
+
+	// Event describes an event that has been triggered. The ID is assigned
+	// when the event is broadcasted and stays the same across deliveries,
+	// receivers can use it to deduplicate events that were delivered more
+	// than once.
 	Event struct {
+		ID      string      `json:"id,omitempty"`
 		Module  string      `json:"module"`
 		Event   string      `json:"event"`
 		Payload interface{} `json:"payload,omitempty"`
+		Version uint64      `json:"version,omitempty"`
 	}
 )
 
@@ -74,57 +265,163 @@ type Manager struct {
 
 	shutdownCtx       context.Context
 	shutdownCtxCancel context.CancelFunc
+	shutdownTimeout   time.Duration
+
+	allowInternalURLs       bool
+	allowedURLPrefixes      []string
+	cfg                     deliveryConfig
+	heartbeatInterval       time.Duration
+	maxConcurrentDeliveries int
+	observer                func(LifecycleEvent)
+	queuePerModule          bool
+	replayMaxAge            time.Duration
+	replaySize              int
+	closedChan              chan struct{}
+
+	// shutdownDone is closed once the first call to Shutdown returned, its
+	// result is stored in shutdownErr
+	shutdownDone chan struct{}
+	shutdownErr  error
+
+	// deliverySem bounds the number of concurrent deliveries, it's nil if
+	// there is no limit
+	deliverySem chan struct{}
 
 	mu       sync.Mutex
-	queues   map[string]*eventQueue // URL -> queue
+	closed   bool
+	queues   map[string]*eventQueue // URL (and module) -> queue
+	replay   []queuedEvent          // oldest first
 	webhooks map[string]Webhook
 }
 
+// deliveryConfig contains the settings used when delivering events.
+type deliveryConfig struct {
+	gzip           bool
+	gzipThreshold  int
+	maxPayloadSize int
+}
+
 type eventQueue struct {
-	ctx     context.Context
-	logger  *zap.SugaredLogger
-	headers map[string]string
-	url     string
+	ctx      context.Context
+	cfg      deliveryConfig
+	sem      chan struct{}
+	logger   *zap.SugaredLogger
+	observer func(LifecycleEvent)
+	module   string // only set if there is a queue per module
+	url      string
+	wg       *sync.WaitGroup
+
+	// flushMu is held while the queue is flushed, it serializes flushes
+	flushMu sync.Mutex
+
+	mu              sync.Mutex
+	headers         map[string]string
+	method          string
+	isDequeueing    bool
+	isFlushing      bool
+	dequeued        chan struct{} // closed once the dequeue goroutine returned
+	events          []queuedEvent
+	droppedOversize uint64
+}
+
+type queuedEvent struct {
+	Event
+	enqueuedAt time.Time
 
-	mu           sync.Mutex
-	isDequeueing bool
-	events       []Event
+	// attempts is the number of times delivering the event was attempted, an
+	// event is only attempted more than once if a flush failed to deliver it
+	attempts int
+}
+
+// statusError is returned when a receiver responds with an unexpected status
+// code.
+type statusError struct {
+	statusCode int
+	body       string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("Webhook returned unexpected status %v: %v", e.statusCode, e.body)
 }
 
 func (m *Manager) BroadcastAction(_ context.Context, event Event) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	if m.closed {
+		return ErrShuttingDown
+	}
+	if event.ID == "" {
+		event.ID = newEventID()
+	}
 	for _, hook := range m.webhooks {
 		if !hook.Matches(event) {
 			continue
 		}
+		m.enqueue(hook, event)
+	}
+	if m.replaySize > 0 {
+		if len(m.replay) == m.replaySize {
+			m.replay = m.replay[1:]
+		}
+		m.replay = append(m.replay, queuedEvent{Event: event, enqueuedAt: time.Now()})
+	}
+	return nil
+}
 
-		// Find queue or create one.
-		queue, exists := m.queues[hook.URL]
-		if !exists {
-			queue = &eventQueue{
-				ctx:     m.shutdownCtx,
-				logger:  m.logger,
-				headers: hook.Headers,
-				url:     hook.URL,
-			}
-			m.queues[hook.URL] = queue
+// BroadcastActionSync sends the event to all matching webhooks and blocks
+// until every delivery either succeeded or failed. Unlike BroadcastAction the
+// event bypasses the queues, so it might be delivered before events that were
+// broadcasted earlier. The returned error contains all failed deliveries.
+func (m *Manager) BroadcastActionSync(ctx context.Context, event Event) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return ErrShuttingDown
+	}
+	if event.ID == "" {
+		event.ID = newEventID()
+	}
+	var hooks []Webhook
+	for _, hook := range m.webhooks {
+		if hook.Matches(event) {
+			hooks = append(hooks, hook)
 		}
+	}
+	m.mu.Unlock()
 
-		// Add event and launch goroutine to start dequeueing if necessary.
-		queue.mu.Lock()
-		queue.events = append(queue.events, event)
-		if !queue.isDequeueing {
-			queue.isDequeueing = true
-			m.wg.Add(1)
-			go func() {
-				queue.dequeue()
-				m.wg.Done()
-			}()
+	var wg sync.WaitGroup
+	errs := make([]error, len(hooks))
+	for i, hook := range hooks {
+		wg.Add(1)
+		go func(i int, hook Webhook) {
+			defer wg.Done()
+			if err := m.sendSync(ctx, hook, event); err != nil {
+				errs[i] = fmt.Errorf("failed to send Webhook event %v to %v: %w", event.String(), hook.URL, err)
+			}
+		}(i, hook)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// BroadcastPing sends a ping event to every registered webhook URL. Every URL
+// receives a single ping, regardless of the number of webhooks registered for
+// it.
+func (m *Manager) BroadcastPing() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return
+	}
+	ping := Event{ID: newEventID(), Event: WebhookEventPing}
+	pinged := make(map[string]struct{})
+	for _, hook := range m.webhooks {
+		if _, ok := pinged[hook.URL]; ok {
+			continue
 		}
-		queue.mu.Unlock()
+		pinged[hook.URL] = struct{}{}
+		m.enqueue(hook, ping)
 	}
-	return nil
 }
 
 func (m *Manager) Delete(ctx context.Context, wh Webhook) error {
@@ -134,6 +431,40 @@ func (m *Manager) Delete(ctx context.Context, wh Webhook) error {
 		return err
 	}
 	delete(m.webhooks, wh.String())
+	m.notifyWebhook(LifecycleWebhookDeleted, wh)
+	return nil
+}
+
+// Flush delivers the events that are queued for the given URL right away and
+// returns once the URL's queues are empty or a delivery failed. If a queue is
+// being dequeued, Flush waits for the delivery that is in flight and takes
+// over. If a delivery fails, the event that failed remains queued and the queue
+// is dequeued in the background again.
+func (m *Manager) Flush(url string) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return ErrShuttingDown
+	}
+	var queues []*eventQueue
+	for _, queue := range m.queues {
+		if queue.url == url {
+			queues = append(queues, queue)
+		}
+	}
+	if len(queues) == 0 {
+		m.mu.Unlock()
+		return fmt.Errorf("%w: %v", ErrQueueNotFound, url)
+	}
+	m.wg.Add(1)
+	m.mu.Unlock()
+	defer m.wg.Done()
+
+	for _, queue := range queues {
+		if err := queue.flush(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -146,44 +477,248 @@ func (m *Manager) Info() ([]Webhook, []WebhookQueueInfo) {
 			Event:  hook.Event,
 			Module: hook.Module,
 			URL:    hook.URL,
+			Method: hook.Method,
 		})
 	}
 	var queueInfos []WebhookQueueInfo
 	for _, queue := range m.queues {
 		queue.mu.Lock()
+		var oldest time.Duration
+		if len(queue.events) > 0 {
+			oldest = time.Since(queue.events[0].enqueuedAt)
+		}
 		queueInfos = append(queueInfos, WebhookQueueInfo{
-			URL:  queue.url,
-			Size: len(queue.events),
+			URL:             queue.url,
+			Module:          queue.module,
+			Size:            len(queue.events),
+			DroppedOversize: queue.droppedOversize,
+			OldestEventAge:  oldest,
 		})
 		queue.mu.Unlock()
 	}
 	return hooks, queueInfos
 }
 
-func (m *Manager) Register(ctx context.Context, wh Webhook) error {
-	ctx, cancel := context.WithTimeout(m.shutdownCtx, webhookTimeout)
-	defer cancel()
+// MatchingWebhooks returns the registered webhooks that match the given event,
+// i.e. the webhooks the event would be delivered to if it was broadcasted.
+// Nothing is enqueued. Like in Webhooks, headers are omitted and the webhooks
+// are sorted.
+func (m *Manager) MatchingWebhooks(event Event) []Webhook {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var hooks []Webhook
+	for _, hook := range m.webhooks {
+		if !hook.Matches(event) {
+			continue
+		}
+		hooks = append(hooks, Webhook{
+			Event:  hook.Event,
+			Module: hook.Module,
+			URL:    hook.URL,
+			Method: hook.Method,
+		})
+	}
+	sortWebhooks(hooks)
+	return hooks
+}
 
-	// Test URL.
-	err := sendEvent(ctx, wh.URL, wh.Headers, Event{
-		Event: WebhookEventPing,
+// PendingEvents returns a snapshot of the events that are queued for the given
+// URL, in the order they were enqueued. Events that are currently being
+// delivered aren't included. If queues are kept per module, the events of all
+// of the URL's queues are returned.
+func (m *Manager) PendingEvents(url string) []Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var pending []queuedEvent
+	for _, queue := range m.queues {
+		if queue.url != url {
+			continue
+		}
+		queue.mu.Lock()
+		pending = append(pending, queue.events...)
+		queue.mu.Unlock()
+	}
+	sort.SliceStable(pending, func(i, j int) bool {
+		return pending[i].enqueuedAt.Before(pending[j].enqueuedAt)
 	})
-	if err != nil {
+	events := make([]Event, len(pending))
+	for i := range pending {
+		events[i] = pending[i].Event
+	}
+	return events
+}
+
+func (m *Manager) Register(ctx context.Context, wh Webhook) error {
+	// Test URL.
+	if err := m.Test(ctx, wh); err != nil {
 		return err
 	}
+	wh.Method = wh.method()
 
 	// Add Webhook.
+	ctx, cancel := context.WithTimeout(m.shutdownCtx, webhookTimeout)
+	defer cancel()
 	if err := m.store.AddWebhook(ctx, wh); err != nil {
 		return err
 	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	_, exists := m.webhooks[wh.String()]
 	m.webhooks[wh.String()] = wh
+	m.notifyWebhook(LifecycleWebhookRegistered, wh)
+
+	// Replay buffered events to new webhooks.
+	if !exists && !m.closed {
+		for _, event := range m.replay {
+			if m.replayMaxAge > 0 && time.Since(event.enqueuedAt) > m.replayMaxAge {
+				continue
+			} else if wh.Matches(event.Event) {
+				m.enqueue(wh, event.Event)
+			}
+		}
+	}
+	return nil
+}
+
+// UpdateWebhook replaces the registered webhook old with new. Like in Register,
+// the new webhook is pinged first and the registration is only swapped if the
+// ping succeeds. If the module and event are unchanged, the events queued for
+// the old webhook are moved over to the new one instead of being lost, and
+// buffered events aren't replayed. An error is returned if old isn't
+// registered.
+func (m *Manager) UpdateWebhook(ctx context.Context, old, new Webhook) error {
+	m.mu.Lock()
+	_, exists := m.webhooks[old.String()]
+	m.mu.Unlock()
+	if !exists {
+		return fmt.Errorf("%w: %v", ErrWebhookNotFound, old)
+	}
+
+	// Test URL.
+	if err := m.Test(ctx, new); err != nil {
+		return err
+	}
+	new.Method = new.method()
+
+	// Swap Webhook, like in Delete the lock is held while the store is
+	// updated so the webhook can't be deleted or updated concurrently. The old
+	// webhook might have been removed while the new one was pinged so it's
+	// looked up again.
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.webhooks[old.String()]; !exists {
+		return fmt.Errorf("%w: %v", ErrWebhookNotFound, old)
+	}
+	_, newExists := m.webhooks[new.String()]
+
+	// The new webhook is added first so the old one remains registered if
+	// that fails.
+	ctx, cancel := context.WithTimeout(m.shutdownCtx, webhookTimeout)
+	defer cancel()
+	if err := m.store.AddWebhook(ctx, new); err != nil {
+		return err
+	}
+	if old.String() != new.String() {
+		if err := m.store.DeleteWebhook(ctx, old); err != nil {
+			if !newExists {
+				err = errors.Join(err, m.store.DeleteWebhook(ctx, new))
+			}
+			return err
+		}
+	}
+	delete(m.webhooks, old.String())
+	m.webhooks[new.String()] = new
+	if old.String() != new.String() {
+		m.notifyWebhook(LifecycleWebhookDeleted, old)
+	}
+	m.notifyWebhook(LifecycleWebhookRegistered, new)
+
+	// Migrate queued events.
+	if !m.closed && old.Module == new.Module && old.Event == new.Event {
+		m.migrateQueues(old, new)
+	}
 	return nil
 }
 
+// Webhooks returns the registered webhooks for the given module, or all
+// webhooks if the module is empty. Like in Info, headers are omitted. The
+// webhooks are sorted by URL, module and event so the result is stable across
+// calls.
+func (m *Manager) Webhooks(module string) []Webhook {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var hooks []Webhook
+	for _, hook := range m.webhooks {
+		if module != "" && hook.Module != module {
+			continue
+		}
+		hooks = append(hooks, Webhook{
+			Event:  hook.Event,
+			Module: hook.Module,
+			URL:    hook.URL,
+			Method: hook.Method,
+		})
+	}
+	sortWebhooks(hooks)
+	return hooks
+}
+
+// Test sends a ping to the webhook's URL without registering it, it returns an
+// error if the URL can't be reached or doesn't respond with a 2xx status code.
+// If compression is enabled the ping is always compressed.
+func (m *Manager) Test(ctx context.Context, wh Webhook) error {
+	if err := validateURL(wh.URL, m.allowInternalURLs || hasAllowedPrefix(wh.URL, m.allowedURLPrefixes)); err != nil {
+		return err
+	} else if err := validateMethod(wh.Method); err != nil {
+		return err
+	}
+
+	// the ping is aborted if the caller's context is done or the manager is
+	// shut down
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	stop := context.AfterFunc(m.shutdownCtx, cancel)
+	defer stop()
+
+	cfg := m.cfg
+	if cfg.gzip {
+		cfg.gzipThreshold = 0
+	}
+	return sendEvent(ctx, cfg, wh.method(), wh.URL, wh.Headers, Event{
+		ID:    newEventID(),
+		Event: WebhookEventPing,
+	})
+}
+
+// Shutdown gracefully shuts down the manager. New events are no longer
+// accepted but the events that are already queued continue to be delivered
+// until all queues are drained or the given context expires, at which point
+// in-flight deliveries are cancelled. If the cancelled deliveries don't return
+// within the manager's shutdown timeout, the queues that are still being
+// dequeued are logged and ErrShutdownTimeout is returned. Calling Shutdown again
+// blocks until the first call returned and returns its result.
 func (m *Manager) Shutdown(ctx context.Context) error {
-	m.shutdownCtxCancel()
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-m.shutdownDone:
+			return m.shutdownErr
+		}
+	}
+	m.closed = true
+	close(m.closedChan)
+	m.mu.Unlock()
+
+	m.shutdownErr = m.shutdown(ctx)
+	close(m.shutdownDone)
+	return m.shutdownErr
+}
+
+func (m *Manager) shutdown(ctx context.Context) error {
+	defer m.shutdownCtxCancel()
 
 	waitChan := make(chan struct{})
 	go func() {
@@ -193,33 +728,325 @@ func (m *Manager) Shutdown(ctx context.Context) error {
 
 	select {
 	case <-ctx.Done():
-		return ctx.Err()
 	case <-waitChan:
+		return nil
+	}
+
+	// cancel in-flight deliveries and wait for them to return
+	m.shutdownCtxCancel()
+	t := time.NewTimer(m.shutdownTimeout)
+	defer t.Stop()
+	select {
+	case <-waitChan:
+		return ctx.Err()
+	case <-t.C:
+	}
+
+	m.mu.Lock()
+	for _, queue := range m.queues {
+		queue.mu.Lock()
+		if queue.isDequeueing {
+			m.logger.Warnw("webhook queue didn't stop in time", "url", queue.url, "size", len(queue.events))
+		}
+		queue.mu.Unlock()
+	}
+	m.mu.Unlock()
+	return fmt.Errorf("%w: %w", ErrShutdownTimeout, ctx.Err())
+}
+
+func (m *Manager) enqueue(hook Webhook, event Event) {
+	m.enqueueEvent(hook, queuedEvent{Event: event, enqueuedAt: time.Now()})
+}
+
+func (m *Manager) enqueueEvent(hook Webhook, event queuedEvent) {
+	// Find queue or create one.
+	key := hook.URL
+	var module string
+	if m.queuePerModule {
+		key = fmt.Sprintf("%v.%v", hook.URL, event.Module)
+		module = event.Module
+	}
+	queue, exists := m.queues[key]
+	if !exists {
+		queue = &eventQueue{
+			ctx:      m.shutdownCtx,
+			cfg:      m.cfg,
+			sem:      m.deliverySem,
+			logger:   m.logger,
+			headers:  hook.Headers,
+			method:   hook.method(),
+			module:   module,
+			observer: m.observer,
+			url:      hook.URL,
+			wg:       &m.wg,
+		}
+		m.queues[key] = queue
+		if m.observer != nil {
+			m.observer(LifecycleEvent{Type: LifecycleQueueCreated, URL: queue.url, Module: queue.module})
+		}
+	}
+
+	// Add event and launch goroutine to start dequeueing if necessary, a queue
+	// that is being flushed is drained by the flush.
+	queue.mu.Lock()
+	queue.events = append(queue.events, event)
+	if !queue.isDequeueing && !queue.isFlushing {
+		queue.startDequeueing()
+	}
+	queue.mu.Unlock()
+}
+
+// notifyWebhook notifies the observer, if any, that the given webhook was
+// registered or deleted. The webhook's headers are omitted.
+func (m *Manager) notifyWebhook(typ LifecycleEventType, wh Webhook) {
+	if m.observer == nil {
+		return
+	}
+	m.observer(LifecycleEvent{
+		Type: typ,
+		Webhook: Webhook{
+			Event:  wh.Event,
+			Module: wh.Module,
+			URL:    wh.URL,
+			Method: wh.Method,
+		},
+		URL: wh.URL,
+	})
+}
+
+// migrateQueues moves the events that are queued for the old webhook over to
+// the new one, the caller must hold the manager's lock and the old webhook
+// must already be unregistered. If the URL is unchanged, the queues' headers
+// and method are updated instead. Events that are also queued for another webhook with
+// the old URL stay in the old queue, events that are being delivered aren't
+// migrated.
+func (m *Manager) migrateQueues(old, new Webhook) {
+	for _, queue := range m.queues {
+		if queue.url != old.URL {
+			continue
+		} else if old.URL == new.URL {
+			queue.mu.Lock()
+			queue.headers = new.Headers
+			queue.method = new.method()
+			queue.mu.Unlock()
+			continue
+		}
+
+		var migrated []queuedEvent
+		queue.mu.Lock()
+		remaining := queue.events[:0]
+		for _, event := range queue.events {
+			if old.Matches(event.Event) && !m.matchesURL(old.URL, event.Event) {
+				migrated = append(migrated, event)
+			} else {
+				remaining = append(remaining, event)
+			}
+		}
+		queue.events = remaining
+		queue.mu.Unlock()
+
+		for _, event := range migrated {
+			m.enqueueEvent(new, event)
+		}
+	}
+}
+
+// matchesURL returns true if the event matches a registered webhook with the
+// given URL, the caller must hold the manager's lock.
+func (m *Manager) matchesURL(url string, event Event) bool {
+	for _, hook := range m.webhooks {
+		if hook.URL == url && hook.Matches(event) {
+			return true
+		}
+	}
+	return false
+}
+
+// sendSync delivers the event to the given webhook, if the number of concurrent
+// deliveries is limited it blocks until a slot is available.
+func (m *Manager) sendSync(ctx context.Context, hook Webhook, event Event) error {
+	if m.deliverySem != nil {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case m.deliverySem <- struct{}{}:
+		}
+		defer func() { <-m.deliverySem }()
+	}
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	return sendEvent(ctx, m.cfg, hook.method(), hook.URL, hook.Headers, event)
+}
+
+func (m *Manager) heartbeat() {
+	defer m.wg.Done()
+
+	t := time.NewTicker(m.heartbeatInterval)
+	defer t.Stop()
+
+	for {
+		m.BroadcastPing()
+		select {
+		case <-m.closedChan:
+			return
+		case <-t.C:
+		}
 	}
-	return nil
 }
 
 func (a Event) String() string {
 	return a.Module + "." + a.Event
 }
 
+// UnmarshalJSON implements json.Unmarshaler, events without a version are
+// decoded as version 1.
+func (a *Event) UnmarshalJSON(b []byte) error {
+	type event Event
+	var e event
+	if err := json.Unmarshal(b, &e); err != nil {
+		return err
+	} else if e.Version == 0 {
+		e.Version = 1
+	}
+	*a = Event(e)
+	return nil
+}
+
 func (q *eventQueue) dequeue() {
 	for {
 		q.mu.Lock()
-		if len(q.events) == 0 {
+		if q.isFlushing {
+			// a flush took over
 			q.isDequeueing = false
 			q.mu.Unlock()
 			return
+		} else if len(q.events) == 0 {
+			q.isDequeueing = false
+			q.mu.Unlock()
+			q.notifyDrained()
+			return
 		}
 		next := q.events[0]
+		next.attempts++
 		q.events = q.events[1:]
 		q.mu.Unlock()
 
-		err := sendEvent(q.ctx, q.url, q.headers, next)
-		if err != nil {
-			q.logger.Errorf("failed to send Webhook event %v to %v: %v", next.String(), q.url, err)
+		err := q.send(next.Event)
+		if errors.Is(err, ErrPayloadTooLarge) {
+			q.dropOversized(next.Event, err)
+		} else if err != nil {
+			fields := []interface{}{"url", q.url, "module", next.Module, "event", next.Event.Event, "attempt", next.attempts, zap.Error(err)}
+			var se *statusError
+			if errors.As(err, &se) {
+				fields = append(fields, "status_code", se.statusCode)
+			}
+			q.logger.Errorw("failed to send Webhook event", fields...)
+		}
+	}
+}
+
+// flush delivers the queued events until the queue is empty or a delivery
+// fails, the event that failed is put back at the front of the queue and the
+// queue is dequeued in the background again. If the queue is being dequeued,
+// flush waits for the in-flight delivery and takes over.
+func (q *eventQueue) flush() error {
+	q.flushMu.Lock()
+	defer q.flushMu.Unlock()
+
+	// take over from the dequeue goroutine
+	q.mu.Lock()
+	q.isFlushing = true
+	var dequeued chan struct{}
+	if q.isDequeueing {
+		dequeued = q.dequeued
+	}
+	q.mu.Unlock()
+	if dequeued != nil {
+		<-dequeued
+	}
+
+	for {
+		q.mu.Lock()
+		if len(q.events) == 0 {
+			q.isFlushing = false
+			q.mu.Unlock()
+			q.notifyDrained()
+			return nil
+		}
+		next := q.events[0]
+		next.attempts++
+		q.events = q.events[1:]
+		q.mu.Unlock()
+
+		err := q.send(next.Event)
+		if errors.Is(err, ErrPayloadTooLarge) {
+			q.dropOversized(next.Event, err)
+		} else if err != nil {
+			q.mu.Lock()
+			q.events = append([]queuedEvent{next}, q.events...)
+			q.isFlushing = false
+			q.startDequeueing()
+			q.mu.Unlock()
+			return fmt.Errorf("failed to send Webhook event %v to %v: %w", next.String(), q.url, err)
+		}
+	}
+}
+
+// startDequeueing launches the goroutine that dequeues the queue's events, the
+// caller must hold the queue's lock.
+func (q *eventQueue) startDequeueing() {
+	q.isDequeueing = true
+	dequeued := make(chan struct{})
+	q.dequeued = dequeued
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		defer close(dequeued)
+		q.dequeue()
+	}()
+}
+
+// notifyDrained notifies the observer, if any, that the queue was drained.
+func (q *eventQueue) notifyDrained() {
+	if q.observer != nil {
+		q.observer(LifecycleEvent{Type: LifecycleQueueDrained, URL: q.url, Module: q.module})
+	}
+}
+
+func (q *eventQueue) dropOversized(event Event, err error) {
+	q.logger.Warnw("dropping oversized Webhook event", "module", event.Module, "event", event.Event, "url", q.url, zap.Error(err))
+	q.mu.Lock()
+	q.droppedOversize++
+	q.mu.Unlock()
+}
+
+// send delivers the given event, if the number of concurrent deliveries is
+// limited it blocks until a slot is available.
+func (q *eventQueue) send(event Event) error {
+	if q.sem != nil {
+		select {
+		case <-q.ctx.Done():
+			return q.ctx.Err()
+		case q.sem <- struct{}{}:
 		}
+		defer func() { <-q.sem }()
 	}
+	q.mu.Lock()
+	headers, method := q.headers, q.method
+	q.mu.Unlock()
+	return sendEvent(q.ctx, q.cfg, method, q.url, headers, event)
+}
+
+// sortWebhooks sorts the webhooks by URL, module and event.
+func sortWebhooks(hooks []Webhook) {
+	sort.Slice(hooks, func(i, j int) bool {
+		if hooks[i].URL != hooks[j].URL {
+			return hooks[i].URL < hooks[j].URL
+		} else if hooks[i].Module != hooks[j].Module {
+			return hooks[i].Module < hooks[j].Module
+		}
+		return hooks[i].Event < hooks[j].Event
+	})
 }
 
 func (w Webhook) Matches(action Event) bool {
@@ -229,11 +1056,23 @@ func (w Webhook) Matches(action Event) bool {
 	return w.Event == "" || w.Event == action.Event
 }
 
+// method returns the HTTP method used to deliver events to the webhook.
+func (w Webhook) method() string {
+	if w.Method == "" {
+		return http.MethodPost
+	}
+	return w.Method
+}
+
 func (w Webhook) String() string {
 	return fmt.Sprintf("%v.%v.%v", w.URL, w.Module, w.Event)
 }
 
-func NewManager(store WebhookStore, logger *zap.Logger) (*Manager, error) {
+// NewManager creates a new webhook manager, the logger is optional.
+func NewManager(store WebhookStore, logger *zap.Logger, opts ...ManagerOption) (*Manager, error) {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
 	shutdownCtx, shutdownCtxCancel := context.WithCancel(context.Background())
 	m := &Manager{
 		logger: logger.Named("webhooks").Sugar(),
@@ -241,10 +1080,19 @@ func NewManager(store WebhookStore, logger *zap.Logger) (*Manager, error) {
 
 		shutdownCtx:       shutdownCtx,
 		shutdownCtxCancel: shutdownCtxCancel,
+		shutdownTimeout:   defaultShutdownTimeout,
+		closedChan:        make(chan struct{}),
+		shutdownDone:      make(chan struct{}),
 
 		queues:   make(map[string]*eventQueue),
 		webhooks: make(map[string]Webhook),
 	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.maxConcurrentDeliveries > 0 {
+		m.deliverySem = make(chan struct{}, m.maxConcurrentDeliveries)
+	}
 	hooks, err := store.Webhooks(shutdownCtx)
 	if err != nil {
 		return nil, err
@@ -252,34 +1100,125 @@ func NewManager(store WebhookStore, logger *zap.Logger) (*Manager, error) {
 	for _, hook := range hooks {
 		m.webhooks[hook.String()] = hook
 	}
+	if m.heartbeatInterval > 0 {
+		m.wg.Add(1)
+		go m.heartbeat()
+	}
 	return m, nil
 }
 
-func sendEvent(ctx context.Context, url string, headers map[string]string, action Event) error {
+// validateURL checks whether the given webhook URL is well-formed, uses http or
+// https and, unless allowInternal is set, doesn't point to an internal address.
+// Hostnames aren't resolved, only 'localhost' and literal IPs are checked.
+func validateURL(rawURL string, allowInternal bool) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidWebhookURL, err)
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: scheme must be http or https, got %q", ErrInvalidWebhookURL, u.Scheme)
+	} else if u.Hostname() == "" {
+		return fmt.Errorf("%w: missing host", ErrInvalidWebhookURL)
+	} else if allowInternal {
+		return nil
+	}
+
+	host := u.Hostname()
+	if strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".localhost") {
+		return fmt.Errorf("%w: internal host %q is not allowed", ErrInvalidWebhookURL, host)
+	} else if ip := net.ParseIP(host); ip != nil && (ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified()) {
+		return fmt.Errorf("%w: internal address %q is not allowed", ErrInvalidWebhookURL, host)
+	}
+	return nil
+}
+
+// hasAllowedPrefix returns true if the given URL starts with one of the given
+// prefixes, the URL's path is cleaned first so it can't escape a prefix using
+// '..' segments.
+func hasAllowedPrefix(rawURL string, prefixes []string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	u.Path = path.Clean("/" + u.Path)
+	u.RawPath = ""
+	cleaned := u.String()
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(cleaned, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateMethod checks whether the given webhook method is supported, an
+// empty method defaults to POST.
+func validateMethod(method string) error {
+	switch method {
+	case "", http.MethodPost, http.MethodPut:
+		return nil
+	default:
+		return fmt.Errorf("%w: method must be POST or PUT, got %q", ErrInvalidWebhookMethod, method)
+	}
+}
+
+// newEventID returns a random ID for an event.
+func newEventID() string {
+	return hex.EncodeToString(frand.Bytes(16))
+}
+
+func sendEvent(ctx context.Context, cfg deliveryConfig, method, url string, headers map[string]string, action Event) error {
+	if action.Version == 0 {
+		action.Version = EventVersion
+	}
 	body, err := json.Marshal(action)
 	if err != nil {
 		return err
+	} else if cfg.maxPayloadSize > 0 && len(body) > cfg.maxPayloadSize {
+		return fmt.Errorf("%w: %d > %d bytes", ErrPayloadTooLarge, len(body), cfg.maxPayloadSize)
+	}
+
+	// compress the body if necessary
+	compressed := cfg.gzip && len(body) > cfg.gzipThreshold
+	if compressed {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		if _, err := gw.Write(body); err != nil {
+			return fmt.Errorf("failed to compress body: %w", err)
+		} else if err := gw.Close(); err != nil {
+			return fmt.Errorf("failed to compress body: %w", err)
+		}
+		body = buf.Bytes()
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
 	if err != nil {
 		return err
 	}
 	for k, v := range headers {
 		req.Header.Set(k, v)
 	}
-	defer io.ReadAll(req.Body) // always drain body
+	req.Header.Set(HeaderEventVersion, fmt.Sprint(action.Version))
+	if action.ID != "" {
+		req.Header.Set(HeaderEventID, action.ID)
+	}
+	if compressed {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, maxErrorBodySize)) // drain body to reuse the connection
+		resp.Body.Close()
+	}()
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
-		errStr, err := io.ReadAll(req.Body)
+		errStr, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
 		if err != nil {
 			return fmt.Errorf("failed to read response body: %w", err)
 		}
-		return fmt.Errorf("Webhook returned unexpected status %v: %v", resp.StatusCode, string(errStr))
+		return &statusError{statusCode: resp.StatusCode, body: string(errStr)}
 	}
 	return nil
 }
@@ -0,0 +1,1349 @@
+package webhooks
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type memoryWebhookStore struct{}
+
+func (memoryWebhookStore) AddWebhook(context.Context, Webhook) error    { return nil }
+func (memoryWebhookStore) DeleteWebhook(context.Context, Webhook) error { return nil }
+func (memoryWebhookStore) Webhooks(context.Context) ([]Webhook, error)  { return nil, nil }
+
+func TestManagerNilLogger(t *testing.T) {
+	mgr, err := NewManager(memoryWebhookStore{}, nil, WithAllowInternalURLs(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Shutdown(context.Background())
+
+	// the server accepts the registration ping and fails all other requests
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) > 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	if err := mgr.Register(context.Background(), Webhook{Module: "foo", URL: srv.URL}); err != nil {
+		t.Fatal(err)
+	}
+
+	// broadcast an event, the failed delivery is logged without panicking
+	if err := mgr.BroadcastAction(context.Background(), Event{Module: "foo", Event: "bar"}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if _, queues := mgr.Info(); requests.Load() == 2 && len(queues) == 1 && queues[0].Size == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("event wasn't delivered")
+}
+
+func TestManagerPendingEvents(t *testing.T) {
+	mgr, err := NewManager(memoryWebhookStore{}, nil, WithAllowInternalURLs(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Shutdown(context.Background())
+
+	// the server accepts the registration ping and blocks on all other
+	// requests until it's unblocked
+	var requests atomic.Int64
+	received, unblock := make(chan struct{}, 1), make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) > 1 {
+			select {
+			case received <- struct{}{}:
+			default:
+			}
+			<-unblock
+		}
+	}))
+	defer srv.Close()
+	defer close(unblock)
+
+	if err := mgr.Register(context.Background(), Webhook{Module: "foo", URL: srv.URL}); err != nil {
+		t.Fatal(err)
+	}
+
+	// broadcast an event and wait for it to be in flight
+	broadcast := func(event string) {
+		t.Helper()
+		if err := mgr.BroadcastAction(context.Background(), Event{Module: "foo", Event: event}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	broadcast("1")
+	select {
+	case <-received:
+	case <-time.After(10 * time.Second):
+		t.Fatal("event wasn't received")
+	}
+
+	// the events broadcasted after it are pending
+	broadcast("2")
+	broadcast("3")
+	pending := mgr.PendingEvents(srv.URL)
+	if len(pending) != 2 || pending[0].Event != "2" || pending[1].Event != "3" {
+		t.Fatalf("unexpected pending events %+v", pending)
+	}
+
+	// modifying the snapshot doesn't affect the queue
+	pending[0].Event = "foo"
+	if pending := mgr.PendingEvents(srv.URL); pending[0].Event != "2" {
+		t.Fatal("snapshot shares memory with the queue")
+	}
+
+	// unknown URLs have no pending events
+	if pending := mgr.PendingEvents("http://unknown"); len(pending) != 0 {
+		t.Fatal("unexpected pending events", pending)
+	}
+}
+
+func TestManagerWebhookMethod(t *testing.T) {
+	mgr, err := NewManager(memoryWebhookStore{}, nil, WithAllowInternalURLs(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Shutdown(context.Background())
+
+	// the server records the method of every request
+	methods := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods <- r.Method
+	}))
+	defer srv.Close()
+
+	assertMethod := func(expected string) {
+		t.Helper()
+		select {
+		case method := <-methods:
+			if method != expected {
+				t.Fatalf("expected method %v, got %v", expected, method)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("no request received")
+		}
+	}
+
+	// unsupported methods are rejected without pinging the webhook
+	if err := mgr.Register(context.Background(), Webhook{Module: "foo", URL: srv.URL, Method: http.MethodGet}); !errors.Is(err, ErrInvalidWebhookMethod) {
+		t.Fatal("unexpected error", err)
+	} else if len(methods) != 0 {
+		t.Fatal("webhook was pinged")
+	}
+
+	// webhooks without a method default to POST
+	if err := mgr.Register(context.Background(), Webhook{Module: "foo", URL: srv.URL}); err != nil {
+		t.Fatal(err)
+	}
+	assertMethod(http.MethodPost)
+	if hooks := mgr.Webhooks(""); len(hooks) != 1 || hooks[0].Method != http.MethodPost {
+		t.Fatalf("unexpected webhooks %+v", hooks)
+	}
+
+	// both the ping and the events use the webhook's method
+	if err := mgr.Register(context.Background(), Webhook{Module: "bar", URL: srv.URL + "/put", Method: http.MethodPut}); err != nil {
+		t.Fatal(err)
+	}
+	assertMethod(http.MethodPut)
+	if err := mgr.BroadcastAction(context.Background(), Event{Module: "bar"}); err != nil {
+		t.Fatal(err)
+	}
+	assertMethod(http.MethodPut)
+}
+
+func TestManagerObserver(t *testing.T) {
+	events := make(chan LifecycleEvent, 10)
+	mgr, err := NewManager(memoryWebhookStore{}, nil, WithAllowInternalURLs(true), WithObserver(func(e LifecycleEvent) {
+		events <- e
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Shutdown(context.Background())
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	assertEvent := func(typ LifecycleEventType) LifecycleEvent {
+		t.Helper()
+		select {
+		case e := <-events:
+			if e.Type != typ {
+				t.Fatalf("expected event %v, got %v", typ, e.Type)
+			} else if e.URL != srv.URL {
+				t.Fatalf("unexpected URL %v", e.URL)
+			}
+			return e
+		case <-time.After(10 * time.Second):
+			t.Fatalf("no %v event", typ)
+		}
+		return LifecycleEvent{}
+	}
+
+	// registering a webhook is observed without its headers
+	wh := Webhook{Module: "foo", URL: srv.URL, Headers: map[string]string{"Authorization": "secret"}}
+	if err := mgr.Register(context.Background(), wh); err != nil {
+		t.Fatal(err)
+	} else if e := assertEvent(LifecycleWebhookRegistered); e.Webhook.String() != wh.String() || e.Webhook.Headers != nil {
+		t.Fatalf("unexpected webhook %+v", e.Webhook)
+	}
+
+	// the first event creates a queue which is drained once it's delivered
+	if err := mgr.BroadcastAction(context.Background(), Event{Module: "foo"}); err != nil {
+		t.Fatal(err)
+	}
+	assertEvent(LifecycleQueueCreated)
+	assertEvent(LifecycleQueueDrained)
+
+	// the queue is reused for the next event
+	if err := mgr.BroadcastAction(context.Background(), Event{Module: "foo"}); err != nil {
+		t.Fatal(err)
+	}
+	assertEvent(LifecycleQueueDrained)
+
+	// deleting the webhook is observed
+	if err := mgr.Delete(context.Background(), wh); err != nil {
+		t.Fatal(err)
+	} else if e := assertEvent(LifecycleWebhookDeleted); e.Webhook.String() != wh.String() {
+		t.Fatalf("unexpected webhook %+v", e.Webhook)
+	}
+	if len(events) != 0 {
+		t.Fatal("unexpected events", len(events))
+	}
+}
+
+func TestManagerFlush(t *testing.T) {
+	mgr, err := NewManager(memoryWebhookStore{}, nil, WithAllowInternalURLs(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Shutdown(context.Background())
+
+	// the server blocks on event '1' until it's unblocked and fails the first
+	// delivery of event '2'
+	var mu sync.Mutex
+	var delivered []string
+	var failed bool
+	received, unblock := make(chan struct{}, 1), make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event Event
+		json.NewDecoder(r.Body).Decode(&event)
+		switch event.Event {
+		case WebhookEventPing:
+			return
+		case "1":
+			received <- struct{}{}
+			<-unblock
+		case "2":
+			mu.Lock()
+			fail := !failed
+			failed = true
+			mu.Unlock()
+			if fail {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+		mu.Lock()
+		delivered = append(delivered, event.Event)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	if err := mgr.Register(context.Background(), Webhook{Module: "foo", URL: srv.URL}); err != nil {
+		t.Fatal(err)
+	}
+
+	// unknown URLs have no queue
+	if err := mgr.Flush("http://unknown"); !errors.Is(err, ErrQueueNotFound) {
+		t.Fatal("unexpected error", err)
+	}
+
+	// broadcast an event and wait for it to be in flight, then broadcast two
+	// more
+	for _, event := range []string{"1", "2", "3"} {
+		if err := mgr.BroadcastAction(context.Background(), Event{Module: "foo", Event: event}); err != nil {
+			t.Fatal(err)
+		} else if event == "1" {
+			<-received
+		}
+	}
+
+	// flush the queue, it should wait for the in-flight delivery
+	flushed := make(chan error, 1)
+	go func() { flushed <- mgr.Flush(srv.URL) }()
+	for {
+		mgr.mu.Lock()
+		queue := mgr.queues[srv.URL]
+		mgr.mu.Unlock()
+		queue.mu.Lock()
+		isFlushing := queue.isFlushing
+		queue.mu.Unlock()
+		if isFlushing {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case err := <-flushed:
+		t.Fatal("flush returned early", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// unblock the receiver, the flush should fail on event '2'
+	close(unblock)
+	select {
+	case err := <-flushed:
+		if err == nil || !strings.Contains(err.Error(), "foo.2") {
+			t.Fatal("unexpected error", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("flush didn't return")
+	}
+
+	// the queue should be dequeued in the background again
+	for i := 0; i < 100; i++ {
+		mu.Lock()
+		done := len(delivered) == 3
+		mu.Unlock()
+		if done {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(delivered, []string{"1", "2", "3"}) {
+		t.Fatal("unexpected deliveries", delivered)
+	}
+}
+
+func TestValidateURL(t *testing.T) {
+	prefixes := []string{"http://127.0.0.1:9980/api/worker/"}
+	tests := []struct {
+		url           string
+		allowInternal bool
+		valid         bool
+	}{
+		{"http://foo.bar/events", false, true},
+		{"https://foo.bar:8080/events", false, true},
+		{"http://10.0.0.1/events", false, true},
+		{"ftp://foo.bar/events", false, false},
+		{"foo.bar/events", false, false},
+		{"http:///events", false, false},
+		{"http://localhost/events", false, false},
+		{"http://LOCALHOST/events", false, false},
+		{"http://foo.localhost/events", false, false},
+		{"http://127.0.0.1/events", false, false},
+		{"http://[::1]/events", false, false},
+		{"http://169.254.169.254/latest/meta-data", false, false},
+		{"http://0.0.0.0/events", false, false},
+		{"http://[::]/events", false, false},
+		{"http://localhost/events", true, true},
+		{"http://169.254.169.254/latest/meta-data", true, true},
+		{"ftp://localhost/events", true, false},
+		{"http://127.0.0.1:9980/api/worker/event", false, true},
+		{"http://127.0.0.1:9980/api/worker/../bus/wallet", false, false},
+		{"http://127.0.0.1:9980/api/bus/wallet", false, false},
+		{"http://127.0.0.1:9981/api/worker/event", false, false},
+	}
+	for _, test := range tests {
+		err := validateURL(test.url, test.allowInternal || hasAllowedPrefix(test.url, prefixes))
+		if test.valid && err != nil {
+			t.Errorf("%v: unexpected error %v", test.url, err)
+		} else if !test.valid && !errors.Is(err, ErrInvalidWebhookURL) {
+			t.Errorf("%v: expected ErrInvalidWebhookURL, got %v", test.url, err)
+		}
+	}
+}
+
+func TestManagerUpdateWebhook(t *testing.T) {
+	store := newTestWebhookStore()
+	mgr := newTestManager(t, store)
+	r1, r2 := newTestReceiver(t), newTestReceiver(t)
+
+	old := Webhook{Module: "foo", URL: r1.URL}
+	other := Webhook{Module: "foo", Event: "2", URL: r1.URL}
+	for _, wh := range []Webhook{old, other} {
+		if err := mgr.Register(context.Background(), wh); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// unknown webhooks can't be updated
+	if err := mgr.UpdateWebhook(context.Background(), Webhook{Module: "bar", URL: r1.URL}, old); !errors.Is(err, ErrWebhookNotFound) {
+		t.Fatal("unexpected error", err)
+	}
+
+	// block the old URL and queue some events behind the blocked one
+	broadcast(t, mgr, Event{Module: "foo", Event: "block"})
+	r1.waitInFlight(t)
+	broadcast(t, mgr, Event{Module: "foo", Event: "2"})
+	broadcast(t, mgr, Event{Module: "foo", Event: "3"})
+
+	// define a helper to assert the stored webhooks
+	assertStored := func(hooks ...Webhook) {
+		t.Helper()
+		var expected []string
+		for _, wh := range hooks {
+			expected = append(expected, wh.String())
+		}
+		sort.Strings(expected)
+		if stored := store.hooks(); !reflect.DeepEqual(stored, expected) {
+			t.Fatalf("expected stored webhooks %v, got %v", expected, stored)
+		}
+	}
+
+	// if the old webhook can't be deleted the update is rolled back
+	new := Webhook{Module: "foo", URL: r2.URL}
+	store.failDeletes(old, errors.New("database is locked"))
+	if err := mgr.UpdateWebhook(context.Background(), old, new); err == nil {
+		t.Fatal("expected error")
+	} else if hooks := mgr.Webhooks(""); len(hooks) != 2 || hooks[0].URL != r1.URL || hooks[1].URL != r1.URL {
+		t.Fatal("unexpected webhooks", hooks)
+	}
+	assertStored(old, other)
+
+	// swap the webhooks, the event that is also queued for the other webhook
+	// stays in the old queue, the other one is migrated
+	store.failDeletes(old, nil)
+	if err := mgr.UpdateWebhook(context.Background(), old, new); err != nil {
+		t.Fatal(err)
+	}
+	assertStored(other, new)
+	r2.waitForEvents(t, "3")
+	if pending := mgr.PendingEvents(r1.URL); len(pending) != 2 || pending[0].Event != "2" || pending[1].Event != "2" {
+		t.Fatalf("unexpected pending events %+v", pending)
+	}
+
+	// update the other webhook's headers, the URL is unchanged so its queue
+	// delivers the remaining events using the new headers
+	updated := other
+	updated.Headers = map[string]string{"X-Foo": "bar"}
+	if err := mgr.UpdateWebhook(context.Background(), other, updated); err != nil {
+		t.Fatal(err)
+	}
+	r1.unblockAll()
+	r1.waitForEvents(t, "block", "2", "2")
+	for _, e := range r1.received()[1:] {
+		if e.header.Get("X-Foo") != "bar" {
+			t.Fatal("event was delivered with the old headers", e.header)
+		}
+	}
+
+	// a webhook that is deleted while its replacement is pinged isn't
+	// brought back by the update
+	deleted := Webhook{Module: "bar", URL: r1.URL}
+	if err := mgr.Register(context.Background(), deleted); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := mgr.Delete(context.Background(), deleted); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+	if err := mgr.UpdateWebhook(context.Background(), deleted, Webhook{Module: "bar", URL: srv.URL}); !errors.Is(err, ErrWebhookNotFound) {
+		t.Fatal("unexpected error", err)
+	} else if hooks := mgr.Webhooks("bar"); len(hooks) != 0 {
+		t.Fatal("unexpected webhooks", hooks)
+	}
+	assertStored(new, updated)
+}
+
+// testWebhookStore is an in-memory WebhookStore that can be configured to
+// fail deleting specific webhooks.
+func TestManagerShutdownDrainsQueues(t *testing.T) {
+	store := newTestWebhookStore()
+	mgr := newTestManager(t, store)
+	r := newTestReceiver(t)
+	if err := mgr.Register(context.Background(), Webhook{Module: "foo", URL: r.URL}); err != nil {
+		t.Fatal(err)
+	}
+
+	// broadcast a blocking event and queue two more behind it
+	broadcast(t, mgr, Event{Module: "foo", Event: "block"})
+	r.waitInFlight(t)
+	broadcast(t, mgr, Event{Module: "foo", Event: "1"})
+	broadcast(t, mgr, Event{Module: "foo", Event: "2"})
+
+	// shut down twice, neither call should return while the queue is being
+	// drained
+	done := make(chan error, 2)
+	go func() { done <- mgr.Shutdown(context.Background()) }()
+	for {
+		mgr.mu.Lock()
+		closed := mgr.closed
+		mgr.mu.Unlock()
+		if closed {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	go func() { done <- mgr.Shutdown(context.Background()) }()
+	select {
+	case err := <-done:
+		t.Fatal("shutdown returned early", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// unblock the receiver, both calls should return after all queued events
+	// were delivered
+	r.unblockAll()
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			} else if got := len(r.received()); got != 3 {
+				t.Fatalf("expected 3 events to be delivered before shutdown returned, got %v", got)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("shutdown didn't return")
+		}
+	}
+	r.waitForEvents(t, "block", "1", "2")
+}
+
+func TestManagerShutdownTimeout(t *testing.T) {
+	// an observer that blocks once the queue was drained keeps the dequeue
+	// goroutine from returning, just like a delivery that ignores the
+	// cancellation would
+	hang := make(chan struct{})
+	defer close(hang)
+	observer := func(e LifecycleEvent) {
+		if e.Type == LifecycleQueueDrained {
+			<-hang
+		}
+	}
+	mgr := newTestManager(t, newTestWebhookStore(), WithShutdownTimeout(50*time.Millisecond), WithObserver(observer))
+	r := newTestReceiver(t)
+	if err := mgr.Register(context.Background(), Webhook{Module: "foo", URL: r.URL}); err != nil {
+		t.Fatal(err)
+	}
+	broadcast(t, mgr, Event{Module: "foo", Event: "block"})
+	r.waitInFlight(t)
+
+	// shutdown should give up waiting for the hung queue
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := mgr.Shutdown(ctx); !errors.Is(err, ErrShutdownTimeout) {
+		t.Fatal("unexpected error", err)
+	} else if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected the context's error to be wrapped", err)
+	}
+
+	// calling it again returns the same error
+	if err := mgr.Shutdown(context.Background()); !errors.Is(err, ErrShutdownTimeout) {
+		t.Fatal("unexpected error", err)
+	}
+
+	// the manager no longer accepts events
+	event := Event{Module: "foo", Event: "bar"}
+	if err := mgr.BroadcastAction(context.Background(), event); !errors.Is(err, ErrShuttingDown) {
+		t.Fatal("unexpected error", err)
+	} else if err := mgr.BroadcastActionSync(context.Background(), event); !errors.Is(err, ErrShuttingDown) {
+		t.Fatal("unexpected error", err)
+	} else if err := mgr.Flush(r.URL); !errors.Is(err, ErrShuttingDown) {
+		t.Fatal("unexpected error", err)
+	}
+}
+
+func TestManagerMaxPayloadSize(t *testing.T) {
+	mgr := newTestManager(t, newTestWebhookStore(), WithMaxPayloadSize(200))
+	r := newTestReceiver(t)
+	if err := mgr.Register(context.Background(), Webhook{Module: "foo", URL: r.URL}); err != nil {
+		t.Fatal(err)
+	}
+
+	// broadcast an oversized event between two small ones
+	broadcast(t, mgr, Event{Module: "foo", Event: "1"})
+	broadcast(t, mgr, Event{Module: "foo", Event: "big", Payload: strings.Repeat("a", 200)})
+	broadcast(t, mgr, Event{Module: "foo", Event: "2"})
+
+	// only the small events should be delivered
+	r.waitForEvents(t, "1", "2")
+
+	// the dropped event should be counted
+	_, queues := mgr.Info()
+	if len(queues) != 1 {
+		t.Fatal("expected 1 queue, got", len(queues))
+	} else if queues[0].DroppedOversize != 1 {
+		t.Fatal("expected 1 dropped event, got", queues[0].DroppedOversize)
+	}
+
+	// synchronous deliveries return the error instead
+	err := mgr.BroadcastActionSync(context.Background(), Event{Module: "foo", Event: "big", Payload: strings.Repeat("a", 200)})
+	if !errors.Is(err, ErrPayloadTooLarge) {
+		t.Fatal("unexpected error", err)
+	}
+}
+
+func TestManagerGzip(t *testing.T) {
+	const threshold = 300
+	mgr := newTestManager(t, newTestWebhookStore(), WithGzip(threshold))
+
+	// receivers that don't support compression are rejected when registering
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+		}
+	}))
+	defer plain.Close()
+	if err := mgr.Register(context.Background(), Webhook{Module: "foo", URL: plain.URL}); err == nil {
+		t.Fatal("expected registration to fail")
+	}
+
+	// the ping is always compressed, even though it's below the threshold
+	r := newTestReceiver(t)
+	if err := mgr.Register(context.Background(), Webhook{Module: "foo", URL: r.URL}); err != nil {
+		t.Fatal(err)
+	}
+	pings := r.pings()
+	if len(pings) != 1 {
+		t.Fatal("expected 1 ping, got", len(pings))
+	} else if enc := pings[0].header.Get("Content-Encoding"); enc != "gzip" {
+		t.Fatalf("expected ping to be compressed, got encoding %q", enc)
+	}
+
+	// only events that exceed the threshold are compressed
+	small := Event{Module: "foo", Event: "small"}
+	big := Event{Module: "foo", Event: "big", Payload: strings.Repeat("a", threshold)}
+	if b, _ := json.Marshal(small); len(b) > threshold {
+		t.Fatal("small event exceeds the threshold", len(b))
+	}
+	broadcast(t, mgr, small)
+	broadcast(t, mgr, big)
+	r.waitForEvents(t, "small", "big")
+
+	received := r.received()
+	if enc := received[0].header.Get("Content-Encoding"); enc != "" {
+		t.Fatalf("expected small event to be uncompressed, got encoding %q", enc)
+	} else if enc := received[1].header.Get("Content-Encoding"); enc != "gzip" {
+		t.Fatalf("expected big event to be compressed, got encoding %q", enc)
+	} else if received[1].Payload != big.Payload {
+		t.Fatal("payload mismatch after decompressing", received[1].Payload)
+	}
+}
+
+func TestManagerMaxConcurrentDeliveries(t *testing.T) {
+	mgr := newTestManager(t, newTestWebhookStore(), WithMaxConcurrentDeliveries(1))
+	r1, r2 := newTestReceiver(t), newTestReceiver(t)
+	if err := mgr.Register(context.Background(), Webhook{Module: "foo", URL: r1.URL}); err != nil {
+		t.Fatal(err)
+	} else if err := mgr.Register(context.Background(), Webhook{Module: "bar", URL: r2.URL}); err != nil {
+		t.Fatal(err)
+	}
+
+	// block the only delivery slot with an event for the first URL
+	broadcast(t, mgr, Event{Module: "foo", Event: "block"})
+	r1.waitInFlight(t)
+	for _, event := range []string{"1", "2", "3"} {
+		broadcast(t, mgr, Event{Module: "foo", Event: event})
+		broadcast(t, mgr, Event{Module: "bar", Event: event})
+	}
+
+	// the second URL has its own queue but shouldn't receive anything until
+	// the slot is released
+	time.Sleep(100 * time.Millisecond)
+	if received := r2.received(); len(received) != 0 {
+		t.Fatal("expected no deliveries while the limit is reached, got", len(received))
+	}
+
+	// unblock the receiver, both URLs should receive their events in order
+	r1.unblockAll()
+	r1.waitForEvents(t, "block", "1", "2", "3")
+	r2.waitForEvents(t, "1", "2", "3")
+}
+
+func TestManagerReplayBuffer(t *testing.T) {
+	mgr := newTestManager(t, newTestWebhookStore(), WithReplayBuffer(3, time.Hour))
+
+	// broadcast more events than the buffer holds, the oldest one is evicted
+	for _, event := range []string{"1", "2", "3", "4"} {
+		broadcast(t, mgr, Event{Module: "foo", Event: event})
+	}
+	broadcast(t, mgr, Event{Module: "bar", Event: "5"})
+
+	// make the oldest buffered event exceed the max age
+	mgr.mu.Lock()
+	mgr.replay[0].enqueuedAt = time.Now().Add(-2 * time.Hour)
+	mgr.mu.Unlock()
+
+	// register a webhook, only the matching events that were neither evicted
+	// nor expired are replayed
+	r := newTestReceiver(t)
+	wh := Webhook{Module: "foo", URL: r.URL}
+	if err := mgr.Register(context.Background(), wh); err != nil {
+		t.Fatal(err)
+	}
+	r.waitForEvents(t, "4")
+
+	// registering the same webhook again doesn't replay the events again
+	if err := mgr.Register(context.Background(), wh); err != nil {
+		t.Fatal(err)
+	}
+	broadcast(t, mgr, Event{Module: "foo", Event: "6"})
+	r.waitForEvents(t, "4", "6")
+}
+
+func TestManagerQueuePerModule(t *testing.T) {
+	mgr := newTestManager(t, newTestWebhookStore(), WithQueuePerModule(true))
+	r := newTestReceiver(t)
+	for _, module := range []string{"foo", "bar"} {
+		if err := mgr.Register(context.Background(), Webhook{Module: module, URL: r.URL}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// block the receiver on an event of the first module, the events of the
+	// second module should still be delivered
+	broadcast(t, mgr, Event{Module: "foo", Event: "block"})
+	r.waitInFlight(t)
+	broadcast(t, mgr, Event{Module: "foo", Event: "1"})
+	broadcast(t, mgr, Event{Module: "bar", Event: "2"})
+	r.waitForEvents(t, "2")
+
+	// both queues should be listed separately
+	_, queues := mgr.Info()
+	sort.Slice(queues, func(i, j int) bool { return queues[i].Module < queues[j].Module })
+	if len(queues) != 2 {
+		t.Fatal("expected 2 queues, got", len(queues))
+	} else if queues[0].URL != r.URL || queues[0].Module != "bar" || queues[0].Size != 0 {
+		t.Fatalf("unexpected queue %+v", queues[0])
+	} else if queues[1].URL != r.URL || queues[1].Module != "foo" || queues[1].Size != 1 {
+		t.Fatalf("unexpected queue %+v", queues[1])
+	}
+
+	// unblock the receiver, the first module's events are delivered in order
+	r.unblockAll()
+	r.waitForEvents(t, "2", "block", "1")
+}
+
+func TestManagerDeliveryFailureLogging(t *testing.T) {
+	core, logs := observer.New(zap.ErrorLevel)
+	mgr, err := NewManager(newTestWebhookStore(), zap.New(core), WithAllowInternalURLs(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Shutdown(context.Background())
+	r := newTestReceiver(t)
+	if err := mgr.Register(context.Background(), Webhook{Module: "foo", URL: r.URL}); err != nil {
+		t.Fatal(err)
+	}
+
+	// queue an event that fails behind a blocking one and flush the queue, the
+	// flush attempts the delivery first and the queue retries it
+	broadcast(t, mgr, Event{Module: "foo", Event: "block"})
+	r.waitInFlight(t)
+	broadcast(t, mgr, Event{Module: "foo", Event: "fail"})
+	flushed := make(chan error, 1)
+	go func() { flushed <- mgr.Flush(r.URL) }()
+	for {
+		mgr.mu.Lock()
+		queue := mgr.queues[r.URL]
+		mgr.mu.Unlock()
+		queue.mu.Lock()
+		isFlushing := queue.isFlushing
+		queue.mu.Unlock()
+		if isFlushing {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	r.unblockAll()
+	if err := <-flushed; err == nil {
+		t.Fatal("expected flush to fail")
+	}
+
+	// the retry's failure should be logged with structured fields
+	var entries []observer.LoggedEntry
+	for i := 0; i < 200; i++ {
+		if entries = logs.FilterMessage("failed to send Webhook event").AllUntimed(); len(entries) > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(entries) != 1 {
+		t.Fatal("expected 1 log entry, got", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	for key, want := range map[string]interface{}{
+		"url":         r.URL,
+		"module":      "foo",
+		"event":       "fail",
+		"attempt":     int64(2),
+		"status_code": int64(http.StatusServiceUnavailable),
+	} {
+		if got := fields[key]; got != want {
+			t.Errorf("expected field %q to be %v (%T), got %v (%T)", key, want, want, got, got)
+		}
+	}
+	if errStr, _ := fields["error"].(string); !strings.Contains(errStr, "unavailable") {
+		t.Errorf("expected error to contain the response body, got %q", errStr)
+	}
+}
+
+func TestManagerPingEventID(t *testing.T) {
+	mgr := newTestManager(t, newTestWebhookStore())
+	r1, r2 := newTestReceiver(t), newTestReceiver(t)
+	for _, r := range []*testReceiver{r1, r2} {
+		if err := mgr.Register(context.Background(), Webhook{Module: "foo", URL: r.URL}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// broadcast two pings, every URL receives them in addition to the ping
+	// that was sent when registering
+	mgr.BroadcastPing()
+	mgr.BroadcastPing()
+	waitForPings := func(r *testReceiver) (ids []string) {
+		t.Helper()
+		for i := 0; i < 200 && len(r.pings()) < 3; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		pings := r.pings()
+		if len(pings) != 3 {
+			t.Fatal("expected 3 pings, got", len(pings))
+		}
+		for _, ping := range pings {
+			id := ping.header.Get(HeaderEventID)
+			if id == "" {
+				t.Fatal("ping is missing the event ID header")
+			} else if id != ping.ID {
+				t.Fatalf("header %q doesn't match the event's ID %q", id, ping.ID)
+			}
+			ids = append(ids, id)
+		}
+		return
+	}
+	ids1, ids2 := waitForPings(r1), waitForPings(r2)
+
+	// every ping gets a new ID, but the same ping shares its ID across URLs
+	if ids1[1] == ids1[2] {
+		t.Fatal("expected distinct pings to have distinct IDs")
+	} else if !reflect.DeepEqual(ids1[1:], ids2[1:]) {
+		t.Fatal("expected the same ping to have the same ID on every URL", ids1, ids2)
+	}
+}
+
+func TestManagerBroadcastPing(t *testing.T) {
+	mgr := newTestManager(t, newTestWebhookStore())
+	r1, r2 := newTestReceiver(t), newTestReceiver(t)
+	for _, wh := range []Webhook{
+		{Module: "foo", URL: r1.URL},
+		{Module: "bar", URL: r1.URL},
+		{Module: "foo", URL: r2.URL},
+	} {
+		if err := mgr.Register(context.Background(), wh); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pings1, pings2 := len(r1.pings()), len(r2.pings())
+
+	// every URL should receive a single ping
+	mgr.BroadcastPing()
+	mgr.Flush(r1.URL)
+	mgr.Flush(r2.URL)
+	if n := len(r1.pings()) - pings1; n != 1 {
+		t.Fatal("expected 1 ping, got", n)
+	} else if n := len(r2.pings()) - pings2; n != 1 {
+		t.Fatal("expected 1 ping, got", n)
+	}
+}
+
+func TestManagerHeartbeat(t *testing.T) {
+	mgr, err := NewManager(newTestWebhookStore(), nil, WithAllowInternalURLs(true), WithHeartbeat(20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newTestReceiver(t)
+	if err := mgr.Register(context.Background(), Webhook{Module: "foo", URL: r.URL}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the receiver should be pinged periodically
+	for i := 0; i < 200 && len(r.pings()) < 4; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := len(r.pings()); n < 4 {
+		t.Fatal("expected at least 4 pings, got", n)
+	}
+
+	// the heartbeat stops on shutdown
+	if err := mgr.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	n := len(r.pings())
+	time.Sleep(100 * time.Millisecond)
+	if len(r.pings()) != n {
+		t.Fatal("received pings after shutdown")
+	}
+}
+
+func TestManagerBroadcastActionSync(t *testing.T) {
+	mgr := newTestManager(t, newTestWebhookStore())
+
+	// register a receiver and two webhooks that reject all events but pings
+	r := newTestReceiver(t)
+	if err := mgr.Register(context.Background(), Webhook{Module: "foo", URL: r.URL}); err != nil {
+		t.Fatal(err)
+	}
+	var failing []string
+	for i := 0; i < 2; i++ {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var event Event
+			json.NewDecoder(r.Body).Decode(&event)
+			if event.Event != WebhookEventPing {
+				http.Error(w, "nope", http.StatusInternalServerError)
+			}
+		}))
+		defer srv.Close()
+		if err := mgr.Register(context.Background(), Webhook{Module: "foo", URL: srv.URL}); err != nil {
+			t.Fatal(err)
+		}
+		failing = append(failing, srv.URL)
+	}
+
+	// the event is delivered before BroadcastActionSync returns and the
+	// failed deliveries are all returned
+	err := mgr.BroadcastActionSync(context.Background(), Event{Module: "foo", Event: "bar"})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, url := range failing {
+		if !strings.Contains(err.Error(), url) {
+			t.Fatalf("expected error to contain %v, got %v", url, err)
+		}
+	}
+	var se *statusError
+	if !errors.As(err, &se) || se.statusCode != http.StatusInternalServerError {
+		t.Fatal("expected a status error", err)
+	} else if received := r.received(); len(received) != 1 || received[0].Event.Event != "bar" {
+		t.Fatal("expected the event to be delivered", received)
+	}
+
+	// events without matching webhooks succeed
+	if err := mgr.BroadcastActionSync(context.Background(), Event{Module: "baz", Event: "bar"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestManagerOldestEventAge(t *testing.T) {
+	mgr := newTestManager(t, newTestWebhookStore())
+	r := newTestReceiver(t)
+	if err := mgr.Register(context.Background(), Webhook{Module: "foo", URL: r.URL}); err != nil {
+		t.Fatal(err)
+	}
+	oldestEventAge := func() time.Duration {
+		t.Helper()
+		_, queues := mgr.Info()
+		if len(queues) != 1 {
+			t.Fatal("expected 1 queue, got", len(queues))
+		}
+		return queues[0].OldestEventAge
+	}
+
+	// queue two events behind a blocking one and backdate the first one
+	broadcast(t, mgr, Event{Module: "foo", Event: "block"})
+	r.waitInFlight(t)
+	broadcast(t, mgr, Event{Module: "foo", Event: "1"})
+	broadcast(t, mgr, Event{Module: "foo", Event: "2"})
+	mgr.mu.Lock()
+	queue := mgr.queues[r.URL]
+	mgr.mu.Unlock()
+	queue.mu.Lock()
+	queue.events[0].enqueuedAt = time.Now().Add(-time.Hour)
+	queue.mu.Unlock()
+
+	// the age of the oldest queued event is reported
+	if age := oldestEventAge(); age < time.Hour || age > 2*time.Hour {
+		t.Fatal("unexpected age", age)
+	}
+
+	// once the queue is drained the age is 0
+	r.unblockAll()
+	r.waitForEvents(t, "block", "1", "2")
+	if age := oldestEventAge(); age != 0 {
+		t.Fatal("unexpected age", age)
+	}
+}
+
+func TestManagerTest(t *testing.T) {
+	store := newTestWebhookStore()
+	mgr := newTestManager(t, store)
+	r := newTestReceiver(t)
+
+	// testing a webhook pings it without registering it
+	if err := mgr.Test(context.Background(), Webhook{Module: "foo", URL: r.URL}); err != nil {
+		t.Fatal(err)
+	} else if pings := r.pings(); len(pings) != 1 {
+		t.Fatal("expected 1 ping, got", len(pings))
+	} else if pings[0].header.Get(HeaderEventID) == "" {
+		t.Fatal("ping is missing the event ID header")
+	} else if pings[0].header.Get("X-Renterd-Event-Version") == "" {
+		t.Fatal("ping is missing the event version header")
+	} else if hooks := mgr.Webhooks(""); len(hooks) != 0 {
+		t.Fatal("expected no webhooks, got", hooks)
+	} else if hooks := store.hooks(); len(hooks) != 0 {
+		t.Fatal("expected no stored webhooks, got", hooks)
+	}
+
+	// invalid methods are rejected
+	err := mgr.Test(context.Background(), Webhook{Module: "foo", URL: r.URL, Method: http.MethodGet})
+	if !errors.Is(err, ErrInvalidWebhookMethod) {
+		t.Fatal("unexpected error", err)
+	}
+
+	// receivers that respond with an unexpected status code fail the test
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusTeapot)
+	}))
+	defer srv.Close()
+	err = mgr.Test(context.Background(), Webhook{Module: "foo", URL: srv.URL})
+	var se *statusError
+	if !errors.As(err, &se) || se.statusCode != http.StatusTeapot {
+		t.Fatal("unexpected error", err)
+	}
+
+	// the ping is aborted when the caller's context is cancelled
+	hang := make(chan struct{})
+	stuck := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hang
+	}))
+	defer stuck.Close()
+	defer close(hang)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := mgr.Test(ctx, Webhook{Module: "foo", URL: stuck.URL}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("unexpected error", err)
+	} else if time.Since(start) > webhookTimeout/2 {
+		t.Fatal("ping wasn't aborted", time.Since(start))
+	}
+
+	// internal URLs are rejected unless allowed
+	mgr = newTestManager(t, store, WithAllowInternalURLs(false))
+	if err := mgr.Test(context.Background(), Webhook{Module: "foo", URL: r.URL}); !errors.Is(err, ErrInvalidWebhookURL) {
+		t.Fatal("unexpected error", err)
+	}
+}
+
+func TestManagerWebhooks(t *testing.T) {
+	mgr := newTestManager(t, newTestWebhookStore())
+	r1, r2 := newTestReceiver(t), newTestReceiver(t)
+	lo, hi := r1.URL, r2.URL
+	if hi < lo {
+		lo, hi = hi, lo
+	}
+
+	// register webhooks in reverse order
+	headers := map[string]string{"foo": "bar"}
+	for _, wh := range []Webhook{
+		{Module: "foo", Event: "b", URL: hi, Headers: headers},
+		{Module: "foo", Event: "a", URL: hi, Headers: headers},
+		{Module: "bar", URL: hi, Headers: headers},
+		{Module: "foo", URL: lo, Method: http.MethodPut, Headers: headers},
+	} {
+		if err := mgr.Register(context.Background(), wh); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the webhooks are sorted, have their method set and headers omitted
+	all := []Webhook{
+		{Module: "foo", URL: lo, Method: http.MethodPut},
+		{Module: "bar", URL: hi, Method: http.MethodPost},
+		{Module: "foo", Event: "a", URL: hi, Method: http.MethodPost},
+		{Module: "foo", Event: "b", URL: hi, Method: http.MethodPost},
+	}
+	if hooks := mgr.Webhooks(""); !reflect.DeepEqual(hooks, all) {
+		t.Fatalf("unexpected webhooks %+v", hooks)
+	}
+
+	// filter by module
+	if hooks := mgr.Webhooks("foo"); !reflect.DeepEqual(hooks, []Webhook{all[0], all[2], all[3]}) {
+		t.Fatalf("unexpected webhooks %+v", hooks)
+	} else if hooks := mgr.Webhooks("baz"); len(hooks) != 0 {
+		t.Fatalf("unexpected webhooks %+v", hooks)
+	}
+}
+
+func TestManagerMatchingWebhooks(t *testing.T) {
+	mgr := newTestManager(t, newTestWebhookStore())
+	r := newTestReceiver(t)
+	for _, wh := range []Webhook{
+		{Module: "foo", URL: r.URL},
+		{Module: "foo", Event: "a", URL: r.URL, Headers: map[string]string{"foo": "bar"}},
+		{Module: "bar", URL: r.URL},
+	} {
+		if err := mgr.Register(context.Background(), wh); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		event Event
+		want  []Webhook
+	}{
+		{
+			event: Event{Module: "foo", Event: "a"},
+			want: []Webhook{
+				{Module: "foo", URL: r.URL, Method: http.MethodPost},
+				{Module: "foo", Event: "a", URL: r.URL, Method: http.MethodPost},
+			},
+		},
+		{
+			event: Event{Module: "foo", Event: "b"},
+			want:  []Webhook{{Module: "foo", URL: r.URL, Method: http.MethodPost}},
+		},
+		{
+			event: Event{Module: "baz", Event: "a"},
+		},
+	}
+	for _, test := range tests {
+		if hooks := mgr.MatchingWebhooks(test.event); !reflect.DeepEqual(hooks, test.want) {
+			t.Errorf("%v: unexpected webhooks %+v", test.event, hooks)
+		}
+	}
+
+	// nothing is enqueued
+	if pending := mgr.PendingEvents(r.URL); len(pending) != 0 {
+		t.Fatal("expected no pending events, got", pending)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if received := r.received(); len(received) != 0 {
+		t.Fatal("expected no events, got", received)
+	}
+}
+
+type testWebhookStore struct {
+	mu         sync.Mutex
+	deleteErrs map[string]error
+	webhooks   map[string]Webhook
+}
+
+func newTestWebhookStore() *testWebhookStore {
+	return &testWebhookStore{
+		deleteErrs: make(map[string]error),
+		webhooks:   make(map[string]Webhook),
+	}
+}
+
+func (s *testWebhookStore) AddWebhook(_ context.Context, wh Webhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.webhooks[wh.String()] = wh
+	return nil
+}
+
+func (s *testWebhookStore) DeleteWebhook(_ context.Context, wh Webhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.deleteErrs[wh.String()]; err != nil {
+		return err
+	} else if _, ok := s.webhooks[wh.String()]; !ok {
+		return ErrWebhookNotFound
+	}
+	delete(s.webhooks, wh.String())
+	return nil
+}
+
+func (s *testWebhookStore) Webhooks(context.Context) ([]Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var hooks []Webhook
+	for _, wh := range s.webhooks {
+		hooks = append(hooks, wh)
+	}
+	return hooks, nil
+}
+
+func (s *testWebhookStore) hooks() (hooks []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.webhooks {
+		hooks = append(hooks, key)
+	}
+	sort.Strings(hooks)
+	return
+}
+
+// failDeletes makes deleting the given webhook fail with the given error, nil
+// resets it.
+func (s *testWebhookStore) failDeletes(wh Webhook, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleteErrs[wh.String()] = err
+}
+
+type receivedEvent struct {
+	Event
+	header http.Header
+}
+
+// testReceiver is a webhook receiver that records the events it receives,
+// including pings. Events named 'block' are only acknowledged once the
+// receiver is unblocked, events named 'fail' are rejected with a 503.
+type testReceiver struct {
+	*httptest.Server
+
+	inFlight chan struct{}
+
+	mu        sync.Mutex
+	events    []receivedEvent
+	unblock   chan struct{}
+	unblocked bool
+}
+
+func newTestReceiver(t *testing.T) *testReceiver {
+	t.Helper()
+	r := &testReceiver{
+		inFlight: make(chan struct{}, 100),
+		unblock:  make(chan struct{}),
+	}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.handle))
+	t.Cleanup(func() {
+		r.unblockAll()
+		r.Close()
+	})
+	return r
+}
+
+func (r *testReceiver) handle(w http.ResponseWriter, req *http.Request) {
+	body := io.Reader(req.Body)
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		gr, err := gzip.NewReader(req.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body = gr
+	}
+	var event Event
+	if err := json.NewDecoder(body).Decode(&event); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if event.Event == "block" {
+		r.inFlight <- struct{}{}
+		r.mu.Lock()
+		unblock := r.unblock
+		r.mu.Unlock()
+		<-unblock
+	} else if event.Event == "fail" {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	r.mu.Lock()
+	r.events = append(r.events, receivedEvent{Event: event, header: req.Header.Clone()})
+	r.mu.Unlock()
+}
+
+// received returns the events that were received so far, excluding pings.
+func (r *testReceiver) received() (events []receivedEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range r.events {
+		if e.Event.Event != WebhookEventPing {
+			events = append(events, e)
+		}
+	}
+	return
+}
+
+// pings returns the pings that were received so far.
+func (r *testReceiver) pings() (pings []receivedEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range r.events {
+		if e.Event.Event == WebhookEventPing {
+			pings = append(pings, e)
+		}
+	}
+	return
+}
+
+func (r *testReceiver) unblockAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.unblocked {
+		r.unblocked = true
+		close(r.unblock)
+	}
+}
+
+// waitForEvents waits until the receiver received the given events, excluding
+// pings, in the given order.
+func (r *testReceiver) waitForEvents(t *testing.T, events ...string) {
+	t.Helper()
+	var received []string
+	for i := 0; i < 200; i++ {
+		received = received[:0]
+		for _, e := range r.received() {
+			received = append(received, e.Event.Event)
+		}
+		if reflect.DeepEqual(received, events) {
+			return
+		} else if len(received) > len(events) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected events %v, got %v", events, received)
+}
+
+// waitInFlight waits until a blocking event is in flight.
+func (r *testReceiver) waitInFlight(t *testing.T) {
+	t.Helper()
+	select {
+	case <-r.inFlight:
+	case <-time.After(10 * time.Second):
+		t.Fatal("no event in flight")
+	}
+}
+
+// newTestManager creates a manager that allows internal URLs and is shut down
+// when the test finishes.
+func newTestManager(t *testing.T, store WebhookStore, opts ...ManagerOption) *Manager {
+	t.Helper()
+	mgr, err := NewManager(store, nil, append([]ManagerOption{WithAllowInternalURLs(true)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		mgr.Shutdown(ctx)
+	})
+	return mgr
+}
+
+func broadcast(t *testing.T, mgr *Manager, event Event) {
+	t.Helper()
+	if err := mgr.BroadcastAction(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -348,10 +349,31 @@ func parseObjectResponseHeaders(header http.Header) (api.HeadObjectResponse, err
 	if err != nil {
 		return api.HeadObjectResponse{}, fmt.Errorf("failed to parse Last-Modified header: %w", err)
 	}
+
+	// parse health
+	var health float64
+	if h := header.Get(api.ObjectHealthHeader); h != "" {
+		health, err = strconv.ParseFloat(h, 64)
+		if err != nil {
+			return api.HeadObjectResponse{}, fmt.Errorf("failed to parse %v header: %w", api.ObjectHealthHeader, err)
+		}
+	}
+
+	// parse parts count
+	var partsCount int
+	if pc := header.Get(api.ObjectPartsCountHeader); pc != "" {
+		partsCount, err = strconv.Atoi(pc)
+		if err != nil {
+			return api.HeadObjectResponse{}, fmt.Errorf("failed to parse %v header: %w", api.ObjectPartsCountHeader, err)
+		}
+	}
+
 	return api.HeadObjectResponse{
 		ContentType:  header.Get("Content-Type"),
 		Etag:         trimEtag(header.Get("ETag")),
+		Health:       health,
 		LastModified: api.TimeRFC3339(modTime),
+		PartsCount:   partsCount,
 		Range:        r,
 		Size:         size,
 		Metadata:     api.ExtractObjectUserMetadataFrom(headers),
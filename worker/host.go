@@ -167,12 +167,12 @@ func (h *host) FundAccount(ctx context.Context, desired types.Currency, rev *typ
 	}
 
 	// calculate the deposit amount
-	return h.acc.WithDeposit(func(balance types.Currency) (types.Currency, error) {
+	return h.acc.WithDeposit(func(acc api.Account) (types.Currency, error) {
 		// return early if we have the desired balance
-		if balance.Cmp(desired) >= 0 {
+		deposit := acc.FundingNeeded(desired)
+		if deposit.IsZero() {
 			return types.ZeroCurrency, nil
 		}
-		deposit := desired.Sub(balance)
 
 		// fetch pricetable directly to bypass the gouging check
 		pt, _, err := h.priceTables.fetch(ctx, h.hk, rev)
@@ -200,7 +200,7 @@ func (h *host) FundAccount(ctx context.Context, desired types.Currency, rev *typ
 
 		// log the account balance after funding
 		log.Debugw("fund account succeeded",
-			"balance", balance.ExactString(),
+			"balance", acc.Balance.String(),
 			"deposit", deposit.ExactString(),
 		)
 		return deposit, nil
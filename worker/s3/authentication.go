@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 
 	"go.sia.tech/gofakes3"
 	"go.sia.tech/gofakes3/signature"
@@ -148,6 +149,23 @@ func (b *authenticatedBackend) AuthenticationMiddleware(h http.Handler) http.Han
 			}
 			// authenticated request successfully
 			perms = rootPerms
+		} else if isPresignedRequest(rq) {
+			// signature in query string, verify it against the current keys
+			as, err := b.backend.b.S3AuthenticationSettings(rq.Context())
+			if err != nil {
+				writeResponse(w, signature.APIError{
+					Code:           string(gofakes3.ErrInternal),
+					Description:    fmt.Sprintf("failed to fetch v4 keys: %v", err),
+					HTTPStatusCode: http.StatusInternalServerError,
+				})
+				return
+			}
+			if apiErr, ok := verifyPresignedV4(rq, as.V4Keypairs, time.Now()); !ok {
+				writeResponse(w, apiErr)
+				return
+			}
+			// presigned request verified successfully
+			perms = rootPerms
 		}
 
 		// add permissions to context
@@ -7,7 +7,10 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"go.sia.tech/gofakes3"
 	"go.sia.tech/renterd/api"
@@ -22,6 +25,43 @@ const (
 
 	// maxKeysDefault is the default maxKeys value used in the AWS SDK
 	maxKeysDefault = 1000
+
+	// errCodeServiceUnavailable is the error code for GETs of objects that
+	// are rejected because of their health.
+	errCodeServiceUnavailable gofakes3.ErrorCode = "ServiceUnavailable"
+
+	// unhealthyObjectRetryAfter is the value of the Retry-After header for
+	// GETs of objects that are rejected because of their health.
+	unhealthyObjectRetryAfter = time.Minute
+)
+
+const (
+	// healthRejectedKey is the context key for the flag that marks a GET as
+	// rejected because of the object's health.
+	healthRejectedKey contextKey = 1
+
+	// copyMetadataKeysKey is the context key for the set of metadata headers
+	// that were part of a CopyObject request.
+	copyMetadataKeysKey contextKey = 2
+
+	// objectAttributesKey is the context key for the attributes of the object
+	// requested by a GetObjectAttributes request.
+	objectAttributesKey contextKey = 3
+)
+
+// objectAttributes are the attributes of an object that GetObject fills in
+// for GetObjectAttributes requests instead of downloading the object.
+type objectAttributes struct {
+	found      bool
+	etag       string
+	partsCount int
+	size       int64
+}
+
+const (
+	metadataDirectiveHeader  = "X-Amz-Metadata-Directive"
+	metadataDirectiveCopy    = "COPY"
+	metadataDirectiveReplace = "REPLACE"
 )
 
 var (
@@ -33,6 +73,9 @@ type s3 struct {
 	b      Bus
 	w      Worker
 	logger *zap.SugaredLogger
+	stats  *transferStats
+
+	minHealth float64
 }
 
 // ListBuckets returns a list of all buckets owned by the authenticated
@@ -240,6 +283,24 @@ func (s *s3) DeleteBucket(ctx context.Context, name string) error {
 // TODO: Range requests starting from the end are not supported yet. Backend
 // needs to be updated for that.
 func (s *s3) GetObject(ctx context.Context, bucketName, objectName string, rangeRequest *gofakes3.ObjectRangeRequest) (*gofakes3.Object, error) {
+	// GetObjectAttributes requests only need the object's metadata
+	if attrs, ok := ctx.Value(objectAttributesKey).(*objectAttributes); ok {
+		obj, err := s.HeadObject(ctx, bucketName, objectName)
+		if err != nil {
+			return nil, err
+		}
+		attrs.found = true
+		attrs.etag = hex.EncodeToString(obj.Hash)
+		attrs.size = obj.Size
+		if pc, ok := obj.Metadata[api.ObjectPartsCountHeader]; ok {
+			attrs.partsCount, err = strconv.Atoi(pc)
+			if err != nil {
+				return nil, gofakes3.ErrorMessage(gofakes3.ErrInternal, err.Error())
+			}
+		}
+		return obj, nil
+	}
+
 	if rangeRequest != nil && rangeRequest.FromEnd {
 		return nil, gofakes3.ErrorMessage(gofakes3.ErrNotImplemented, "range request from end not supported")
 	}
@@ -253,6 +314,24 @@ func (s *s3) GetObject(ctx context.Context, bucketName, objectName string, range
 		opts.Range = &api.DownloadRange{Offset: rangeRequest.Start, Length: length}
 	}
 
+	// reject objects that aren't healthy enough before starting a download
+	// that might fail midway
+	if s.minHealth > 0 {
+		hor, err := s.w.HeadObject(ctx, bucketName, objectName, api.HeadObjectOptions{})
+		if utils.IsErr(err, api.ErrBucketNotFound) {
+			return nil, gofakes3.BucketNotFound(bucketName)
+		} else if utils.IsErr(err, api.ErrObjectNotFound) {
+			return nil, gofakes3.KeyNotFound(objectName)
+		} else if err != nil {
+			return nil, gofakes3.ErrorMessage(gofakes3.ErrInternal, err.Error())
+		} else if hor.Health < s.minHealth {
+			if rejected, ok := ctx.Value(healthRejectedKey).(*atomic.Bool); ok {
+				rejected.Store(true)
+			}
+			return nil, gofakes3.ErrorMessage(errCodeServiceUnavailable, fmt.Sprintf("object health %v is below the minimum of %v", hor.Health, s.minHealth))
+		}
+	}
+
 	res, err := s.w.GetObject(ctx, bucketName, objectName, opts)
 	if utils.IsErr(err, api.ErrBucketNotFound) {
 		return nil, gofakes3.BucketNotFound(bucketName)
@@ -279,6 +358,7 @@ func (s *s3) GetObject(ctx context.Context, bucketName, objectName string, range
 	// decorate metadata
 	res.Metadata["Content-Type"] = res.ContentType
 	res.Metadata["Last-Modified"] = res.LastModified.Std().Format(http.TimeFormat)
+	res.Metadata[api.ObjectHealthHeader] = strconv.FormatFloat(res.Health, 'f', -1, 64)
 
 	// etag to bytes
 	etag, err := hex.DecodeString(res.Etag)
@@ -291,7 +371,7 @@ func (s *s3) GetObject(ctx context.Context, bucketName, objectName string, range
 		Name:     gofakes3.URLEncode(objectName),
 		Metadata: res.Metadata,
 		Size:     res.Size,
-		Contents: res.Content,
+		Contents: s.stats.downloadReader(bucketName, res.Content),
 		Range:    objectRange,
 	}, nil
 }
@@ -309,7 +389,9 @@ func (s *s3) HeadObject(ctx context.Context, bucketName, objectName string) (*go
 	res, err := s.w.HeadObject(ctx, bucketName, objectName, api.HeadObjectOptions{
 		IgnoreDelim: true,
 	})
-	if utils.IsErr(err, api.ErrObjectNotFound) {
+	if utils.IsErr(err, api.ErrBucketNotFound) {
+		return nil, gofakes3.BucketNotFound(bucketName)
+	} else if utils.IsErr(err, api.ErrObjectNotFound) {
 		return nil, gofakes3.KeyNotFound(objectName)
 	} else if err != nil {
 		return nil, gofakes3.ErrorMessage(gofakes3.ErrInternal, err.Error())
@@ -324,6 +406,10 @@ func (s *s3) HeadObject(ctx context.Context, bucketName, objectName string) (*go
 	// decorate metadata
 	metadata["Content-Type"] = res.ContentType
 	metadata["Last-Modified"] = res.LastModified.Std().Format(http.TimeFormat)
+	metadata[api.ObjectHealthHeader] = strconv.FormatFloat(res.Health, 'f', -1, 64)
+	if res.PartsCount > 0 {
+		metadata[api.ObjectPartsCountHeader] = strconv.Itoa(res.PartsCount)
+	}
 
 	// etag to bytes
 	hash, err := hex.DecodeString(res.Etag)
@@ -381,9 +467,13 @@ func (s *s3) PutObject(ctx context.Context, bucketName, key string, meta map[str
 		opts.MimeType = ct
 	}
 
-	ur, err := s.w.UploadObject(ctx, input, bucketName, key, opts)
+	// NOTE: the input verifies the body against the Content-MD5 header, if
+	// the digest doesn't match the upload fails before the object is stored
+	ur, err := s.w.UploadObject(ctx, s.stats.uploadReader(bucketName, input), bucketName, key, opts)
 	if utils.IsErr(err, api.ErrBucketNotFound) {
 		return gofakes3.PutObjectResult{}, gofakes3.BucketNotFound(bucketName)
+	} else if utils.IsErr(err, gofakes3.ErrBadDigest) {
+		return gofakes3.PutObjectResult{}, gofakes3.ErrBadDigest
 	} else if err != nil {
 		return gofakes3.PutObjectResult{}, gofakes3.ErrorMessage(gofakes3.ErrInternal, err.Error())
 	}
@@ -415,6 +505,34 @@ func (s *s3) DeleteMulti(ctx context.Context, bucketName string, objects ...stri
 }
 
 func (s *s3) CopyObject(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string, meta map[string]string) (gofakes3.CopyObjectResult, error) {
+	// gofakes3 merges the source's metadata into the request's regardless of
+	// the metadata directive, undo the merge where the directive requires it
+	reqKeys, _ := ctx.Value(copyMetadataKeysKey).(map[string]struct{})
+	switch directive := meta[metadataDirectiveHeader]; directive {
+	case "", metadataDirectiveCopy:
+		// the request's metadata is ignored, if it overrode any of the
+		// source's metadata we fetch the source's metadata again
+		if len(reqKeys) > 0 {
+			src, err := s.HeadObject(ctx, srcBucket, srcKey)
+			if err != nil {
+				return gofakes3.CopyObjectResult{}, err
+			}
+			meta = src.Metadata
+		}
+	case metadataDirectiveReplace:
+		// the request's user metadata replaces the source's, if no content
+		// type is specified the source's content type is kept
+		for k := range meta {
+			if _, ok := reqKeys[http.CanonicalHeaderKey(k)]; !ok && extractMetadataKey(k) != "" {
+				delete(meta, k)
+			}
+		}
+	default:
+		return gofakes3.CopyObjectResult{}, gofakes3.ErrorMessagef(gofakes3.ErrInvalidArgument, "unknown metadata directive %q", directive)
+	}
+
+	// copying an object onto itself only updates its content type and
+	// metadata, the object's slabs are left untouched
 	convertToSiaMetadataHeaders(meta)
 	obj, err := s.b.CopyObject(ctx, srcBucket, dstBucket, "/"+srcKey, "/"+dstKey, api.CopyObjectOptions{
 		MimeType: meta["Content-Type"],
@@ -445,10 +563,12 @@ func (s *s3) CreateMultipartUpload(ctx context.Context, bucket, key string, meta
 }
 
 func (s *s3) UploadPart(ctx context.Context, bucket, object string, id gofakes3.UploadID, partNumber int, contentLength int64, input io.Reader) (*gofakes3.UploadPartResult, error) {
-	res, err := s.w.UploadMultipartUploadPart(ctx, input, bucket, object, string(id), partNumber, api.UploadMultipartUploadPartOptions{
+	res, err := s.w.UploadMultipartUploadPart(ctx, s.stats.uploadReader(bucket, input), bucket, object, string(id), partNumber, api.UploadMultipartUploadPartOptions{
 		ContentLength: contentLength,
 	})
-	if err != nil {
+	if utils.IsErr(err, gofakes3.ErrBadDigest) {
+		return nil, gofakes3.ErrBadDigest
+	} else if err != nil {
 		return nil, gofakes3.ErrorMessage(gofakes3.ErrInternal, err.Error())
 	}
 
@@ -0,0 +1,178 @@
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.sia.tech/gofakes3"
+	"go.sia.tech/gofakes3/signature"
+)
+
+const (
+	presignAlgorithm       = "AWS4-HMAC-SHA256"
+	presignDateFormat      = "20060102T150405Z"
+	presignScopeDateFormat = "20060102"
+	presignUnsignedPayload = "UNSIGNED-PAYLOAD"
+
+	// presignMaxExpiry is the maximum validity of a presigned URL as defined
+	// by AWS.
+	presignMaxExpiry = 7 * 24 * time.Hour
+
+	// presignMaxClockSkew is the amount of time a presigned URL's date is
+	// allowed to be ahead of our own clock.
+	presignMaxClockSkew = 15 * time.Minute
+)
+
+// isPresignedRequest returns true if the request carries a v4 signature in
+// its query string rather than in its Authorization header.
+func isPresignedRequest(rq *http.Request) bool {
+	return rq.URL.Query().Has("X-Amz-Signature")
+}
+
+// verifyPresignedV4 verifies a request that was presigned using v4 query
+// string authentication against the given access key pairs. It returns false
+// alongside the error to write to the client if the request is malformed,
+// expired or its signature doesn't match.
+//
+// see https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-query-string-auth.html
+func verifyPresignedV4(rq *http.Request, keys map[string]string, now time.Time) (signature.APIError, bool) {
+	query := rq.URL.Query()
+
+	// validate the query parameters
+	if query.Get("X-Amz-Algorithm") != presignAlgorithm {
+		return presignQueryError("X-Amz-Algorithm only supports \"%s\"", presignAlgorithm), false
+	}
+	credential := strings.Split(query.Get("X-Amz-Credential"), "/")
+	if len(credential) != 5 || credential[3] != "s3" || credential[4] != "aws4_request" {
+		return presignQueryError("error parsing the X-Amz-Credential parameter; expecting \"<YOUR-AKID>/YYYYMMDD/REGION/s3/aws4_request\""), false
+	}
+	amzDate := query.Get("X-Amz-Date")
+	date, err := time.Parse(presignDateFormat, amzDate)
+	if err != nil {
+		return presignQueryError("X-Amz-Date must be in the ISO8601 Long Format \"yyyyMMdd'T'HHmmss'Z'\""), false
+	} else if date.Format(presignScopeDateFormat) != credential[1] {
+		return presignQueryError("the date in the X-Amz-Credential parameter doesn't match X-Amz-Date"), false
+	}
+	expires, err := strconv.ParseInt(query.Get("X-Amz-Expires"), 10, 64)
+	if err != nil || expires < 1 {
+		return presignQueryError("X-Amz-Expires must be a positive integer"), false
+	} else if expires > int64(presignMaxExpiry/time.Second) {
+		return presignQueryError("X-Amz-Expires must be less than a week (in seconds); that is, the given X-Amz-Expires must be less than 604800 seconds"), false
+	}
+	signedHeaders := query.Get("X-Amz-SignedHeaders")
+	if !containsHeader(signedHeaders, "host") {
+		return presignQueryError("X-Amz-SignedHeaders must contain the host header"), false
+	}
+	secretKey, ok := keys[credential[0]]
+	if !ok {
+		return signature.APIError{
+			Code:           "InvalidAccessKeyId",
+			Description:    "The AWS access key Id you provided does not exist in our records.",
+			HTTPStatusCode: http.StatusForbidden,
+		}, false
+	}
+
+	// check the validity window
+	if now.Add(presignMaxClockSkew).Before(date) {
+		return presignAccessDenied("Request is not valid yet"), false
+	} else if now.After(date.Add(time.Duration(expires) * time.Second)) {
+		return presignAccessDenied("Request has expired"), false
+	}
+
+	// build the canonical request, the signature itself is not part of it
+	var canonicalHeaders strings.Builder
+	for _, header := range strings.Split(signedHeaders, ";") {
+		value := rq.Host
+		if header != "host" {
+			value = strings.Join(rq.Header.Values(header), ",")
+		}
+		canonicalHeaders.WriteString(header + ":" + strings.TrimSpace(value) + "\n")
+	}
+	payload := presignUnsignedPayload
+	if sha := query.Get("X-Amz-Content-Sha256"); sha != "" {
+		payload = sha
+	}
+	query.Del("X-Amz-Signature")
+	canonicalRequest := strings.Join([]string{
+		rq.Method,
+		encodePath(rq.URL.Path),
+		strings.ReplaceAll(query.Encode(), "+", "%20"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payload,
+	}, "\n")
+
+	// compute the expected signature
+	scope := strings.Join(credential[1:], "/")
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{presignAlgorithm, amzDate, scope, hex.EncodeToString(canonicalHash[:])}, "\n")
+	signingKey := []byte("AWS4" + secretKey)
+	for _, part := range credential[1:] {
+		signingKey = sumHMAC(signingKey, []byte(part))
+	}
+	expected := hex.EncodeToString(sumHMAC(signingKey, []byte(stringToSign)))
+	if !hmac.Equal([]byte(expected), []byte(rq.URL.Query().Get("X-Amz-Signature"))) {
+		return signature.APIError{
+			Code:           "SignatureDoesNotMatch",
+			Description:    "The request signature we calculated does not match the signature you provided. Check your key and signing method.",
+			HTTPStatusCode: http.StatusForbidden,
+		}, false
+	}
+	return signature.APIError{}, true
+}
+
+func containsHeader(signedHeaders, header string) bool {
+	for _, h := range strings.Split(signedHeaders, ";") {
+		if h == header {
+			return true
+		}
+	}
+	return false
+}
+
+// encodePath URI-encodes a path the way it's encoded in the canonical request,
+// leaving unreserved characters and slashes untouched.
+func encodePath(path string) string {
+	var sb strings.Builder
+	for _, b := range []byte(path) {
+		if 'A' <= b && b <= 'Z' || 'a' <= b && b <= 'z' || '0' <= b && b <= '9' {
+			sb.WriteByte(b)
+			continue
+		}
+		switch b {
+		case '-', '_', '.', '~', '/':
+			sb.WriteByte(b)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", b)
+		}
+	}
+	return sb.String()
+}
+
+func presignAccessDenied(desc string) signature.APIError {
+	return signature.APIError{
+		Code:           string(gofakes3.ErrAccessDenied),
+		Description:    desc,
+		HTTPStatusCode: http.StatusForbidden,
+	}
+}
+
+func presignQueryError(format string, args ...any) signature.APIError {
+	return signature.APIError{
+		Code:           "AuthorizationQueryParametersError",
+		Description:    fmt.Sprintf(format, args...),
+		HTTPStatusCode: http.StatusBadRequest,
+	}
+}
+
+func sumHMAC(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
@@ -1,12 +1,18 @@
 package s3
 
 import (
+	"bytes"
 	"context"
+	"encoding/xml"
 	"fmt"
 	"io"
 	"math/rand"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"go.sia.tech/gofakes3"
 	"go.sia.tech/renterd/api"
@@ -14,14 +20,134 @@ import (
 	"go.uber.org/zap"
 )
 
-type gofakes3Logger struct {
-	l *zap.SugaredLogger
-}
+type (
+	gofakes3Logger struct {
+		l *zap.SugaredLogger
+	}
+
+	// Handler is the S3 API's http.Handler, it keeps track of the number of
+	// bytes that were transferred through it.
+	Handler struct {
+		http.Handler
+		sweeper *multipartSweeper
+		stats   *transferStats
+	}
+
+	// BucketTransferStats contains the number of bytes that were uploaded to
+	// and downloaded from a bucket through the S3 API.
+	BucketTransferStats struct {
+		Downloaded uint64 `json:"downloaded"`
+		Uploaded   uint64 `json:"uploaded"`
+	}
+
+	transferStats struct {
+		mu      sync.Mutex
+		buckets map[string]BucketTransferStats
+	}
+
+	// countingReader wraps a reader and reports the number of bytes read
+	// through it.
+	countingReader struct {
+		r      io.Reader
+		onRead func(n int)
+	}
+
+	countingReadCloser struct {
+		countingReader
+		c io.Closer
+	}
+
+	// regionHandler wraps the gofakes3 handler and rewrites the responses to
+	// GetBucketLocation requests to report the configured region. gofakes3
+	// always reports an empty location, which clients interpret as
+	// us-east-1.
+	regionHandler struct {
+		h      http.Handler
+		region string
+	}
+
+	// healthHandler wraps the gofakes3 handler and turns the errors for
+	// objects that were rejected because of their health into 503 responses
+	// with a Retry-After header. gofakes3 doesn't allow backends to set the
+	// status code or the headers of an error response.
+	healthHandler struct {
+		h http.Handler
+	}
+
+	// copyHandler wraps the gofakes3 handler and passes the metadata headers
+	// of CopyObject requests to the backend through the request's context.
+	// The backend is only handed the request's metadata merged with the
+	// source's, so it can't apply the metadata directive without them.
+	copyHandler struct {
+		h http.Handler
+	}
+
+	// attributesHandler wraps the gofakes3 handler and serves
+	// GetObjectAttributes requests, which gofakes3 doesn't route. They are
+	// served as GetObject requests for which the backend only fetches the
+	// object's attributes, the response is then replaced by those.
+	attributesHandler struct {
+		h http.Handler
+	}
+
+	// getObjectAttributesResponse is the body of a response to a
+	// GetObjectAttributes request. Only the requested attributes are set.
+	// ObjectParts only contains the part count of objects created by a
+	// multipart upload, the parts themselves aren't kept once the upload
+	// completed. Checksum isn't reported since no checksums are stored.
+	getObjectAttributesResponse struct {
+		XMLName      xml.Name           `xml:"GetObjectAttributesResponse"`
+		Xmlns        string             `xml:"xmlns,attr"`
+		ETag         string             `xml:"ETag,omitempty"`
+		StorageClass string             `xml:"StorageClass,omitempty"`
+		ObjectSize   *int64             `xml:"ObjectSize,omitempty"`
+		ObjectParts  *objectPartsResult `xml:"ObjectParts,omitempty"`
+	}
+
+	// objectPartsResult is the ObjectParts element of a GetObjectAttributes
+	// response. PartsCount is set as well since some clients read it
+	// instead of TotalPartsCount.
+	objectPartsResult struct {
+		PartsCount      int `xml:"PartsCount"`
+		TotalPartsCount int `xml:"TotalPartsCount"`
+	}
+
+	// healthResponseWriter is the http.ResponseWriter used by the
+	// healthHandler, rejected is set by the backend through the request's
+	// context.
+	healthResponseWriter struct {
+		http.ResponseWriter
+		rejected *atomic.Bool
+	}
+
+	// bufferedResponseWriter is a http.ResponseWriter that buffers the
+	// response so it can be modified before it is written.
+	bufferedResponseWriter struct {
+		header http.Header
+		status int
+		body   bytes.Buffer
+	}
+)
 
 type Opts struct {
 	AuthDisabled      bool
 	HostBucketEnabled bool
 	HostBucketBases   []string
+
+	// Region is the region reported for all buckets, if empty no location
+	// constraint is reported.
+	Region string
+
+	// MinObjectHealth is the health an object needs to have to be
+	// downloaded, GETs for objects with a lower health are rejected. A health
+	// of 0 disables the check.
+	MinObjectHealth float64
+
+	// MultipartUploadMaxAge is the age after which incomplete multipart
+	// uploads are aborted, they are checked every MultipartSweepInterval.
+	// Uploads are never aborted if either is 0.
+	MultipartUploadMaxAge  time.Duration
+	MultipartSweepInterval time.Duration
 }
 
 type Bus interface {
@@ -69,12 +195,15 @@ func (l *gofakes3Logger) Print(level gofakes3.LogLevel, v ...interface{}) {
 	}
 }
 
-func New(b Bus, w Worker, logger *zap.Logger, opts Opts) (http.Handler, error) {
+func New(b Bus, w Worker, logger *zap.Logger, opts Opts) (*Handler, error) {
 	logger = logger.Named("s3")
 	s3Backend := &s3{
 		b:      b,
 		w:      w,
 		logger: logger.Sugar(),
+		stats:  &transferStats{buckets: make(map[string]BucketTransferStats)},
+
+		minHealth: opts.MinObjectHealth,
 	}
 	backend := gofakes3.Backend(s3Backend)
 	if !opts.AuthDisabled {
@@ -91,7 +220,214 @@ func New(b Bus, w Worker, logger *zap.Logger, opts Opts) (http.Handler, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create s3 server: %w", err)
 	}
-	return faker.Server(), nil
+	handler := http.Handler(&attributesHandler{h: &copyHandler{h: faker.Server()}})
+	if opts.Region != "" {
+		handler = &regionHandler{h: handler, region: opts.Region}
+	}
+	if opts.MinObjectHealth > 0 {
+		handler = &healthHandler{h: handler}
+	}
+	return &Handler{
+		Handler: handler,
+		sweeper: newMultipartSweeper(b, logger.Sugar(), opts.MultipartSweepInterval, opts.MultipartUploadMaxAge),
+		stats:   s3Backend.stats,
+	}, nil
+}
+
+// Shutdown stops the handler's background tasks.
+func (h *Handler) Shutdown(ctx context.Context) error {
+	return h.sweeper.shutdown(ctx)
+}
+
+// SweepMultipartUploads aborts all multipart uploads that were created before
+// the given cutoff and returns the number of aborted uploads. Usually this
+// happens periodically in the background, see Opts.MultipartUploadMaxAge.
+func (h *Handler) SweepMultipartUploads(ctx context.Context, cutoff time.Time) (int, error) {
+	return h.sweeper.sweep(ctx, cutoff)
+}
+
+func (rh *regionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, ok := r.URL.Query()["location"]; !ok || r.Method != http.MethodGet {
+		rh.h.ServeHTTP(w, r)
+		return
+	}
+
+	// serve the request into a buffer, errors and responses to requests that
+	// weren't routed to GetBucketLocation are passed through untouched
+	bw := &bufferedResponseWriter{header: make(http.Header), status: http.StatusOK}
+	rh.h.ServeHTTP(bw, r)
+	body := bw.body.Bytes()
+
+	var location gofakes3.GetBucketLocation
+	if bw.status == http.StatusOK && xml.Unmarshal(body, &location) == nil {
+		location.LocationConstraint = rh.region
+		if b, err := xml.MarshalIndent(location, "", "  "); err == nil {
+			body = append([]byte(xml.Header), b...)
+			bw.header.Del("Content-Length")
+		}
+	}
+
+	for k, v := range bw.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(bw.status)
+	w.Write(body)
+}
+
+func (ah *attributesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, ok := r.URL.Query()["attributes"]; !ok || r.Method != http.MethodGet {
+		ah.h.ServeHTTP(w, r)
+		return
+	}
+
+	// serve the request as a GetObject request into a buffer, errors are
+	// passed through untouched
+	attrs := new(objectAttributes)
+	bw := &bufferedResponseWriter{header: make(http.Header), status: http.StatusOK}
+	ah.h.ServeHTTP(bw, r.WithContext(context.WithValue(r.Context(), objectAttributesKey, attrs)))
+	body := bw.body.Bytes()
+
+	if bw.status == http.StatusOK && attrs.found {
+		// if no attributes are specified, all supported ones are returned
+		requested := make(map[string]bool)
+		for _, v := range r.Header.Values("X-Amz-Object-Attributes") {
+			for _, attr := range strings.Split(v, ",") {
+				requested[strings.TrimSpace(attr)] = true
+			}
+		}
+		all := len(requested) == 0
+
+		resp := getObjectAttributesResponse{Xmlns: "http://s3.amazonaws.com/doc/2006-03-01/"}
+		if all || requested["ETag"] {
+			resp.ETag = attrs.etag
+		}
+		if all || requested["StorageClass"] {
+			resp.StorageClass = "STANDARD"
+		}
+		if all || requested["ObjectSize"] {
+			resp.ObjectSize = &attrs.size
+		}
+		if (all || requested["ObjectParts"]) && attrs.partsCount > 0 {
+			resp.ObjectParts = &objectPartsResult{
+				PartsCount:      attrs.partsCount,
+				TotalPartsCount: attrs.partsCount,
+			}
+		}
+		if b, err := xml.MarshalIndent(resp, "", "  "); err == nil {
+			body = append([]byte(xml.Header), b...)
+
+			// drop the headers that describe the object's contents, clients
+			// reject responses with an ETag header
+			for k := range bw.header {
+				if k == "Etag" || k == "Accept-Ranges" || k == "Content-Range" || extractMetadataKey(k) != "" {
+					bw.header.Del(k)
+				}
+			}
+			bw.header.Set("Content-Type", "application/xml")
+			bw.header.Set("Content-Length", strconv.Itoa(len(body)))
+		}
+	}
+
+	for k, v := range bw.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(bw.status)
+	w.Write(body)
+}
+
+func (ch *copyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut || r.Header.Get("X-Amz-Copy-Source") == "" {
+		ch.h.ServeHTTP(w, r)
+		return
+	}
+	keys := make(map[string]struct{})
+	for k := range r.Header {
+		if k == "Content-Type" || extractMetadataKey(k) != "" {
+			keys[http.CanonicalHeaderKey(k)] = struct{}{}
+		}
+	}
+	ch.h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), copyMetadataKeysKey, keys)))
+}
+
+func (hh *healthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		hh.h.ServeHTTP(w, r)
+		return
+	}
+	rejected := new(atomic.Bool)
+	ctx := context.WithValue(r.Context(), healthRejectedKey, rejected)
+	hh.h.ServeHTTP(&healthResponseWriter{ResponseWriter: w, rejected: rejected}, r.WithContext(ctx))
+}
+
+func (hw *healthResponseWriter) WriteHeader(statusCode int) {
+	if hw.rejected.Load() {
+		hw.Header().Set("Retry-After", strconv.Itoa(int(unhealthyObjectRetryAfter.Seconds())))
+		statusCode = http.StatusServiceUnavailable
+	}
+	hw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (bw *bufferedResponseWriter) Header() http.Header {
+	return bw.header
+}
+
+func (bw *bufferedResponseWriter) Write(b []byte) (int, error) {
+	return bw.body.Write(b)
+}
+
+func (bw *bufferedResponseWriter) WriteHeader(statusCode int) {
+	bw.status = statusCode
+}
+
+// TransferStats returns the number of bytes that were uploaded and downloaded
+// through the S3 API, broken down by bucket.
+func (h *Handler) TransferStats() map[string]BucketTransferStats {
+	h.stats.mu.Lock()
+	defer h.stats.mu.Unlock()
+	stats := make(map[string]BucketTransferStats, len(h.stats.buckets))
+	for bucket, bs := range h.stats.buckets {
+		stats[bucket] = bs
+	}
+	return stats
+}
+
+// downloadReader returns a reader that adds the bytes read from rc to the
+// bucket's downloaded bytes.
+func (ts *transferStats) downloadReader(bucket string, rc io.ReadCloser) io.ReadCloser {
+	return &countingReadCloser{
+		countingReader: countingReader{r: rc, onRead: func(n int) {
+			ts.mu.Lock()
+			bs := ts.buckets[bucket]
+			bs.Downloaded += uint64(n)
+			ts.buckets[bucket] = bs
+			ts.mu.Unlock()
+		}},
+		c: rc,
+	}
+}
+
+// uploadReader returns a reader that adds the bytes read from r to the
+// bucket's uploaded bytes.
+func (ts *transferStats) uploadReader(bucket string, r io.Reader) io.Reader {
+	return &countingReader{r: r, onRead: func(n int) {
+		ts.mu.Lock()
+		bs := ts.buckets[bucket]
+		bs.Uploaded += uint64(n)
+		ts.buckets[bucket] = bs
+		ts.mu.Unlock()
+	}}
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	if n > 0 {
+		cr.onRead(n)
+	}
+	return n, err
+}
+
+func (crc *countingReadCloser) Close() error {
+	return crc.c.Close()
 }
 
 // Parsev4AuthKeys parses a list of accessKey-secretKey pairs and returns a map
@@ -0,0 +1,131 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.sia.tech/renterd/api"
+	"go.sia.tech/renterd/internal/utils"
+	"go.uber.org/zap"
+)
+
+// multipartUploadsBatchSize is the number of multipart uploads fetched per
+// request while sweeping.
+const multipartUploadsBatchSize = 100
+
+// multipartSweeper aborts multipart uploads that were neither completed nor
+// aborted within a given max age, it mirrors S3's
+// AbortIncompleteMultipartUpload lifecycle rule.
+type multipartSweeper struct {
+	b      Bus
+	logger *zap.SugaredLogger
+
+	interval time.Duration
+	maxAge   time.Duration
+
+	shutdownCtx       context.Context
+	shutdownCtxCancel context.CancelFunc
+	wg                sync.WaitGroup
+}
+
+func newMultipartSweeper(b Bus, logger *zap.SugaredLogger, interval, maxAge time.Duration) *multipartSweeper {
+	ctx, cancel := context.WithCancel(context.Background())
+	ms := &multipartSweeper{
+		b:      b,
+		logger: logger.Named("multipartsweeper"),
+
+		interval: interval,
+		maxAge:   maxAge,
+
+		shutdownCtx:       ctx,
+		shutdownCtxCancel: cancel,
+	}
+	if interval > 0 && maxAge > 0 {
+		ms.wg.Add(1)
+		go func() {
+			defer ms.wg.Done()
+			ms.run()
+		}()
+	}
+	return ms
+}
+
+func (ms *multipartSweeper) run() {
+	t := time.NewTicker(ms.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ms.shutdownCtx.Done():
+			return
+		case <-t.C:
+		}
+
+		aborted, err := ms.sweep(ms.shutdownCtx, time.Now().Add(-ms.maxAge))
+		if err != nil && ms.shutdownCtx.Err() == nil {
+			ms.logger.Errorw("failed to sweep multipart uploads", zap.Error(err))
+		} else if aborted > 0 {
+			ms.logger.Infow("aborted incomplete multipart uploads", "aborted", aborted)
+		}
+	}
+}
+
+// sweep aborts all multipart uploads that were created before the given
+// cutoff and returns the number of aborted uploads.
+func (ms *multipartSweeper) sweep(ctx context.Context, cutoff time.Time) (aborted int, _ error) {
+	buckets, err := ms.b.ListBuckets(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list buckets: %w", err)
+	}
+
+	for _, bucket := range buckets {
+		var expired []api.MultipartUpload
+		var pathMarker, uploadIDMarker string
+		for {
+			resp, err := ms.b.MultipartUploads(ctx, bucket.Name, "", pathMarker, uploadIDMarker, multipartUploadsBatchSize)
+			if err != nil {
+				return aborted, fmt.Errorf("failed to fetch multipart uploads of bucket %v: %w", bucket.Name, err)
+			}
+			for _, upload := range resp.Uploads {
+				if upload.CreatedAt.Std().Before(cutoff) {
+					expired = append(expired, upload)
+				}
+			}
+			if !resp.HasMore {
+				break
+			}
+			pathMarker, uploadIDMarker = resp.NextPathMarker, resp.NextUploadIDMarker
+		}
+
+		// abort the uploads after listing them to not mess with the markers,
+		// uploads that were completed in the meantime are ignored
+		for _, upload := range expired {
+			err := ms.b.AbortMultipartUpload(ctx, bucket.Name, upload.Path, upload.UploadID)
+			if utils.IsErr(err, api.ErrMultipartUploadNotFound) {
+				continue
+			} else if err != nil {
+				return aborted, fmt.Errorf("failed to abort multipart upload %v of bucket %v: %w", upload.UploadID, bucket.Name, err)
+			}
+			aborted++
+		}
+	}
+	return aborted, nil
+}
+
+func (ms *multipartSweeper) shutdown(ctx context.Context) error {
+	ms.shutdownCtxCancel()
+
+	done := make(chan struct{})
+	go func() {
+		ms.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	case <-done:
+		return nil
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 
 	"go.sia.tech/renterd/api"
 )
@@ -56,9 +57,13 @@ func (cr *contentReader) Read(p []byte) (int, error) {
 }
 
 func serveContent(rw http.ResponseWriter, req *http.Request, name string, content io.Reader, hor api.HeadObjectResponse) {
-	// set content type and etag
+	// set content type, etag, health and parts count
 	rw.Header().Set("Content-Type", hor.ContentType)
 	rw.Header().Set("ETag", api.FormatETag(hor.Etag))
+	rw.Header().Set(api.ObjectHealthHeader, strconv.FormatFloat(hor.Health, 'f', -1, 64))
+	if hor.PartsCount > 0 {
+		rw.Header().Set(api.ObjectPartsCountHeader, strconv.Itoa(hor.PartsCount))
+	}
 
 	// set the user metadata headers
 	for k, v := range hor.Metadata {
@@ -1363,7 +1363,9 @@ func (w *Worker) headObject(ctx context.Context, bucket, path string, onlyMetada
 	return &api.HeadObjectResponse{
 		ContentType:  res.Object.MimeType,
 		Etag:         res.Object.ETag,
+		Health:       res.Object.Health,
 		LastModified: res.Object.ModTime,
+		PartsCount:   res.Object.PartsCount,
 		Range:        opts.Range.ContentRange(res.Object.Size),
 		Size:         res.Object.Size,
 		Metadata:     res.Object.Metadata,